@@ -0,0 +1,101 @@
+package main
+
+import (
+        "context"
+        "fmt"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/event"
+        "maunium.net/go/mautrix/id"
+)
+
+// isAdmin reports whether a user is one of the configured admins
+func isAdmin(userID id.UserID) bool {
+        for _, admin := range config().Admins {
+                if id.UserID(admin) == userID {
+                        return true
+                }
+        }
+        return false
+}
+
+// ensureAdmins makes sure every admin is in each log room and has the power level needed for commands
+func ensureAdmins(ctx context.Context, client *mautrix.Client) {
+        if len(config().Admins) == 0 {
+                return
+        }
+        for _, roomID := range logRooms() {
+                inviteAdmins(ctx, client, roomID)
+                promoteAdmins(ctx, client, roomID)
+        }
+}
+
+// inviteAdmins invites the admins that are neither joined nor invited to a room; banned admins are left alone
+func inviteAdmins(ctx context.Context, client *mautrix.Client, roomID id.RoomID) {
+        resp, err := client.Members(ctx, roomID)
+        if err != nil {
+                fmt.Printf("Failed to get members of log room %s: %v\n", roomID, err)
+                return
+        }
+        memberships := make(map[id.UserID]event.Membership)
+        for _, evt := range resp.Chunk {
+                if member := evt.Content.AsMember(); member != nil {
+                        memberships[id.UserID(evt.GetStateKey())] = member.Membership
+                }
+        }
+
+        for _, admin := range config().Admins {
+                switch memberships[id.UserID(admin)] {
+                case event.MembershipJoin, event.MembershipInvite:
+                case event.MembershipBan:
+                        fmt.Printf("Admin %s is banned from log room %s, not inviting\n", admin, roomID)
+                default:
+                        inviteAdmin(ctx, client, roomID, id.UserID(admin))
+                }
+        }
+}
+
+// inviteAdmin invites an admin to a log room
+func inviteAdmin(ctx context.Context, client *mautrix.Client, roomID id.RoomID, admin id.UserID) {
+        fmt.Printf("Inviting admin %s to log room %s\n", admin, roomID)
+        if _, err := client.InviteUser(ctx, roomID, &mautrix.ReqInviteUser{UserID: admin}); err != nil {
+                fmt.Printf("Failed to invite admin %s to log room %s: %v\n", admin, roomID, err)
+        }
+}
+
+// promoteAdmins raises admins below the command power level to it
+func promoteAdmins(ctx context.Context, client *mautrix.Client, roomID id.RoomID) {
+        var powerLevels event.PowerLevelsEventContent
+        if err := roomState(ctx, client, roomID, event.StatePowerLevels, "", &powerLevels); err != nil {
+                fmt.Printf("Failed to read power levels of log room %s: %v\n", roomID, err)
+                return
+        }
+
+        required := commandPowerLevel()
+        changed := false
+        for _, admin := range config().Admins {
+                if powerLevels.GetUserLevel(id.UserID(admin)) < required {
+                        powerLevels.SetUserLevel(id.UserID(admin), required)
+                        changed = true
+                }
+        }
+        if !changed {
+                return
+        }
+
+        fmt.Printf("Granting admins power level %d in log room %s\n", required, roomID)
+        if _, err := client.SendStateEvent(ctx, roomID, event.StatePowerLevels, "", &powerLevels); err != nil {
+                fmt.Printf("Failed to update power levels of log room %s: %v\n", roomID, err)
+        }
+}
+
+// handleAdminMembership re-invites admins who leave or are kicked from a log room
+func handleAdminMembership(ctx context.Context, client *mautrix.Client, evt *event.Event) {
+        admin := id.UserID(evt.GetStateKey())
+        if !isLogRoom(evt.RoomID) || !isAdmin(admin) {
+                return
+        }
+        if member := evt.Content.AsMember(); member != nil && member.Membership == event.MembershipLeave {
+                go inviteAdmin(ctx, client, evt.RoomID, admin)
+        }
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+        "bytes"
+        "context"
+        "encoding/json"
+        "fmt"
+        "net/http"
+        "sort"
+        "strings"
+        "sync"
+        "time"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/id"
+)
+
+// AlertmanagerConfig points at a Prometheus Alertmanager that receives alerts for failing servers
+type AlertmanagerConfig struct {
+        URL    string            `yaml:"url"`    // Alertmanager base URL (e.g. http://localhost:9093), empty disables alerts
+        Labels map[string]string `yaml:"labels"` // Extra labels added to every alert
+        Rooms  map[string]string `yaml:"rooms"`  // Room per receiver name for relayed webhook notifications
+        Filter NotifyFilter      `yaml:"filter"` // Problems sent as alerts, all if empty
+}
+
+// amAlert is an alert in Alertmanager's API format
+type amAlert struct {
+        Labels       map[string]string `json:"labels"`
+        Annotations  map[string]string `json:"annotations,omitempty"`
+        StartsAt     time.Time         `json:"startsAt"`
+        EndsAt       *time.Time        `json:"endsAt,omitempty"`
+        GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+var (
+        alertsMu      sync.Mutex
+        pendingAlerts = make(map[string]amAlert) // Alerts raised during the current cycle
+        firingAlerts  = make(map[string]amAlert) // Alerts sent in the previous cycle
+)
+
+// raiseAlert records a failing or degraded server for the current cycle's alerts
+func raiseAlert(server, roomID, status string) {
+        if config().Alertmanager.URL == "" || !config().Alertmanager.Filter.matches(server, roomID, statusSeverity(status)) {
+                return
+        }
+        alertsMu.Lock()
+        defer alertsMu.Unlock()
+        pendingAlerts[server+" "+roomID] = newAlert(server, roomID, status)
+}
+
+// newAlert builds the alert for a failing or degraded server seen in a room
+func newAlert(server, roomID, status string) amAlert {
+        sev := "warning"
+        if strings.HasPrefix(status, "Failed") {
+                sev = "critical"
+        }
+        labels := map[string]string{
+                "alertname": "MatrixFederationUnhealthy",
+                "server":    server,
+                "room":      roomID,
+                "severity":  sev,
+        }
+        for name, value := range config().Alertmanager.Labels {
+                labels[name] = value
+        }
+        if tenant := tenantOf(id.RoomID(roomID)); tenant != "" {
+                labels["tenant"] = tenant
+                for name, value := range config().Tenants[tenant].AlertLabels {
+                        labels[name] = value
+                }
+        }
+
+        annotations := map[string]string{"summary": formatServerLine(server, status)}
+        if notes := serverNotes(server); len(notes) > 0 {
+                annotations["description"] = strings.Join(notes, "\n")
+        }
+        if impact := serverImpact(server); impact != "" {
+                annotations["impact"] = impact
+        }
+        if runbook := serverOverride(server).Runbook; runbook != "" {
+                annotations["runbook_url"] = runbook
+        }
+
+        return amAlert{
+                Labels:      labels,
+                Annotations: annotations,
+                StartsAt:    time.Now(),
+        }
+}
+
+// flushAlerts sends the current cycle's alerts to Alertmanager, keeping the start time of alerts that were
+// already firing, and resolves those that no longer are. Firing alerts are resent every cycle, as Alertmanager expects,
+// and end three check intervals later, so Alertmanager doesn't resolve them between cycles when its resolve_timeout
+// is shorter than the interval, but does once the monitor stops sending them.
+func flushAlerts(ctx context.Context) {
+        if config().Alertmanager.URL == "" {
+                return
+        }
+
+        alertsMu.Lock()
+        now := time.Now()
+        endsAt := now.Add(3 * cycleInterval())
+        var alerts []amAlert
+        for key, alert := range pendingAlerts {
+                alert.EndsAt = &endsAt
+                if previous, ok := firingAlerts[key]; ok && previous.Labels["severity"] == alert.Labels["severity"] {
+                        alert.StartsAt = previous.StartsAt
+                }
+                pendingAlerts[key] = alert
+                alerts = append(alerts, alert)
+        }
+        for key, alert := range firingAlerts {
+                if _, ok := pendingAlerts[key]; !ok {
+                        alert.EndsAt = &now
+                        alerts = append(alerts, alert)
+                }
+        }
+        firingAlerts, pendingAlerts = pendingAlerts, make(map[string]amAlert)
+        alertsMu.Unlock()
+
+        if len(alerts) == 0 {
+                return
+        }
+        if err := postAlerts(ctx, alerts); err != nil {
+                fmt.Println("Failed to send alerts to Alertmanager:", err)
+        }
+}
+
+// postAlerts posts alerts to Alertmanager's v2 API
+func postAlerts(ctx context.Context, alerts []amAlert) error {
+        body, err := json.Marshal(alerts)
+        if err != nil {
+                return err
+        }
+        req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(config().Alertmanager.URL, "/")+"/api/v2/alerts", bytes.NewReader(body))
+        if err != nil {
+                return err
+        }
+        req.Header.Set("Content-Type", "application/json")
+        client := &http.Client{Timeout: 30 * time.Second}
+        resp, err := client.Do(req)
+        if err != nil {
+                return err
+        }
+        defer resp.Body.Close()
+        if resp.StatusCode != http.StatusOK {
+                return fmt.Errorf("unexpected status %s", resp.Status)
+        }
+        return nil
+}
+
+// amWebhook is the payload of an Alertmanager webhook notification
+type amWebhook struct {
+        Receiver          string            `json:"receiver"`
+        Status            string            `json:"status"`
+        GroupLabels       map[string]string `json:"groupLabels"`
+        CommonLabels      map[string]string `json:"commonLabels"`
+        CommonAnnotations map[string]string `json:"commonAnnotations"`
+        Alerts            []struct {
+                Status      string            `json:"status"`
+                Labels      map[string]string `json:"labels"`
+                Annotations map[string]string `json:"annotations"`
+                StartsAt    time.Time         `json:"startsAt"`
+        } `json:"alerts"`
+}
+
+// handleAlertmanagerWebhook relays Alertmanager notifications into Matrix, e.g. with this receiver:
+// webhook_configs: [{url: "http://host:8080/api/v1/alertmanager", http_config: {authorization: {credentials: <token>}}}]
+func handleAlertmanagerWebhook(ctx context.Context, client *mautrix.Client, w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+                http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+                return
+        }
+        if !authorized(r) {
+                http.Error(w, "unauthorized", http.StatusUnauthorized)
+                return
+        }
+
+        var notification amWebhook
+        if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024*1024)).Decode(&notification); err != nil {
+                http.Error(w, "invalid JSON body", http.StatusBadRequest)
+                return
+        }
+
+        message := formatAlertNotification(notification)
+        var err error
+        if room := config().Alertmanager.Rooms[notification.Receiver]; room != "" {
+                err = sendMessageToRoom(ctx, client, id.RoomID(room), message)
+        } else {
+                err = sendReport(ctx, client, alertSeverity(notification), message)
+        }
+        if err != nil {
+                http.Error(w, "failed to send to Matrix", http.StatusBadGateway)
+                return
+        }
+        w.WriteHeader(http.StatusOK)
+}
+
+// alertSeverity maps a notification's severity label onto the log room severities; resolved alerts are informational
+func alertSeverity(notification amWebhook) severity {
+        if notification.Status == "resolved" {
+                return severityInfo
+        }
+        switch notification.CommonLabels["severity"] {
+        case "critical", "page":
+                return severityCritical
+        case "warning":
+                return severityWarning
+        }
+        return severityInfo
+}
+
+// formatAlertNotification formats a notification like "[FIRING:2] HighLatency (job=node)" followed by a line per alert
+func formatAlertNotification(notification amWebhook) string {
+        var group []string
+        for name, value := range notification.GroupLabels {
+                group = append(group, fmt.Sprintf("%s=%s", name, value))
+        }
+        sort.Strings(group)
+
+        var b strings.Builder
+        fmt.Fprintf(&b, "[%s:%d] %s", strings.ToUpper(notification.Status), len(notification.Alerts), notification.CommonLabels["alertname"])
+        if len(group) > 0 {
+                fmt.Fprintf(&b, " (%s)", strings.Join(group, ", "))
+        }
+        if summary := notification.CommonAnnotations["summary"]; summary != "" {
+                fmt.Fprintf(&b, "\n%s", summary)
+        }
+
+        var lines []string
+        for _, alert := range notification.Alerts {
+                text := alert.Annotations["summary"]
+                if text == "" {
+                        text = alert.Annotations["description"]
+                }
+                if text == "" {
+                        text = alert.Labels["alertname"]
+                }
+                lines = append(lines, fmt.Sprintf("- %s: %s (since %s)", alert.Status, text, alert.StartsAt.Format("2006-01-02 15:04")))
+        }
+        if len(lines) > 0 {
+                fmt.Fprintf(&b, "\n%s", reportLines(lines))
+        }
+        return b.String()
+}
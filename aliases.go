@@ -0,0 +1,84 @@
+package main
+
+import (
+        "context"
+        "errors"
+        "fmt"
+        "sync"
+        "time"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/event"
+        "maunium.net/go/mautrix/id"
+)
+
+var (
+        aliasChecksMu  sync.Mutex
+        lastAliasCheck = make(map[id.RoomID]time.Time) // When each room's aliases were last resolved
+        aliasProblems  = make(map[id.RoomAlias]string) // Aliases currently reported as broken, with the problem
+)
+
+// verifyRoomAliases resolves a monitored room's canonical and alternative aliases through the room directory at most
+// once per alias check interval, reporting aliases that no longer exist or point to another room, and their recovery
+func verifyRoomAliases(ctx context.Context, client *mautrix.Client, roomID id.RoomID, roomDescription string) {
+        if config().AliasCheckInterval <= 0 {
+                return
+        }
+        aliasChecksMu.Lock()
+        due := time.Since(lastAliasCheck[roomID]) >= time.Duration(config().AliasCheckInterval)*time.Second
+        if due {
+                lastAliasCheck[roomID] = time.Now()
+        }
+        aliasChecksMu.Unlock()
+        if !due {
+                return
+        }
+
+        var canonicalAlias struct {
+                Alias      id.RoomAlias   `json:"alias"`
+                AltAliases []id.RoomAlias `json:"alt_aliases"`
+        }
+        if err := roomState(ctx, client, roomID, event.StateCanonicalAlias, "", &canonicalAlias); err != nil {
+                return
+        }
+        aliases := canonicalAlias.AltAliases
+        if canonicalAlias.Alias != "" {
+                aliases = append([]id.RoomAlias{canonicalAlias.Alias}, aliases...)
+        }
+
+        var broken, fixed []string
+        for _, alias := range aliases {
+                problem := ""
+                resp, err := client.ResolveAlias(ctx, alias)
+                switch {
+                case errors.Is(err, mautrix.MNotFound):
+                        problem = "no longer exists"
+                case err != nil:
+                        fmt.Printf("Failed to resolve alias %s of room %s: %v\n", alias, roomID, err)
+                        continue
+                case resp.RoomID != roomID:
+                        problem = fmt.Sprintf("points to another room, %s", resp.RoomID)
+                }
+
+                aliasChecksMu.Lock()
+                previous, wasBroken := aliasProblems[alias]
+                if problem != "" {
+                        aliasProblems[alias] = problem
+                } else {
+                        delete(aliasProblems, alias)
+                }
+                aliasChecksMu.Unlock()
+
+                if problem != "" && problem != previous {
+                        broken = append(broken, fmt.Sprintf("%s %s", alias, problem))
+                } else if problem == "" && wasBroken {
+                        fixed = append(fixed, fmt.Sprintf("%s resolves to the room again", alias))
+                }
+        }
+        if len(broken) > 0 {
+                sendRoomReport(ctx, client, roomID, severityWarning, fmt.Sprintf("Aliases of room %s are broken:\n%s", roomDescription, reportLines(broken)))
+        }
+        if len(fixed) > 0 {
+                sendRoomReport(ctx, client, roomID, severityInfo, fmt.Sprintf("Aliases of room %s recovered:\n%s", roomDescription, reportLines(fixed)))
+        }
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+        "context"
+        "encoding/json"
+        "fmt"
+        "net/http"
+        "strconv"
+        "strings"
+        "sync"
+        "time"
+
+        "maunium.net/go/mautrix"
+)
+
+// APIConfig configures the HTTP API other tools can query
+type APIConfig struct {
+        Listen string `yaml:"listen"` // Address to listen on (e.g. 127.0.0.1:8080), empty disables the API
+        Token  string `yaml:"token"`  // Bearer token required to trigger checks, empty disables triggers
+
+        ReadTokens  []string        `yaml:"read_tokens"`  // Bearer tokens with read-only access
+        RequireAuth bool            `yaml:"require_auth"` // Require a read or admin role for the dashboard and check results too
+        AuthProxy   AuthProxyConfig `yaml:"auth_proxy"`   // Identity headers of an authenticating (e.g. OIDC) reverse proxy
+}
+
+// defaultMaxAge is how old a cached result may be when a request doesn't say
+const defaultMaxAge = 300 * time.Second
+
+// apiCheckResult is the API's view of a server's latest check
+type apiCheckResult struct {
+        Server    string    `json:"server"`
+        Status    string    `json:"status"`
+        Online    bool      `json:"online"`
+        Software  string    `json:"software,omitempty"`
+        Version   string    `json:"version,omitempty"`
+        LatencyMs int64     `json:"latency_ms"`
+        CheckedAt time.Time `json:"checked_at"`
+        Cached    bool      `json:"cached"`
+}
+
+// serverLock serializes on-demand checks of a server, so concurrent requests share one probe
+type serverLock struct {
+        sync.Mutex
+        users int // Requests holding or waiting for the lock, it is dropped when the last one is done
+}
+
+var (
+        serverLocksMu sync.Mutex
+        serverLocks   = make(map[string]*serverLock)
+)
+
+// lockServer takes the on-demand check lock of a server
+func lockServer(server string) {
+        serverLocksMu.Lock()
+        lock, ok := serverLocks[server]
+        if !ok {
+                lock = &serverLock{}
+                serverLocks[server] = lock
+        }
+        lock.users++
+        serverLocksMu.Unlock()
+        lock.Lock()
+}
+
+// unlockServer releases the on-demand check lock of a server, dropping it once no request needs it
+func unlockServer(server string) {
+        serverLocksMu.Lock()
+        lock := serverLocks[server]
+        lock.users--
+        if lock.users == 0 {
+                delete(serverLocks, server)
+        }
+        serverLocksMu.Unlock()
+        lock.Unlock()
+}
+
+// startAPI serves the HTTP API in the background
+func startAPI(ctx context.Context, client *mautrix.Client) {
+        if config().API.Listen == "" {
+                return
+        }
+
+        mux := http.NewServeMux()
+        mux.HandleFunc("/", tenantView(handleDashboard))
+        mux.HandleFunc("/api/v1/check/", tenantView(func(w http.ResponseWriter, r *http.Request, tenant string) {
+                handleCheck(ctx, client, w, r, tenant)
+        }))
+        mux.HandleFunc("/api/v1/servers", tenantView(handleRegistry))
+        mux.HandleFunc("/api/v1/servers/", tenantView(handleRegistry))
+        mux.HandleFunc("/api/v1/stats", readOnly(func(w http.ResponseWriter, r *http.Request) {
+                writeJSON(w, compileStats())
+        }))
+        mux.HandleFunc("/api/v1/silences", handleSilences)
+        mux.HandleFunc("/api/v1/audit", handleAudit)
+        mux.HandleFunc("/_matrix-health/key/v1", handleWebhookKey)
+        mux.HandleFunc("/api/v1/trigger", handleTrigger)
+        mux.HandleFunc("/probe", readOnly(func(w http.ResponseWriter, r *http.Request) {
+                handleProbe(ctx, client, w, r)
+        }))
+        mux.HandleFunc("/api/v1/alertmanager", func(w http.ResponseWriter, r *http.Request) {
+                handleAlertmanagerWebhook(ctx, client, w, r)
+        })
+        go runTriggeredChecks(ctx, client)
+
+        server := &http.Server{Addr: config().API.Listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+        go func() {
+                <-ctx.Done()
+                server.Close()
+        }()
+        go func() {
+                fmt.Printf("API listening on %s\n", config().API.Listen)
+                if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+                        fmt.Println("API server failed:", err)
+                }
+        }()
+}
+
+// handleCheck returns a server's latest result if it is recent enough, and checks the server otherwise,
+// e.g. GET /api/v1/check/example.org?max_age=300. Only monitored servers can be queried, and a tenant only
+// those in its rooms, so the API can't be used to probe arbitrary hosts.
+func handleCheck(ctx context.Context, client *mautrix.Client, w http.ResponseWriter, r *http.Request, tenant string) {
+        if r.Method != http.MethodGet {
+                http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+                return
+        }
+        server := strings.ToLower(strings.TrimPrefix(r.URL.Path, "/api/v1/check/"))
+        if server == "" || strings.Contains(server, "/") {
+                http.Error(w, "expected /api/v1/check/{server}", http.StatusBadRequest)
+                return
+        }
+        if tenant != "" && !tenantServers(tenant)[server] || tenant == "" && !isMonitored(server) {
+                http.NotFound(w, r)
+                return
+        }
+
+        maxAge := defaultMaxAge
+        if value := r.URL.Query().Get("max_age"); value != "" {
+                seconds, err := strconv.Atoi(value)
+                if err != nil || seconds < 0 {
+                        http.Error(w, "max_age must be a number of seconds", http.StatusBadRequest)
+                        return
+                }
+                maxAge = time.Duration(seconds) * time.Second
+        }
+
+        lockServer(server)
+        result, cached := latestResult(server)
+        if !cached || time.Since(result.CheckedAt) > maxAge {
+                checkServer(ctx, client, server)
+                result, _ = latestResult(server)
+                cached = false
+        }
+        unlockServer(server)
+
+        writeJSON(w, apiCheckResult{
+                Server:    server,
+                Status:    result.Status,
+                Online:    result.Online,
+                Software:  result.Software,
+                Version:   result.Version,
+                LatencyMs: result.Latency.Milliseconds(),
+                CheckedAt: result.CheckedAt,
+                Cached:    cached,
+        })
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, value interface{}) {
+        w.Header().Set("Content-Type", "application/json")
+        if err := json.NewEncoder(w).Encode(value); err != nil {
+                fmt.Println("Failed to write API response:", err)
+        }
+}
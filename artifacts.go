@@ -0,0 +1,132 @@
+package main
+
+import (
+        "encoding/json"
+        "fmt"
+        "os"
+        "path/filepath"
+        "strings"
+        "time"
+
+        "maunium.net/go/mautrix/id"
+)
+
+// ArtifactsConfig writes each cycle's results per room as JSON files, for offline processing without the database
+type ArtifactsConfig struct {
+        Dir      string `yaml:"dir"`       // Directory the JSON files are written to, empty disables them
+        KeepDays int    `yaml:"keep_days"` // Days the files are kept, defaults to 7
+}
+
+// roomArtifact is the JSON written for a room after each check cycle
+type roomArtifact struct {
+        RoomID      string           `json:"room_id"`
+        Alias       string           `json:"alias"`
+        Name        string           `json:"name"`
+        CheckedAt   time.Time        `json:"checked_at"`
+        JoinRule    string           `json:"join_rule"`
+        GuestAccess string           `json:"guest_access"`
+        Visibility  string           `json:"visibility"`
+        Servers     []serverArtifact `json:"servers"`
+}
+
+// serverArtifact is a server's result in a room artifact
+type serverArtifact struct {
+        Server    string `json:"server"`
+        Status    string `json:"status"` // Full status, e.g. "Failed (Unreachable)"
+        Class     string `json:"class"`  // OK, Failed, Warning or Maintenance
+        Online    bool   `json:"online"`
+        Software  string `json:"software,omitempty"`
+        Version   string `json:"version,omitempty"`
+        LatencyMs int64  `json:"latency_ms"`
+}
+
+// lastArtifactPrune is when old artifact files were last deleted
+var lastArtifactPrune time.Time
+
+// writeRoomArtifact writes a room's results of this cycle to a timestamped JSON file in the artifacts directory,
+// e.g. 20240101T120000Z-!room_id:example.org.json
+func writeRoomArtifact(roomID id.RoomID, alias, name string, settings roomSettings, servers []string, statuses map[string]string) {
+        if config().Artifacts.Dir == "" {
+                return
+        }
+        now := time.Now().UTC()
+        artifact := roomArtifact{
+                RoomID:      roomID.String(),
+                Alias:       alias,
+                Name:        name,
+                CheckedAt:   now,
+                JoinRule:    settings.JoinRule,
+                GuestAccess: settings.GuestAccess,
+                Visibility:  settings.Visibility,
+                Servers:     []serverArtifact{},
+        }
+        for _, server := range servers {
+                status, ok := statuses[server]
+                if !ok {
+                        continue
+                }
+                entry := serverArtifact{Server: server, Status: status, Class: statusClass(status)}
+                if result, ok := latestResult(server); ok {
+                        entry.Online = result.Online
+                        entry.Software = result.Software
+                        entry.Version = result.Version
+                        entry.LatencyMs = result.Latency.Milliseconds()
+                }
+                artifact.Servers = append(artifact.Servers, entry)
+        }
+
+        data, err := json.MarshalIndent(artifact, "", "  ")
+        if err != nil {
+                fmt.Printf("Failed to encode the results of room %s: %v\n", roomID, err)
+                return
+        }
+        if err := os.MkdirAll(config().Artifacts.Dir, 0o755); err != nil {
+                fmt.Println("Failed to create the artifacts directory:", err)
+                return
+        }
+        // Room IDs are safe in file names apart from path separators
+        fileName := fmt.Sprintf("%s-%s.json", now.Format("20060102T150405Z"), strings.ReplaceAll(roomID.String(), "/", "_"))
+        path := filepath.Join(config().Artifacts.Dir, fileName)
+        // Written under a temporary name first, so readers never see a partial file
+        if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+                fmt.Printf("Failed to write %s: %v\n", path, err)
+                return
+        }
+        if err := os.Rename(path+".tmp", path); err != nil {
+                fmt.Printf("Failed to write %s: %v\n", path, err)
+        }
+}
+
+// pruneArtifacts deletes artifact files older than the keep days, at most once per hour
+func pruneArtifacts() {
+        if config().Artifacts.Dir == "" || time.Since(lastArtifactPrune) < time.Hour {
+                return
+        }
+        lastArtifactPrune = time.Now()
+        keepDays := config().Artifacts.KeepDays
+        if keepDays <= 0 {
+                keepDays = 7
+        }
+        cutoff := time.Now().AddDate(0, 0, -keepDays)
+
+        paths, err := filepath.Glob(filepath.Join(config().Artifacts.Dir, "*.json"))
+        if err != nil {
+                fmt.Println("Failed to list the artifacts:", err)
+                return
+        }
+        pruned := 0
+        for _, path := range paths {
+                info, err := os.Stat(path)
+                if err != nil || !info.ModTime().Before(cutoff) {
+                        continue
+                }
+                if err := os.Remove(path); err != nil {
+                        fmt.Printf("Failed to delete %s: %v\n", path, err)
+                        continue
+                }
+                pruned++
+        }
+        if pruned > 0 {
+                fmt.Printf("Deleted %d artifact files older than %d days\n", pruned, keepDays)
+        }
+}
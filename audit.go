@@ -0,0 +1,112 @@
+package main
+
+import (
+        "fmt"
+        "net/http"
+        "strconv"
+        "strings"
+        "time"
+)
+
+// auditEntry is one administrative action
+type auditEntry struct {
+        Time     time.Time `json:"time"`
+        Actor    string    `json:"actor"`
+        Action   string    `json:"action"`
+        Target   string    `json:"target"`
+        OldValue string    `json:"old_value,omitempty"`
+        NewValue string    `json:"new_value,omitempty"`
+}
+
+// recordAudit stores an administrative action with who did it and what changed
+func recordAudit(actor, action, target, oldValue, newValue string) {
+        if _, err := db.Exec(`INSERT INTO audit_log (ts, actor, action, target, old_value, new_value) VALUES (?, ?, ?, ?, ?, ?)`,
+                time.Now().Unix(), actor, action, target, oldValue, newValue); err != nil {
+                fmt.Printf("Failed to record %s of %s by %s in the audit log: %v\n", action, target, actor, err)
+        }
+}
+
+// recentAudit returns the latest administrative actions, newest first
+func recentAudit(limit int) ([]auditEntry, error) {
+        rows, err := db.Query(`SELECT ts, actor, action, target, old_value, new_value FROM audit_log ORDER BY ts DESC, rowid DESC LIMIT ?`, limit)
+        if err != nil {
+                return nil, err
+        }
+        defer rows.Close()
+
+        var entries []auditEntry
+        for rows.Next() {
+                var entry auditEntry
+                var ts int64
+                if err := rows.Scan(&ts, &entry.Actor, &entry.Action, &entry.Target, &entry.OldValue, &entry.NewValue); err != nil {
+                        return nil, err
+                }
+                entry.Time = time.Unix(ts, 0)
+                entries = append(entries, entry)
+        }
+        return entries, rows.Err()
+}
+
+// apiActor names who made an API request: the proxy's signed-in user, or the token's role
+func apiActor(r *http.Request) string {
+        if header := config().API.AuthProxy.UserHeader; header != "" {
+                if user := r.Header.Get(header); user != "" {
+                        return user
+                }
+        }
+        return "api"
+}
+
+// commandAudit lists the latest administrative actions, e.g. "!audit" or "!audit 50"
+func commandAudit(args []string) string {
+        limit := 20
+        if len(args) == 1 {
+                n, err := strconv.Atoi(args[0])
+                if err != nil || n <= 0 {
+                        return "Usage: !audit [count]"
+                }
+                limit = n
+        } else if len(args) > 1 {
+                return "Usage: !audit [count]"
+        }
+
+        entries, err := recentAudit(limit)
+        if err != nil {
+                return fmt.Sprintf("Failed to read the audit log: %v", err)
+        }
+        if len(entries) == 0 {
+                return "The audit log is empty"
+        }
+        var lines []string
+        for _, entry := range entries {
+                line := fmt.Sprintf("%s %s: %s %s", entry.Time.UTC().Format("2006-01-02 15:04"), entry.Actor, entry.Action, entry.Target)
+                if entry.OldValue != "" || entry.NewValue != "" {
+                        line += fmt.Sprintf(" (%q -> %q)", entry.OldValue, entry.NewValue)
+                }
+                lines = append(lines, line)
+        }
+        return fmt.Sprintf("Latest administrative actions:\n%s", strings.Join(lines, "\n"))
+}
+
+// handleAudit returns the latest administrative actions to admins, e.g. GET /api/v1/audit?limit=100
+func handleAudit(w http.ResponseWriter, r *http.Request) {
+        if !authorized(r) {
+                http.Error(w, "unauthorized", http.StatusUnauthorized)
+                return
+        }
+        limit := 100
+        if value := r.URL.Query().Get("limit"); value != "" {
+                n, err := strconv.Atoi(value)
+                if err != nil || n <= 0 {
+                        http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+                        return
+                }
+                limit = n
+        }
+        entries, err := recentAudit(limit)
+        if err != nil {
+                http.Error(w, err.Error(), http.StatusInternalServerError)
+                return
+        }
+        writeJSON(w, entries)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+        "crypto/subtle"
+        "net/http"
+        "strings"
+)
+
+// AuthProxyConfig trusts the identity headers set by an authenticating reverse proxy, e.g. oauth2-proxy in front
+// of an OIDC provider. Only enable it when the API can't be reached without going through the proxy.
+type AuthProxyConfig struct {
+        UserHeader   string   `yaml:"user_header"`   // Header with the signed-in user (e.g. X-Forwarded-Email), empty disables the proxy login
+        GroupsHeader string   `yaml:"groups_header"` // Header with the user's comma-separated groups (e.g. X-Forwarded-Groups)
+        AdminGroups  []string `yaml:"admin_groups"`  // Groups allowed to use control operations
+        ReadGroups   []string `yaml:"read_groups"`   // Groups allowed read-only access, empty allows every signed-in user
+}
+
+// apiRole is what a request to the API is allowed to do
+type apiRole int
+
+const (
+        roleNone  apiRole = iota
+        roleRead          // Status pages and check results
+        roleAdmin         // Control operations too: triggering checks, silences, relayed notifications
+)
+
+// requestRole returns the role of a request: from its bearer token (api.token is admin, api.read_tokens read-only),
+// or from the identity headers of the authenticating proxy
+func requestRole(r *http.Request) apiRole {
+        if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != "" {
+                if config().API.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(config().API.Token)) == 1 {
+                        return roleAdmin
+                }
+                for _, readToken := range config().API.ReadTokens {
+                        if readToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(readToken)) == 1 {
+                                return roleRead
+                        }
+                }
+        }
+
+        proxy := config().API.AuthProxy
+        if proxy.UserHeader == "" || r.Header.Get(proxy.UserHeader) == "" {
+                return roleNone
+        }
+        groups := make(map[string]bool)
+        if proxy.GroupsHeader != "" {
+                for _, group := range strings.Split(r.Header.Get(proxy.GroupsHeader), ",") {
+                        groups[strings.TrimSpace(group)] = true
+                }
+        }
+        for _, group := range proxy.AdminGroups {
+                if groups[group] {
+                        return roleAdmin
+                }
+        }
+        if len(proxy.ReadGroups) == 0 {
+                return roleRead
+        }
+        for _, group := range proxy.ReadGroups {
+                if groups[group] {
+                        return roleRead
+                }
+        }
+        return roleNone
+}
+
+// authorized reports whether the request may use control operations
+func authorized(r *http.Request) bool {
+        return requestRole(r) == roleAdmin
+}
+
+// readOnly wraps a handler for read access, which requires a read or admin role when api.require_auth is set
+func readOnly(handler http.HandlerFunc) http.HandlerFunc {
+        return func(w http.ResponseWriter, r *http.Request) {
+                if config().API.RequireAuth && requestRole(r) < roleRead {
+                        http.Error(w, "unauthorized", http.StatusUnauthorized)
+                        return
+                }
+                handler(w, r)
+        }
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "regexp"
+        "sort"
+        "strings"
+        "time"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/event"
+        "maunium.net/go/mautrix/id"
+)
+
+// maxBackfillPages bounds how far back the backfill pages through each log room
+const maxBackfillPages = 200
+
+// reportLinePattern matches a server's line in the bot's reports, e.g. "example.org - Failed (Unreachable) [critical]"
+var reportLinePattern = regexp.MustCompile(`^([a-zA-Z0-9.:\[\]-]+) - (OK|Failed|Warning|Maintenance)(?: \(([^)]*)\))?`)
+
+// backfillObservation is a server's status as reported in an earlier log room message
+type backfillObservation struct {
+        class, reason string
+        ts            int64
+}
+
+// backfillHistory reconstructs the check and outage history from the bot's earlier reports in the log rooms,
+// once, so availability doesn't start from zero when moving to the persistent store. Only history older than
+// what the store already has is added; encrypted messages are skipped.
+func backfillHistory(ctx context.Context, client *mautrix.Client) {
+        if !config().Database.Backfill || loadState("backfill_done") != "" {
+                return
+        }
+        cutoff := time.Now().Add(-historyRetention())
+        observations := make(map[string][]backfillObservation)
+        for _, roomID := range logRooms() {
+                messages, err := readOwnReports(ctx, client, roomID, cutoff, observations)
+                if err != nil {
+                        fmt.Printf("Failed to read the history of log room %s: %v\n", roomID, err)
+                        return
+                }
+                fmt.Printf("Read %d earlier reports in log room %s\n", messages, roomID)
+        }
+
+        checks, outages := 0, 0
+        for server, history := range observations {
+                added, opened, err := backfillServer(server, history)
+                if err != nil {
+                        fmt.Printf("Failed to backfill the history of %s: %v\n", server, err)
+                        return
+                }
+                checks += added
+                outages += opened
+        }
+        fmt.Printf("Backfilled %d check results and %d outages of %d servers from the log rooms\n", checks, outages, len(observations))
+        if checks > 0 {
+                if err := restoreResults(); err != nil {
+                        fmt.Println("Failed to load the backfilled results:", err)
+                }
+        }
+        if err := saveState("backfill_done", time.Now().UTC().Format(time.RFC3339)); err != nil {
+                fmt.Println("Failed to save the backfill state:", err)
+        }
+}
+
+// readOwnReports collects the server statuses in the bot's messages in a room back to cutoff, returning the number
+// of messages with at least one status
+func readOwnReports(ctx context.Context, client *mautrix.Client, roomID id.RoomID, cutoff time.Time, observations map[string][]backfillObservation) (int, error) {
+        filter := &mautrix.FilterPart{
+                Senders: []id.UserID{client.UserID},
+                Types:   []event.Type{event.EventMessage},
+        }
+        messages := 0
+        from := ""
+        for page := 0; page < maxBackfillPages; page++ {
+                resp, err := client.Messages(ctx, roomID, from, "", mautrix.DirectionBackward, filter, 100)
+                if err != nil {
+                        return messages, err
+                }
+                for _, evt := range resp.Chunk {
+                        if time.UnixMilli(evt.Timestamp).Before(cutoff) {
+                                return messages, nil
+                        }
+                        if evt.Sender != client.UserID || evt.Type != event.EventMessage {
+                                continue
+                        }
+                        _ = evt.Content.ParseRaw(evt.Type)
+                        content := evt.Content.AsMessage()
+                        if content == nil {
+                                continue
+                        }
+                        found := false
+                        for _, line := range strings.Split(content.Body, "\n") {
+                                match := reportLinePattern.FindStringSubmatch(strings.TrimSpace(line))
+                                if match == nil {
+                                        continue
+                                }
+                                server, class, reason := strings.ToLower(match[1]), match[2], match[3]
+                                if strings.HasSuffix(server, ".invalid") {
+                                        continue // Test and canary alerts
+                                }
+                                if class == "OK" {
+                                        reason = "" // "(was Failed)" on recovered servers
+                                }
+                                observations[server] = append(observations[server], backfillObservation{class: class, reason: reason, ts: evt.Timestamp / 1000})
+                                found = true
+                        }
+                        if found {
+                                messages++
+                        }
+                }
+                if resp.End == "" || len(resp.Chunk) == 0 {
+                        break
+                }
+                from = resp.End
+        }
+        return messages, nil
+}
+
+// backfillServer stores a server's reported statuses older than its first stored check, and the outages they show.
+// It returns the number of check results and outages added.
+func backfillServer(server string, history []backfillObservation) (int, int, error) {
+        var first int64
+        if err := db.QueryRow(`SELECT COALESCE(MIN(ts), 0) FROM checks WHERE server = ?`, server).Scan(&first); err != nil {
+                return 0, 0, err
+        }
+        var firstOutage int64
+        if err := db.QueryRow(`SELECT COALESCE(MIN(started), 0) FROM outages WHERE server = ?`, server).Scan(&firstOutage); err != nil {
+                return 0, 0, err
+        }
+        sort.Slice(history, func(i, j int) bool { return history[i].ts < history[j].ts })
+
+        tx, err := db.Begin()
+        if err != nil {
+                return 0, 0, err
+        }
+        defer tx.Rollback()
+
+        checks, outages := 0, 0
+        var started int64
+        for _, obs := range history {
+                if first != 0 && obs.ts >= first {
+                        break
+                }
+                if _, err := tx.Exec(`INSERT INTO checks (server, room, status, reason, latency_ms, ts) VALUES (?, '', ?, ?, 0, ?)`,
+                        server, obs.class, obs.reason, obs.ts); err != nil {
+                        return 0, 0, err
+                }
+                checks++
+
+                // Outages open on the first failure and close on the first answer, as in trackOutage
+                switch {
+                case obs.class == "Failed" && started == 0:
+                        started = obs.ts
+                case (obs.class == "OK" || obs.class == "Warning") && started != 0:
+                        if firstOutage == 0 || obs.ts < firstOutage {
+                                if _, err := tx.Exec(`INSERT INTO outages (server, started, ended) VALUES (?, ?, ?)`, server, started, obs.ts); err != nil {
+                                        return 0, 0, err
+                                }
+                                outages++
+                        }
+                        started = 0
+                }
+        }
+        // An outage still open at the end of the reports is only kept when nothing newer is known about the server
+        if started != 0 && first == 0 && firstOutage == 0 {
+                if _, err := tx.Exec(`INSERT INTO outages (server, started) VALUES (?, ?)`, server, started); err != nil {
+                        return 0, 0, err
+                }
+                outages++
+        }
+        return checks, outages, tx.Commit()
+}
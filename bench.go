@@ -0,0 +1,132 @@
+package main
+
+import (
+        "context"
+        "flag"
+        "fmt"
+        "sort"
+        "strings"
+        "sync"
+        "time"
+)
+
+// benchStats collects the outcome of the probes of a benchmark
+type benchStats struct {
+        mu        sync.Mutex
+        latencies []time.Duration
+        errors    map[string]int // Failed probes by reason
+}
+
+// add records the outcome of one probe
+func (s *benchStats) add(result probeResult) {
+        s.mu.Lock()
+        defer s.mu.Unlock()
+        switch {
+        case result.Online:
+                s.latencies = append(s.latencies, result.Latency)
+        case result.CertProblem != "":
+                s.errors[result.CertProblem]++
+        case result.Redirect != "":
+                s.errors["Redirected to "+result.Redirect]++
+        default:
+                s.errors["Unreachable"]++
+        }
+}
+
+// runBench probes one server's federation endpoint over and over with the given concurrency for the given duration,
+// and prints the latency distribution and error rate; it returns the exit code
+func runBench(args []string) int {
+        flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+        concurrency := flags.Int("c", 10, "number of concurrent probes")
+        duration := flags.Duration("d", 10*time.Second, "how long to keep probing")
+        if err := flags.Parse(args); err != nil || flags.NArg() != 1 || *concurrency < 1 || *duration <= 0 {
+                fmt.Println("usage: matrix-health bench [-c 10] [-d 10s] <server>")
+                return 2
+        }
+        if err := loadValidConfig(); err != nil {
+                fmt.Println("Configuration error:", err)
+                return 2
+        }
+        server := strings.ToLower(flags.Arg(0))
+        if isOnion(server) && config().TorProxy == "" {
+                fmt.Println("Cannot benchmark an onion service without tor_proxy")
+                return 2
+        }
+
+        // Resolve the delegation once, so the benchmark measures the endpoint and not the DNS
+        candidates, err := resolveMatrixServer(context.Background(), server)
+        if err != nil {
+                fmt.Printf("Failed to resolve %s: %v\n", server, err)
+                return 2
+        }
+        ctx := context.Background()
+        path, timeout := probePath(server), probeTimeout(server)
+        host := ""
+        for _, candidate := range candidates {
+                if checkServerOnline(ctx, candidate, path, timeout).Online {
+                        host = candidate
+                        break
+                }
+        }
+        if host == "" {
+                fmt.Printf("%s doesn't answer on %s\n", server, strings.Join(candidates, ", "))
+                return 2
+        }
+
+        fmt.Printf("Benchmarking https://%s%s with %d concurrent probes for %s\n", host, path, *concurrency, *duration)
+        stats := &benchStats{errors: make(map[string]int)}
+        ctx, cancel := context.WithTimeout(ctx, *duration)
+        defer cancel()
+        start := time.Now()
+        var wg sync.WaitGroup
+        for i := 0; i < *concurrency; i++ {
+                wg.Add(1)
+                go func() {
+                        defer wg.Done()
+                        for ctx.Err() == nil {
+                                result := checkServerOnline(ctx, host, path, timeout)
+                                // Probes cut off by the end of the benchmark don't count as errors
+                                if !result.Online && ctx.Err() != nil {
+                                        return
+                                }
+                                stats.add(result)
+                        }
+                }()
+        }
+        wg.Wait()
+        printBenchStats(stats, time.Since(start))
+        return 0
+}
+
+// printBenchStats prints the request rate, latency percentiles and errors of a benchmark
+func printBenchStats(stats *benchStats, elapsed time.Duration) {
+        failed := 0
+        for _, count := range stats.errors {
+                failed += count
+        }
+        total := len(stats.latencies) + failed
+        if total == 0 {
+                fmt.Println("No probes completed")
+                return
+        }
+        fmt.Printf("%d probes in %s (%.1f/s), %d failed (%.1f%%)\n", total, elapsed.Round(time.Millisecond),
+                float64(total)/elapsed.Seconds(), failed, 100*float64(failed)/float64(total))
+
+        if latencies := stats.latencies; len(latencies) > 0 {
+                sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+                percentile := func(p float64) time.Duration {
+                        return latencies[int(p*float64(len(latencies)-1))].Round(time.Microsecond * 100)
+                }
+                fmt.Printf("Latency: min %s, p50 %s, p90 %s, p95 %s, p99 %s, max %s\n", percentile(0), percentile(0.50),
+                        percentile(0.90), percentile(0.95), percentile(0.99), percentile(1))
+        }
+
+        reasons := make([]string, 0, len(stats.errors))
+        for reason := range stats.errors {
+                reasons = append(reasons, reason)
+        }
+        sort.Slice(reasons, func(i, j int) bool { return stats.errors[reasons[i]] > stats.errors[reasons[j]] })
+        for _, reason := range reasons {
+                fmt.Printf("  %s: %d\n", reason, stats.errors[reason])
+        }
+}
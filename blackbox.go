@@ -0,0 +1,63 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "net/http"
+        "strconv"
+        "strings"
+        "time"
+
+        "maunium.net/go/mautrix"
+)
+
+// handleProbe probes a single server the way blackbox_exporter's /probe endpoint does, so existing scrape
+// configs (relabeling __param_target) and dashboards work unchanged. The result is not recorded in the history,
+// as scrapes would skew the availability figures.
+func handleProbe(ctx context.Context, client *mautrix.Client, w http.ResponseWriter, r *http.Request) {
+        target := strings.ToLower(r.URL.Query().Get("target"))
+        if target == "" || strings.ContainsAny(target, "/ ") {
+                http.Error(w, "target parameter is missing or invalid", http.StatusBadRequest)
+                return
+        }
+
+        start := time.Now()
+        // The probe stops when the scraper gives up
+        release, err := probeLimiter.acquire(r.Context())
+        if err != nil {
+                return
+        }
+        status, result := probeServer(r.Context(), client, target)
+        release()
+        duration := time.Since(start)
+
+        success := 0
+        if strings.HasPrefix(status, "OK") || strings.HasPrefix(status, "Warning") {
+                success = 1
+        }
+        warning := 0
+        if strings.HasPrefix(status, "Warning") {
+                warning = 1
+        }
+
+        var b strings.Builder
+        writeMetric := func(name, labels, help, value string) {
+                fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s%s %s\n", name, help, name, name, labels, value)
+        }
+        writeMetric("probe_success", "", "Displays whether or not the probe was a success", strconv.Itoa(success))
+        writeMetric("probe_duration_seconds", "", "Returns how long the probe took to complete in seconds", formatSeconds(duration))
+        writeMetric("probe_matrix_federation_latency_seconds", "", "Duration of the federation version request", formatSeconds(result.Latency))
+        writeMetric("probe_matrix_warning", "", "Whether the server answered but looks misconfigured", strconv.Itoa(warning))
+        if result.Software != "" {
+                writeMetric("probe_matrix_server_info", fmt.Sprintf("{software=%q,version=%q}", result.Software, result.Version),
+                        "Server software reported by the federation version endpoint", "1")
+        }
+
+        w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+        fmt.Fprint(w, b.String())
+}
+
+// formatSeconds formats a duration as seconds for Prometheus
+func formatSeconds(d time.Duration) string {
+        return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
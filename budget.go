@@ -0,0 +1,107 @@
+package main
+
+import (
+        "context"
+        "sort"
+        "sync"
+        "time"
+)
+
+// probePacer spreads probes evenly over time, at most probes_per_minute, so checking a large federation
+// doesn't saturate the uplink or look like a scan to the probed servers
+type probePacer struct {
+        mu       sync.Mutex
+        next     time.Time
+        interval time.Duration
+}
+
+var pacer *probePacer
+
+// initProbeBudget sets up probe pacing from the configuration
+func initProbeBudget() {
+        if config().Limits.ProbesPerMinute > 0 {
+                pacer = &probePacer{interval: time.Minute / time.Duration(config().Limits.ProbesPerMinute)}
+        }
+}
+
+// wait blocks until the next probe may start
+func (p *probePacer) wait(ctx context.Context) error {
+        if p == nil {
+                return nil
+        }
+        p.mu.Lock()
+        now := time.Now()
+        if p.next.Before(now) {
+                p.next = now
+        }
+        start := p.next
+        p.next = start.Add(p.interval)
+        p.mu.Unlock()
+        return sleepContext(ctx, time.Until(start))
+}
+
+// probeBudget shares the probes of one check cycle fairly between the monitored rooms: each room gets an even share
+// of what is left, so a huge room can't starve the others, and what a room doesn't need goes to the rooms after it
+type probeBudget struct {
+        mu        sync.Mutex // Rooms checked at once plan their probes concurrently
+        remaining int
+        roomsLeft int
+        probed    map[string]bool // Servers probed this cycle, whose result other rooms reuse
+}
+
+// newProbeBudget returns the budget for a cycle over the given number of rooms, or nil if probes are unlimited
+func newProbeBudget(rooms int) *probeBudget {
+        if config().Limits.ProbesPerMinute <= 0 {
+                return nil
+        }
+        perCycle := config().Limits.ProbesPerMinute * config().Interval / 60
+        if perCycle < 1 {
+                perCycle = 1
+        }
+        return &probeBudget{remaining: perCycle, roomsLeft: rooms, probed: make(map[string]bool)}
+}
+
+// plan picks the servers of a room to probe now, using the room's share of the budget. Servers not checked for the
+// longest go first, so every server gets its turn over the following cycles; the others keep their latest result.
+// Without a budget every server is probed.
+func (b *probeBudget) plan(servers []string) map[string]bool {
+        probe := make(map[string]bool)
+        if b == nil {
+                for _, server := range servers {
+                        probe[server] = true
+                }
+                return probe
+        }
+
+        b.mu.Lock()
+        defer b.mu.Unlock()
+        share := b.remaining
+        if b.roomsLeft > 1 {
+                share = (b.remaining + b.roomsLeft - 1) / b.roomsLeft
+        }
+        b.roomsLeft--
+
+        var candidates []string
+        lastChecked := make(map[string]time.Time)
+        for _, server := range servers {
+                if b.probed[server] || isIgnored(server) {
+                        continue
+                }
+                candidates = append(candidates, server)
+                if result, ok := latestResult(server); ok {
+                        lastChecked[server] = result.CheckedAt
+                }
+        }
+        sort.SliceStable(candidates, func(i, j int) bool {
+                return lastChecked[candidates[i]].Before(lastChecked[candidates[j]])
+        })
+        if len(candidates) > share {
+                candidates = candidates[:share]
+        }
+        for _, server := range candidates {
+                probe[server] = true
+                b.probed[server] = true
+        }
+        b.remaining -= len(candidates)
+        return probe
+}
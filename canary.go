@@ -0,0 +1,84 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "time"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/event"
+        "maunium.net/go/mautrix/id"
+)
+
+// canaryServer is the made-up server named in canary alerts; .invalid can never be a real server
+const canaryServer = "canary.matrix-health.invalid"
+
+// canaryFailing is whether the last canary alert wasn't delivered, so a broken path is reported once
+var canaryFailing bool
+
+// startAlertCanary sends a canary alert every canary_interval seconds in the background
+func startAlertCanary(ctx context.Context, client *mautrix.Client) {
+        if config().CanaryInterval <= 0 {
+                return
+        }
+        go func() {
+                ticker := time.NewTicker(time.Duration(config().CanaryInterval) * time.Second)
+                defer ticker.Stop()
+                for {
+                        select {
+                        case <-ctx.Done():
+                                return
+                        case <-ticker.C:
+                                checkAlertCanary(ctx, client)
+                        }
+                }
+        }()
+}
+
+// checkAlertCanary sends a made-up failure the way a real one is formatted and routed, reads it back from the log
+// room, and reports the alerting path breaking or working again through the other log rooms and the fallback webhook
+func checkAlertCanary(ctx context.Context, client *mautrix.Client) {
+        target := logRoomFor(severityCritical)
+        err := sendCanary(ctx, client, target)
+        if ctx.Err() != nil {
+                return
+        }
+
+        switch {
+        case err != nil && !canaryFailing:
+                canaryFailing = true
+                message := fmt.Sprintf("Alerts are not being delivered: the canary alert failed: %v", err)
+                reportElsewhere(ctx, client, target, severityCritical, message)
+                notifyFallback(ctx, fmt.Sprintf("matrix-health: %s (log room %s)", message, target))
+        case err == nil && canaryFailing:
+                canaryFailing = false
+                message := "Alerts are delivered again, the canary alert went through"
+                reportElsewhere(ctx, client, target, severityInfo, message)
+                notifyFallback(ctx, fmt.Sprintf("matrix-health: %s (log room %s)", message, target))
+        case err != nil:
+                fmt.Println("Canary alert still failing:", err)
+        }
+}
+
+// sendCanary posts a canary alert to a log room and makes sure the homeserver hands it back intact
+func sendCanary(ctx context.Context, client *mautrix.Client, roomID id.RoomID) error {
+        message := fmt.Sprintf("[CANARY] Alerting path check, no action needed:\n%s", formatServerLine(canaryServer, "Failed (Canary alert)"))
+        eventID, err := sendMessageContent(ctx, client, roomID, &event.MessageEventContent{MsgType: msgTypeFor(roomID), Body: message})
+        if err != nil {
+                return fmt.Errorf("failed to send: %w", err)
+        }
+
+        evt, err := client.GetEvent(ctx, roomID, eventID)
+        switch {
+        case err != nil:
+                return fmt.Errorf("failed to read back %s: %w", eventID, err)
+        case evt.Sender != client.UserID:
+                return fmt.Errorf("read back %s from %s instead of the bot", eventID, evt.Sender)
+        case evt.Unsigned.RedactedBecause != nil:
+                return fmt.Errorf("%s was redacted", eventID)
+        }
+        if config().Verbose {
+                fmt.Printf("Canary alert %s delivered to %s\n", eventID, roomID)
+        }
+        return nil
+}
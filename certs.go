@@ -0,0 +1,98 @@
+package main
+
+import (
+        "context"
+        "crypto/x509"
+        "errors"
+        "fmt"
+        "sort"
+        "sync"
+        "time"
+
+        "maunium.net/go/mautrix"
+)
+
+// CertsConfig controls warnings about the TLS certificates of federation endpoints
+type CertsConfig struct {
+        WarnDays int `yaml:"warn_days"` // Warn about certificates expiring within this many days, defaults to 14 (-1 = only expired or untrusted ones)
+}
+
+// certWarningInterval is how often the same server's certificate is warned about
+const certWarningInterval = 24 * time.Hour
+
+// certStatus is what the latest probe learned about a server's certificate
+type certStatus struct {
+        expiry  time.Time
+        problem string
+}
+
+var (
+        certsMu      sync.Mutex
+        certStatuses = make(map[string]certStatus) // Latest certificate of each server
+        certWarned   = make(map[string]time.Time)  // When each server's certificate was last warned about
+)
+
+// certProblem describes why a probe's TLS handshake rejected the server's certificate, or "" if it wasn't that
+func certProblem(err error) string {
+        var invalid x509.CertificateInvalidError
+        if errors.As(err, &invalid) && invalid.Reason == x509.Expired {
+                return "Certificate expired"
+        }
+        var unknownAuthority x509.UnknownAuthorityError
+        if errors.As(err, &unknownAuthority) {
+                if cert := unknownAuthority.Cert; cert != nil && cert.Issuer.String() == cert.Subject.String() {
+                        return "Self-signed certificate"
+                }
+                return "Untrusted certificate"
+        }
+        var hostname x509.HostnameError
+        if errors.As(err, &hostname) {
+                return "Certificate for another host"
+        }
+        return ""
+}
+
+// recordCertificate remembers the certificate seen by a server's latest probe
+func recordCertificate(server string, result probeResult) {
+        if result.CertExpiry.IsZero() && result.CertProblem == "" {
+                return
+        }
+        certsMu.Lock()
+        defer certsMu.Unlock()
+        certStatuses[server] = certStatus{expiry: result.CertExpiry, problem: result.CertProblem}
+}
+
+// reportCertificates warns about certificates expiring within the warning days, expired or untrusted,
+// mentioning each server at most once per warning interval
+func reportCertificates(ctx context.Context, client *mautrix.Client) {
+        warnDays := config().Certs.WarnDays
+        if warnDays == 0 {
+                warnDays = 14
+        }
+        now := time.Now()
+
+        var lines []string
+        certsMu.Lock()
+        for server, cert := range certStatuses {
+                if isIgnored(server) || isSilenced(server) || now.Sub(certWarned[server]) < certWarningInterval {
+                        continue
+                }
+                var line string
+                switch {
+                case cert.problem != "":
+                        line = fmt.Sprintf("%s - %s", server, cert.problem)
+                case warnDays > 0 && cert.expiry.Before(now.AddDate(0, 0, warnDays)):
+                        line = fmt.Sprintf("%s - expires %s (in %d days)", server, cert.expiry.UTC().Format("2006-01-02"), int(cert.expiry.Sub(now).Hours()/24))
+                default:
+                        continue
+                }
+                certWarned[server] = now
+                lines = append(lines, line)
+        }
+        certsMu.Unlock()
+        if len(lines) == 0 {
+                return
+        }
+        sort.Strings(lines)
+        sendReport(ctx, client, severityCert, fmt.Sprintf("TLS certificate problems:\n%s", reportLines(lines)))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+        "fmt"
+        "io"
+        "math/rand"
+        "net/http"
+        "strings"
+        "time"
+)
+
+// ChaosConfig injects faults into the bot's own HTTP traffic, to try out retries, re-logins and
+// alert suppression without breaking real infrastructure. Never enable it in production.
+type ChaosConfig struct {
+        Enabled bool `yaml:"enabled"`
+
+        HomeserverFailureRate float64 `yaml:"homeserver_failure_rate"` // Fraction of homeserver requests failing with a network error
+        HomeserverOutage      int     `yaml:"homeserver_outage"`       // Seconds the homeserver is unreachable in each outage period (0 = no outages)
+        HomeserverOutageEvery int     `yaml:"homeserver_outage_every"` // Seconds between the starts of simulated homeserver outages, defaults to 600
+        UnknownTokenRate      float64 `yaml:"unknown_token_rate"`      // Fraction of homeserver requests rejected with M_UNKNOWN_TOKEN
+        ProbeFailureRate      float64 `yaml:"probe_failure_rate"`      // Fraction of federation probes failing with a network error
+        Latency               int     `yaml:"latency"`                 // Milliseconds added to every request
+}
+
+// chaosStart is when fault injection began, the reference for simulated outage periods
+var chaosStart = time.Now()
+
+// chaosTransport wraps a transport and makes requests fail as configured
+type chaosTransport struct {
+        next       http.RoundTripper
+        homeserver bool // Whether the transport talks to the homeserver rather than probed servers
+}
+
+// newChaosTransport wraps a transport with fault injection if chaos testing is enabled
+func newChaosTransport(next http.RoundTripper, homeserver bool) http.RoundTripper {
+        if !config().Chaos.Enabled {
+                return next
+        }
+        if next == nil {
+                next = http.DefaultTransport
+        }
+        return &chaosTransport{next: next, homeserver: homeserver}
+}
+
+// RoundTrip delays the request and fails it or passes it on
+func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+        if latency := time.Duration(config().Chaos.Latency) * time.Millisecond; latency > 0 {
+                select {
+                case <-req.Context().Done():
+                        return nil, req.Context().Err()
+                case <-time.After(latency):
+                }
+        }
+
+        if !t.homeserver {
+                if rand.Float64() < config().Chaos.ProbeFailureRate {
+                        return nil, fmt.Errorf("chaos: simulated network failure reaching %s", req.URL.Host)
+                }
+                return t.next.RoundTrip(req)
+        }
+
+        if homeserverOutage(time.Now()) {
+                return nil, fmt.Errorf("chaos: simulated homeserver outage")
+        }
+        if rand.Float64() < config().Chaos.HomeserverFailureRate {
+                return nil, fmt.Errorf("chaos: simulated network failure reaching the homeserver")
+        }
+        // Login requests can't be rejected for their token
+        if !strings.HasSuffix(req.URL.Path, "/login") && rand.Float64() < config().Chaos.UnknownTokenRate {
+                return &http.Response{
+                        Status:     "401 Unauthorized",
+                        StatusCode: http.StatusUnauthorized,
+                        Proto:      req.Proto,
+                        ProtoMajor: req.ProtoMajor,
+                        ProtoMinor: req.ProtoMinor,
+                        Header:     http.Header{"Content-Type": []string{"application/json"}},
+                        Body:       io.NopCloser(strings.NewReader(`{"errcode":"M_UNKNOWN_TOKEN","error":"chaos: simulated expired access token"}`)),
+                        Request:    req,
+                }, nil
+        }
+        return t.next.RoundTrip(req)
+}
+
+// homeserverOutage reports whether a simulated homeserver outage is going on
+func homeserverOutage(now time.Time) bool {
+        outage := time.Duration(config().Chaos.HomeserverOutage) * time.Second
+        if outage <= 0 {
+                return false
+        }
+        every := time.Duration(config().Chaos.HomeserverOutageEvery) * time.Second
+        if every <= 0 {
+                every = 10 * time.Minute
+        }
+        return now.Sub(chaosStart)%every < outage
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+        "context"
+        "encoding/csv"
+        "encoding/json"
+        "flag"
+        "fmt"
+        "io"
+        "os"
+        "sort"
+        "strconv"
+        "strings"
+        "time"
+
+        "maunium.net/go/mautrix"
+)
+
+// buildVersion is the release, set at build time with -ldflags "-X main.buildVersion=1.2.3"
+var buildVersion = "dev"
+
+// usage lists the commands
+const usage = `usage: matrix-health [--config file] [command]
+
+commands:
+  serve                        Monitor the federation (the default)
+  check <server>               Check one server and exit with 0 (OK), 1 (warning) or 2 (failed)
+  bench [-c 10] [-d 10s] <server>
+                               Probe one server over and over and report the latency distribution and errors
+  query [--server s] [--room id] [--since 24h] [--status failed] [--format table|json] [--limit 100] [-o file]
+                               Print stored check results, newest first
+  state export [-o file]       Write the check history, incidents, silences and other stored state to an archive
+  state import [-replace] <file>
+                               Restore the stored state from an archive, e.g. on a new host (stop the monitor first)
+  validate-config              Check the configuration and exit with 1 if it is invalid
+  version                      Print the version
+  servers export [-format json|csv] [-o file]
+                               Check every server in the joined rooms and write the list
+  config dump [-o file]        Write the effective configuration, with secrets redacted
+  notify-test [all|logroom|rooms|webhook|alertmanager]
+                               Send a test failure and recovery through the notification channels
+  mockfed [-port 8441] [-servers healthy,slow,badjson,error,expired]
+                               Serve mock federation endpoints for testing
+
+flags:`
+
+// loadValidConfig loads the configuration from --config, or the first default file that exists, and validates it
+func loadValidConfig() error {
+        if configPath == "" {
+                configPath = findConfigFile()
+        }
+        next := &Config{}
+        if err := loadConfig(configPath, next); err != nil {
+                return fmt.Errorf("failed to load %s: %w", configPath, err)
+        }
+        if err := validateConfig(next); err != nil {
+                return fmt.Errorf("invalid configuration in %s: %w", configPath, err)
+        }
+        publishConfig(next)
+        fmt.Println("Configuration loaded successfully.")
+        return nil
+}
+
+// runValidateConfig checks the configuration without starting the monitor, returning the exit code
+func runValidateConfig() int {
+        if err := loadValidConfig(); err != nil {
+                fmt.Println("Configuration error:", err)
+                return 1
+        }
+        fmt.Printf("%s is valid\n", configPath)
+        return 0
+}
+
+// runCheck checks a single server with the configured probe settings, without logging in to Matrix,
+// and returns the exit code for its status
+func runCheck(args []string) int {
+        if len(args) != 1 {
+                fmt.Println("usage: matrix-health check <server>")
+                return 2
+        }
+        if err := loadValidConfig(); err != nil {
+                fmt.Println("Configuration error:", err)
+                return 2
+        }
+        initLimits()
+
+        server := strings.ToLower(args[0])
+        status, result := probeServer(context.Background(), nil, server)
+        fmt.Printf("%s - %s\n", server, status)
+        if result.Online {
+                fmt.Printf("Software: %s %s, latency: %s\n", result.Software, result.Version, result.Latency.Round(time.Millisecond))
+        }
+        switch statusClass(status) {
+        case "OK":
+                return 0
+        case "Warning":
+                return 1
+        default:
+                return 2
+        }
+}
+
+// runCommand runs a one-off subcommand that needs the bot's account
+func runCommand(ctx context.Context, client *mautrix.Client, args []string) error {
+        switch {
+        case len(args) >= 2 && args[0] == "servers" && args[1] == "export":
+                return runServersExport(ctx, client, args[2:])
+        case len(args) >= 2 && args[0] == "config" && args[1] == "dump":
+                return runConfigDump(args[2:])
+        case args[0] == "notify-test":
+                return runNotifyTest(ctx, client, args[1:])
+        default:
+                return fmt.Errorf("unknown command: %s\n%s", strings.Join(args, " "), usage)
+        }
+}
+
+// exportedServer is one server in the inventory export
+type exportedServer struct {
+        Server    string    `json:"server"`
+        Rooms     []string  `json:"rooms"`
+        Users     int       `json:"users"`
+        Software  string    `json:"software"`
+        Version   string    `json:"version"`
+        Status    string    `json:"status"`
+        CheckedAt time.Time `json:"checked_at"`
+        Impact    string    `json:"impact,omitempty"`
+}
+
+// runServersExport checks every server in the joined rooms and writes the full list as JSON or CSV.
+// The export goes to its own file so it doesn't mix with the progress messages on stdout.
+func runServersExport(ctx context.Context, client *mautrix.Client, args []string) error {
+        flags := flag.NewFlagSet("servers export", flag.ContinueOnError)
+        format := flags.String("format", "json", "output format (json or csv)")
+        output := flags.String("o", "", "output file (defaults to servers.json or servers.csv)")
+        if err := flags.Parse(args); err != nil {
+                return err
+        }
+        if *format != "json" && *format != "csv" {
+                return fmt.Errorf("unsupported format: %s", *format)
+        }
+        if *output == "" {
+                *output = "servers." + *format
+        }
+
+        joinedRooms, err := client.JoinedRooms(ctx)
+        if err != nil {
+                return fmt.Errorf("failed to fetch joined rooms: %w", err)
+        }
+
+        // Collect the rooms and user counts of every server
+        servers := make(map[string]*exportedServer)
+        for _, roomID := range joinedRooms.JoinedRooms {
+                if isLogRoom(roomID) {
+                        continue
+                }
+                roomAlias, _ := getRoomDetails(ctx, client, roomID)
+
+                members, err := joinedMembers(ctx, client, roomID)
+                if err != nil {
+                        fmt.Printf("Failed to get joined members for room %s: %v\n", roomID, err)
+                        continue
+                }
+
+                for userID := range members {
+                        server := extractDomain(string(userID))
+                        if isIgnored(server) {
+                                continue
+                        }
+                        entry, ok := servers[server]
+                        if !ok {
+                                entry = &exportedServer{Server: server}
+                                servers[server] = entry
+                        }
+                        entry.Users++
+                        if len(entry.Rooms) == 0 || entry.Rooms[len(entry.Rooms)-1] != roomAlias {
+                                entry.Rooms = append(entry.Rooms, roomAlias)
+                        }
+                }
+        }
+
+        // Check each server once for its current status and version
+        var exported []exportedServer
+        for _, entry := range servers {
+                checkServer(ctx, client, entry.Server)
+                if result, ok := latestResult(entry.Server); ok {
+                        entry.Software = result.Software
+                        entry.Version = result.Version
+                        entry.Status = result.Status
+                        entry.CheckedAt = result.CheckedAt
+                }
+                entry.Impact = serverImpact(entry.Server)
+                exported = append(exported, *entry)
+        }
+        sort.Slice(exported, func(i, j int) bool { return exported[i].Server < exported[j].Server })
+
+        file, err := os.Create(*output)
+        if err != nil {
+                return err
+        }
+        defer file.Close()
+        fmt.Printf("Writing %d servers to %s\n", len(exported), *output)
+        return writeServersExport(file, *format, exported)
+}
+
+// writeServersExport writes the exported servers to out as JSON or CSV
+func writeServersExport(out io.Writer, format string, exported []exportedServer) error {
+        if format == "csv" {
+                writer := csv.NewWriter(out)
+                writer.Write([]string{"server", "rooms", "users", "software", "version", "status", "checked_at", "impact"})
+                for _, entry := range exported {
+                        writer.Write([]string{
+                                entry.Server,
+                                strings.Join(entry.Rooms, ";"),
+                                strconv.Itoa(entry.Users),
+                                entry.Software,
+                                entry.Version,
+                                entry.Status,
+                                entry.CheckedAt.Format(time.RFC3339),
+                                entry.Impact,
+                        })
+                }
+                writer.Flush()
+                return writer.Error()
+        }
+
+        encoder := json.NewEncoder(out)
+        encoder.SetIndent("", "  ")
+        return encoder.Encode(exported)
+}
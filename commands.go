@@ -0,0 +1,234 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "strconv"
+        "strings"
+        "time"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/event"
+        "maunium.net/go/mautrix/id"
+)
+
+// handleCommandEvent handles bot commands (messages starting with "!") sent to the log room
+func handleCommandEvent(ctx context.Context, client *mautrix.Client, evt *event.Event) {
+        if evt.Sender == client.UserID || !isLogRoom(evt.RoomID) {
+                return
+        }
+        content := evt.Content.AsMessage()
+        if !strings.HasPrefix(content.Body, "!") {
+                return
+        }
+
+        args := strings.Fields(content.Body)
+        command := strings.TrimPrefix(args[0], "!")
+        args = args[1:]
+
+        // A tenant's log room only sees that tenant's rooms and servers
+        tenant := tenantOfLogRoom(evt.RoomID)
+
+        // Commands can take a while, so don't hold up the sync loop
+        go func() {
+                var reply string
+                switch command {
+                case "purge":
+                        reply = adminOnly(ctx, client, evt, func() string { return commandPurge(ctx, client, evt.RoomID, args, evt.Sender) })
+                case "config":
+                        if tenant != "" {
+                                reply = "The configuration is only available in the shared log rooms"
+                        } else {
+                                reply = adminOnly(ctx, client, evt, commandConfig)
+                        }
+                case "status":
+                        reply = commandStatus(args, tenant)
+                case "server":
+                        reply = commandServer(args, tenant)
+                case "report":
+                        sendMessageToRoom(ctx, client, evt.RoomID, "Generating report...")
+                        reply = commandReport(ctx, client, args, tenant)
+                case "tag", "untag", "unnote":
+                        if tenant != "" {
+                                reply = "Tags and notes can only be changed from the shared log rooms"
+                        } else if command == "tag" {
+                                reply = adminOnly(ctx, client, evt, func() string { return commandTag(args, evt.Sender) })
+                        } else if command == "untag" {
+                                reply = adminOnly(ctx, client, evt, func() string { return commandUntag(args, evt.Sender) })
+                        } else {
+                                reply = adminOnly(ctx, client, evt, func() string { return commandUnnote(args, evt.Sender) })
+                        }
+                case "note":
+                        switch {
+                        case len(args) > 1 && tenant != "":
+                                reply = "Tags and notes can only be changed from the shared log rooms"
+                        case len(args) > 1:
+                                reply = adminOnly(ctx, client, evt, func() string { return commandNote(args, evt.Sender) })
+                        case len(args) == 1 && tenant != "" && !tenantServers(tenant)[strings.ToLower(args[0])]:
+                                reply = fmt.Sprintf("%s is not monitored for %s", args[0], tenant)
+                        default:
+                                reply = commandNote(args, evt.Sender)
+                        }
+                case "audit":
+                        if tenant != "" {
+                                reply = "The audit log is only available in the shared log rooms"
+                        } else {
+                                reply = adminOnly(ctx, client, evt, func() string { return commandAudit(args) })
+                        }
+                case "testalert":
+                        if tenant != "" {
+                                reply = "Test notifications can only be sent from the shared log rooms"
+                        } else {
+                                reply = adminOnly(ctx, client, evt, func() string { return commandTestAlert(ctx, client, args, evt.Sender) })
+                        }
+                case "health":
+                        reply = commandHealth(ctx, client, evt, args, tenant)
+                default:
+                        return
+                }
+                if reply != "" {
+                        sendMessageToRoom(ctx, client, evt.RoomID, reply)
+                }
+        }()
+}
+
+// commandHealth runs the "!health <subcommand>" family of operator commands
+func commandHealth(ctx context.Context, client *mautrix.Client, evt *event.Event, args []string, tenant string) string {
+        usage := "Usage: !health status [tag:<tag>] | check <server> | rooms | silence [<server> <duration>] | unsilence <server> | uptime <server> | abort"
+        if len(args) == 0 {
+                return usage
+        }
+        switch subcommand, args := args[0], args[1:]; subcommand {
+        case "status":
+                return commandStatus(args, tenant)
+        case "check":
+                if len(args) != 1 {
+                        return "Usage: !health check <server>"
+                }
+                if tenant != "" && !tenantServers(tenant)[strings.ToLower(args[0])] {
+                        return fmt.Sprintf("%s is not monitored for %s", args[0], tenant)
+                }
+                sendMessageToRoom(ctx, client, evt.RoomID, fmt.Sprintf("Checking %s...", args[0]))
+                return serverReport(ctx, client, strings.ToLower(args[0]))
+        case "rooms":
+                return commandRooms(ctx, client, tenant)
+        case "uptime":
+                return commandUptime(args, tenant)
+        case "silence", "unsilence":
+                if tenant != "" {
+                        return "Silences can only be managed from the shared log rooms"
+                }
+                if subcommand == "unsilence" {
+                        return adminOnly(ctx, client, evt, func() string { return commandUnsilence(args, evt.Sender) })
+                }
+                if len(args) == 0 {
+                        return commandSilence(args, evt.Sender)
+                }
+                return adminOnly(ctx, client, evt, func() string { return commandSilence(args, evt.Sender) })
+        case "abort":
+                if tenant != "" {
+                        return "Check cycles can only be aborted from the shared log rooms"
+                }
+                return adminOnly(ctx, client, evt, func() string {
+                        recordAudit(evt.Sender.String(), "abort", "check cycle", "", "")
+                        if !abortCycle() {
+                                return "No check cycle is running"
+                        }
+                        return "Aborting the running check cycle; rooms not yet checked are left for the next one"
+                })
+        default:
+                return usage
+        }
+}
+
+// commandRooms lists the monitored rooms (of the tenant, if any) with their servers' latest results
+func commandRooms(ctx context.Context, client *mautrix.Client, tenant string) string {
+        joinedRooms, err := client.JoinedRooms(ctx)
+        if err != nil {
+                return fmt.Sprintf("Failed to fetch joined rooms: %v", err)
+        }
+
+        var lines []string
+        for _, roomID := range joinedRooms.JoinedRooms {
+                if isLogRoom(roomID) || !inTenant(roomID, tenant) {
+                        continue
+                }
+                roomAlias, roomTitle := getRoomDetails(ctx, client, roomID)
+                members, err := joinedMembers(ctx, client, roomID)
+                if err != nil {
+                        lines = append(lines, fmt.Sprintf("%s - %s ( %s ): failed to get members: %v", roomAlias, roomTitle, roomID, err))
+                        continue
+                }
+                servers, _ := roomMemberServers(members)
+                failed := 0
+                for _, server := range servers {
+                        if result, ok := latestResult(server); ok && strings.HasPrefix(result.Status, "Failed") {
+                                failed++
+                        }
+                }
+                lines = append(lines, fmt.Sprintf("%s - %s ( %s ): %d members on %d servers, %d failing", roomAlias, roomTitle, roomID, len(members), len(servers), failed))
+        }
+        if len(lines) == 0 {
+                return "No monitored rooms"
+        }
+        return fmt.Sprintf("Monitored rooms (%d):\n%s", len(lines), reportLines(lines))
+}
+
+// adminOnly runs the command if the sender's power level in the room allows it
+func adminOnly(ctx context.Context, client *mautrix.Client, evt *event.Event, command func() string) string {
+        var powerLevels event.PowerLevelsEventContent
+        if err := roomState(ctx, client, evt.RoomID, event.StatePowerLevels, "", &powerLevels); err != nil {
+                return fmt.Sprintf("Failed to check permissions: %v", err)
+        }
+
+        required := commandPowerLevel()
+        if powerLevels.GetUserLevel(evt.Sender) < required {
+                return fmt.Sprintf("%s: this command requires power level %d", evt.Sender, required)
+        }
+        return command()
+}
+
+// commandPowerLevel returns the power level needed for admin commands
+func commandPowerLevel() int {
+        if config().CommandPowerLevel == 0 {
+                return 50
+        }
+        return config().CommandPowerLevel
+}
+
+// commandPurge redacts the bot's messages in the room older than the given age, e.g. "!purge 30d"
+func commandPurge(ctx context.Context, client *mautrix.Client, roomID id.RoomID, args []string, actor id.UserID) string {
+        if len(args) != 1 {
+                return "Usage: !purge <age> (e.g. 30d, 12h, 2w)"
+        }
+        age, err := parseAge(args[0])
+        if err != nil {
+                return err.Error()
+        }
+
+        sendMessageToRoom(ctx, client, roomID, fmt.Sprintf("Purging reports older than %s...", args[0]))
+        redacted, err := redactOwnMessages(ctx, client, roomID, time.Now().Add(-age), 0, 0)
+        recordAudit(actor.String(), "purge", roomID.String(), "", fmt.Sprintf("%d messages older than %s", redacted, args[0]))
+        if err != nil {
+                return fmt.Sprintf("Purge stopped after %d messages: %v", redacted, err)
+        }
+        return fmt.Sprintf("Purged %d messages older than %s", redacted, args[0])
+}
+
+// parseAge parses an age like 30d, 2w, 12h or 45m
+func parseAge(value string) (time.Duration, error) {
+        units := map[byte]time.Duration{
+                'm': time.Minute,
+                'h': time.Hour,
+                'd': 24 * time.Hour,
+                'w': 7 * 24 * time.Hour,
+        }
+        if len(value) >= 2 {
+                if unit, ok := units[value[len(value)-1]]; ok {
+                        if n, err := strconv.Atoi(value[:len(value)-1]); err == nil && n > 0 {
+                                return time.Duration(n) * unit, nil
+                        }
+                }
+        }
+        return 0, fmt.Errorf("invalid age %q, expected a number followed by m, h, d or w", value)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+        "encoding/json"
+        "fmt"
+        "io/ioutil"
+        "os"
+        "path/filepath"
+        "sort"
+        "strings"
+
+        "gopkg.in/yaml.v3"
+)
+
+// loadConfigIncludes merges the files listed under include and the configuration files in include_dir into the configuration.
+// Files are applied in order, so later files override scalar settings, while maps such as servers are merged.
+// Included files may include further files; relative paths are resolved against the directory of the including file.
+func loadConfigIncludes(mainPath string, c *Config) error {
+        abs, err := filepath.Abs(mainPath)
+        if err != nil {
+                return err
+        }
+        return loadIncludesFrom(mainPath, c, map[string]bool{abs: true})
+}
+
+// loadIncludesFrom merges the includes set by the file at path, descending into nested includes.
+// visiting holds the files on the current include chain, so a file including itself is reported as a cycle.
+func loadIncludesFrom(path string, c *Config, visiting map[string]bool) error {
+        include, includeDir := c.Include, c.IncludeDir
+        defer func() { c.Include, c.IncludeDir = include, includeDir }()
+
+        files, err := includeFiles(filepath.Dir(path), include, includeDir)
+        if err != nil {
+                return fmt.Errorf("%s: %w", path, err)
+        }
+
+        for _, file := range files {
+                abs, err := filepath.Abs(file)
+                if err != nil {
+                        return err
+                }
+                if visiting[abs] {
+                        return fmt.Errorf("%s: include cycle through %s", path, file)
+                }
+
+                fmt.Printf("Including configuration from: %s\n", file)
+                data, err := ioutil.ReadFile(file)
+                if err != nil {
+                        return err
+                }
+                c.Include, c.IncludeDir = nil, ""
+                if err := decodeConfig(file, data, c); err != nil {
+                        return fmt.Errorf("%s: %w", file, err)
+                }
+
+                visiting[abs] = true
+                err = loadIncludesFrom(file, c, visiting)
+                delete(visiting, abs)
+                if err != nil {
+                        return err
+                }
+        }
+        return nil
+}
+
+// includeFiles lists the files named by include and include_dir, resolving relative paths against baseDir
+func includeFiles(baseDir string, include []string, includeDir string) ([]string, error) {
+        resolve := func(path string) string {
+                if filepath.IsAbs(path) {
+                        return path
+                }
+                return filepath.Join(baseDir, path)
+        }
+
+        var files []string
+        for _, name := range include {
+                files = append(files, resolve(name))
+        }
+
+        if includeDir != "" {
+                dir := resolve(includeDir)
+                entries, err := ioutil.ReadDir(dir)
+                if err != nil {
+                        return nil, fmt.Errorf("failed to read include_dir: %w", err)
+                }
+                var dirFiles []string
+                for _, entry := range entries {
+                        ext := strings.ToLower(filepath.Ext(entry.Name()))
+                        if !entry.IsDir() && (ext == ".yaml" || ext == ".yml" || ext == ".toml" || ext == ".json") {
+                                dirFiles = append(dirFiles, filepath.Join(dir, entry.Name()))
+                        }
+                }
+                sort.Strings(dirFiles)
+                files = append(files, dirFiles...)
+        }
+        return files, nil
+}
+
+// configFileNames are the configuration files looked for when none is given, in order of preference
+var configFileNames = []string{"config.yaml", "config.yml", "config.toml", "config.json"}
+
+// findConfigFile returns the first existing default configuration file, or config.yaml if there is none
+func findConfigFile() string {
+        for _, name := range configFileNames {
+                if _, err := os.Stat(name); err == nil {
+                        return name
+                }
+        }
+        return configFileNames[0]
+}
+
+// decodeConfig merges a configuration file into c, choosing YAML, TOML or JSON by its extension.
+// TOML and JSON are converted to YAML first, so the yaml struct tags stay the single source of key names.
+func decodeConfig(path string, data []byte, c *Config) error {
+        var generic map[string]interface{}
+        switch strings.ToLower(filepath.Ext(path)) {
+        case ".json":
+                if err := json.Unmarshal(data, &generic); err != nil {
+                        return err
+                }
+        case ".toml":
+                var err error
+                if generic, err = decodeTOML(string(data)); err != nil {
+                        return err
+                }
+        default:
+                return yaml.Unmarshal(data, c)
+        }
+
+        converted, err := yaml.Marshal(generic)
+        if err != nil {
+                return err
+        }
+        return yaml.Unmarshal(converted, c)
+}
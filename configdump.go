@@ -0,0 +1,140 @@
+package main
+
+import (
+        "flag"
+        "fmt"
+        "io"
+        "os"
+        "sort"
+        "strings"
+        "time"
+
+        "gopkg.in/yaml.v3"
+)
+
+// redacted replaces secrets in the configuration dump
+const redacted = "<redacted>"
+
+// runtimeState is what the bot is doing beyond the configuration files
+type runtimeState struct {
+        IgnoredFromFile   []string                       `yaml:"ignored_from_file"`
+        StaticServers     []string                       `yaml:"static_servers"`
+        Maintenance       map[string][]MaintenanceWindow `yaml:"maintenance"`
+        ActiveMaintenance []string                       `yaml:"active_maintenance"`
+        InventoryServers  int                            `yaml:"inventory_servers"`
+        Silenced          []string                       `yaml:"silenced"`
+}
+
+// dumpConfig returns the effective configuration and runtime state as YAML, with secrets redacted
+func dumpConfig() (string, error) {
+        running := config()
+        effective := *running
+        for _, secret := range []*string{&effective.Password, &effective.AccessToken, &effective.Encryption.PickleKey, &effective.Encryption.RecoveryKey, &effective.API.Token, &effective.FallbackWebhook} {
+                if *secret != "" {
+                        *secret = redacted
+                }
+        }
+
+        if len(effective.API.ReadTokens) > 0 {
+                effective.API.ReadTokens = make([]string, len(running.API.ReadTokens))
+                for i := range effective.API.ReadTokens {
+                        effective.API.ReadTokens[i] = redacted
+                }
+        }
+
+        if len(effective.Tenants) > 0 {
+                effective.Tenants = make(map[string]TenantConfig, len(running.Tenants))
+                for name, tenant := range running.Tenants {
+                        if tenant.APIToken != "" {
+                                tenant.APIToken = redacted
+                        }
+                        effective.Tenants[name] = tenant
+                }
+        }
+
+        dump := struct {
+                Config  Config       `yaml:"config"`
+                Runtime runtimeState `yaml:"runtime"`
+        }{effective, currentRuntimeState()}
+
+        data, err := yaml.Marshal(dump)
+        if err != nil {
+                return "", err
+        }
+        return string(data), nil
+}
+
+// currentRuntimeState collects the state loaded at runtime from list files and the inventory, and the active silences
+func currentRuntimeState() runtimeState {
+        state := runtimeState{
+                StaticServers: staticServers(),
+                Silenced:      activeSilences(),
+                Maintenance:   make(map[string][]MaintenanceWindow),
+        }
+
+        listsMu.RLock()
+        for server := range ignoreFromFile {
+                state.IgnoredFromFile = append(state.IgnoredFromFile, server)
+        }
+        servers := make(map[string]bool)
+        for server := range maintenanceFromFile {
+                servers[server] = true
+        }
+        listsMu.RUnlock()
+        sort.Strings(state.IgnoredFromFile)
+
+        for server := range config().Servers {
+                servers[strings.ToLower(server)] = true
+        }
+        now := time.Now()
+        for server := range servers {
+                if windows := serverOverride(server).Maintenance; len(windows) > 0 {
+                        state.Maintenance[server] = windows
+                        if inMaintenance(server, now) {
+                                state.ActiveMaintenance = append(state.ActiveMaintenance, server)
+                        }
+                }
+        }
+        sort.Strings(state.ActiveMaintenance)
+
+        inventoryMu.RLock()
+        state.InventoryServers = len(inventory)
+        inventoryMu.RUnlock()
+        return state
+}
+
+// runConfigDump writes the effective configuration to stdout or a file
+func runConfigDump(args []string) error {
+        flags := flag.NewFlagSet("config dump", flag.ContinueOnError)
+        output := flags.String("o", "", "output file (defaults to stdout)")
+        if err := flags.Parse(args); err != nil {
+                return err
+        }
+
+        dump, err := dumpConfig()
+        if err != nil {
+                return err
+        }
+
+        var out io.Writer = os.Stdout
+        if *output != "" {
+                file, err := os.Create(*output)
+                if err != nil {
+                        return err
+                }
+                defer file.Close()
+                out = file
+                fmt.Printf("Writing configuration to %s\n", *output)
+        }
+        _, err = io.WriteString(out, dump)
+        return err
+}
+
+// commandConfig replies with the effective configuration, e.g. "!config"
+func commandConfig() string {
+        dump, err := dumpConfig()
+        if err != nil {
+                return fmt.Sprintf("Failed to dump the configuration: %v", err)
+        }
+        return "Effective configuration:\n" + dump
+}
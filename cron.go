@@ -0,0 +1,128 @@
+package main
+
+import (
+        "fmt"
+        "strconv"
+        "strings"
+        "time"
+)
+
+// cronSchedule is a parsed cron expression with the five standard fields: minute, hour, day of month, month and
+// day of week (0-7, both 0 and 7 being Sunday). Fields take *, numbers, ranges (1-5), steps (*/15, 0-30/10)
+// and lists of those; @hourly, @daily, @weekly and @monthly are accepted as well.
+type cronSchedule struct {
+        minute, hour, dom, month, dow uint64 // Bit sets of the allowed values
+        domAny, dowAny                bool   // Day fields given as *, as a day matches either day field when both are set
+}
+
+// cronMacros are the shorthands for common schedules
+var cronMacros = map[string]string{
+        "@hourly":  "0 * * * *",
+        "@daily":   "0 0 * * *",
+        "@weekly":  "0 0 * * 0",
+        "@monthly": "0 0 1 * *",
+}
+
+// parseCron parses a cron expression
+func parseCron(expr string) (*cronSchedule, error) {
+        if macro, ok := cronMacros[strings.TrimSpace(expr)]; ok {
+                expr = macro
+        }
+        fields := strings.Fields(expr)
+        if len(fields) != 5 {
+                return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields (minute hour day-of-month month day-of-week)", expr)
+        }
+
+        var c cronSchedule
+        var err error
+        for i, field := range []struct {
+                bits     *uint64
+                min, max int
+        }{{&c.minute, 0, 59}, {&c.hour, 0, 23}, {&c.dom, 1, 31}, {&c.month, 1, 12}, {&c.dow, 0, 7}} {
+                if *field.bits, err = parseCronField(fields[i], field.min, field.max); err != nil {
+                        return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+                }
+        }
+        if c.dow&(1<<7) != 0 {
+                c.dow |= 1 // 7 is Sunday too
+        }
+        c.domAny, c.dowAny = fields[2] == "*", fields[4] == "*"
+        return &c, nil
+}
+
+// parseCronField parses one field of a cron expression into the bit set of the values it allows
+func parseCronField(field string, min, max int) (uint64, error) {
+        var bits uint64
+        for _, part := range strings.Split(field, ",") {
+                rng, step := part, 1
+                if i := strings.Index(part, "/"); i >= 0 {
+                        var err error
+                        if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+                                return 0, fmt.Errorf("invalid step in %q", part)
+                        }
+                        rng = part[:i]
+                }
+
+                low, high := min, max
+                switch {
+                case rng == "*":
+                case strings.Contains(rng, "-"):
+                        bounds := strings.SplitN(rng, "-", 2)
+                        var err1, err2 error
+                        low, err1 = strconv.Atoi(bounds[0])
+                        high, err2 = strconv.Atoi(bounds[1])
+                        if err1 != nil || err2 != nil {
+                                return 0, fmt.Errorf("invalid range %q", rng)
+                        }
+                default:
+                        value, err := strconv.Atoi(rng)
+                        if err != nil {
+                                return 0, fmt.Errorf("invalid value %q", rng)
+                        }
+                        low, high = value, value
+                        if step > 1 {
+                                high = max // 5/15 means every 15 starting at 5
+                        }
+                }
+                if low < min || high > max || low > high {
+                        return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+                }
+                for value := low; value <= high; value += step {
+                        bits |= 1 << uint(value)
+                }
+        }
+        return bits, nil
+}
+
+// matchesDay reports whether a day is part of the schedule. As in cron, when both day fields are restricted,
+// a day matching either of them is.
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+        dom := c.dom&(1<<uint(t.Day())) != 0
+        dow := c.dow&(1<<uint(t.Weekday())) != 0
+        if c.domAny || c.dowAny {
+                return dom && dow
+        }
+        return dom || dow
+}
+
+// next returns the first time after the given one the schedule fires, in the given time's location,
+// or the zero time if it never does (e.g. on February 30th)
+func (c *cronSchedule) next(after time.Time) time.Time {
+        loc := after.Location()
+        t := after.Truncate(time.Minute).Add(time.Minute)
+        for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+                switch {
+                case c.month&(1<<uint(t.Month())) == 0:
+                        t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+                case !c.matchesDay(t):
+                        t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+                case c.hour&(1<<uint(t.Hour())) == 0:
+                        t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+                case c.minute&(1<<uint(t.Minute())) == 0:
+                        t = t.Add(time.Minute)
+                default:
+                        return t
+                }
+        }
+        return time.Time{}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+        "context"
+        "sync"
+        "time"
+
+        "maunium.net/go/mautrix"
+)
+
+// The probes of the running check cycle stop at the cycle timeout, on !health abort, or at shutdown
+var (
+        cycleMu     sync.Mutex
+        cancelCycle context.CancelFunc
+)
+
+// startCycle returns the context the probes of a new check cycle run in
+func startCycle(ctx context.Context) context.Context {
+        var cancel context.CancelFunc
+        if config().CycleTimeout > 0 {
+                ctx, cancel = context.WithTimeout(ctx, time.Duration(config().CycleTimeout)*time.Second)
+        } else {
+                ctx, cancel = context.WithCancel(ctx)
+        }
+        cycleMu.Lock()
+        defer cycleMu.Unlock()
+        cancelCycle = cancel
+        cycleChecks = make(map[string]*sharedCheck)
+        return ctx
+}
+
+// endCycle releases the context of the check cycle that just ended
+func endCycle() {
+        cycleMu.Lock()
+        defer cycleMu.Unlock()
+        if cancelCycle != nil {
+                cancelCycle()
+                cancelCycle = nil
+        }
+        cycleChecks = nil
+}
+
+// sharedCheck is a server's check in the running cycle, shared by the rooms the server is in
+type sharedCheck struct {
+        done   chan struct{}
+        status string
+        ok     bool // False if the check crashed
+}
+
+// cycleChecks are the servers checked in the running cycle; nil outside a cycle
+var cycleChecks map[string]*sharedCheck
+
+// checkServerOnce checks a server for a room unless another room already did in this cycle, and otherwise waits for
+// and shares that check. A server in several rooms is thus probed once per cycle, and its failure streak and recheck
+// backoff advance once per cycle. It reports false if the check crashed.
+func checkServerOnce(ctx context.Context, client *mautrix.Client, server, room string) (string, bool) {
+        cycleMu.Lock()
+        if cycleChecks == nil {
+                cycleMu.Unlock()
+                return checkServerInRoom(ctx, client, server, room), true
+        }
+        check, shared := cycleChecks[server]
+        if !shared {
+                check = &sharedCheck{done: make(chan struct{})}
+                cycleChecks[server] = check
+        }
+        cycleMu.Unlock()
+
+        if shared {
+                select {
+                case <-check.done:
+                        return check.status, check.ok
+                case <-ctx.Done():
+                        return "Failed (Check aborted)", true
+                }
+        }
+        // A crash is passed on to the caller, and the rooms waiting for this check go without its status
+        defer close(check.done)
+        check.status = checkServerInRoom(ctx, client, server, room)
+        check.ok = true
+        return check.status, true
+}
+
+// abortCycle stops the probes of the running check cycle, reporting whether one was running
+func abortCycle() bool {
+        cycleMu.Lock()
+        defer cycleMu.Unlock()
+        if cancelCycle == nil {
+                return false
+        }
+        cancelCycle()
+        return true
+}
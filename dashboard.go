@@ -0,0 +1,150 @@
+package main
+
+import (
+        "encoding/json"
+        "fmt"
+        "html/template"
+        "net/http"
+        "strings"
+        "time"
+
+        "maunium.net/go/mautrix/id"
+)
+
+// dashboardTemplate is the read-only status page
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="60">
+<title>Matrix federation health</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.3em 0.8em; text-align: left; border-bottom: 1px solid #ddd; }
+.OK { color: #2a7d2a; } .Failed { color: #c62828; } .Warning { color: #b26a00; } .Maintenance { color: #555; }
+</style>
+</head>
+<body>
+<h1>Matrix federation health</h1>
+<p>{{len .Servers}} servers, {{.Failed}} failing{{if .Silenced}}, silenced: {{.Silenced}}{{end}}. Updated {{.Now}}.</p>
+<table>
+<tr><th>Server</th><th>Status</th><th>Software</th><th>Latency</th><th>Checked</th></tr>
+{{range .Servers}}<tr><td>{{.Server}}</td><td class="{{.Class}}">{{.Status}}</td><td>{{.Software}} {{.Version}}</td><td>{{.Latency}}</td><td>{{.CheckedAt}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// dashboardServer is a row of the status page
+type dashboardServer struct {
+        Server, Status, Class, Software, Version, Latency, CheckedAt string
+}
+
+// handleDashboard serves the read-only status page with every checked server, failing ones first;
+// a tenant only sees the servers in its rooms
+func handleDashboard(w http.ResponseWriter, r *http.Request, tenant string) {
+        if r.URL.Path != "/" {
+                http.NotFound(w, r)
+                return
+        }
+
+        var visible map[string]bool
+        if tenant != "" {
+                visible = tenantServers(tenant)
+        }
+
+        resultsMu.RLock()
+        servers := make([]string, 0, len(latestResults))
+        statuses := make(map[string]string)
+        for server, result := range latestResults {
+                if visible != nil && !visible[server] {
+                        continue
+                }
+                servers = append(servers, server)
+                statuses[server] = result.Status
+        }
+        resultsMu.RUnlock()
+        sortServers(servers, statuses, nil)
+
+        page := struct {
+                Servers  []dashboardServer
+                Failed   int
+                Silenced string
+                Now      string
+        }{Now: time.Now().UTC().Format("2006-01-02 15:04 UTC")}
+        if tenant == "" {
+                page.Silenced = strings.Join(activeSilences(), ", ")
+        }
+        for _, server := range servers {
+                result, _ := latestResult(server)
+                row := dashboardServer{
+                        Server:    server,
+                        Status:    result.Status,
+                        Class:     statusClass(result.Status),
+                        Software:  result.Software,
+                        Version:   result.Version,
+                        CheckedAt: result.CheckedAt.UTC().Format("2006-01-02 15:04:05"),
+                }
+                if result.Online {
+                        row.Latency = result.Latency.Round(time.Millisecond).String()
+                }
+                if row.Class == "Failed" {
+                        page.Failed++
+                }
+                page.Servers = append(page.Servers, row)
+        }
+
+        w.Header().Set("Content-Type", "text/html; charset=utf-8")
+        if err := dashboardTemplate.Execute(w, page); err != nil {
+                fmt.Println("Failed to render the dashboard:", err)
+        }
+}
+
+// silenceRequest silences a server through the API
+type silenceRequest struct {
+        Server   string `json:"server"`
+        Duration string `json:"duration"` // e.g. 2h or 1d, empty ends the silence
+}
+
+// handleSilences lists the silenced servers (GET, read access) or silences a server (POST, control access):
+// POST /api/v1/silences with {"server": "example.org", "duration": "2h"}
+func handleSilences(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet:
+                readOnly(func(w http.ResponseWriter, r *http.Request) {
+                        writeJSON(w, map[string][]string{"silenced": activeSilences()})
+                })(w, r)
+        case http.MethodPost:
+                if !authorized(r) {
+                        http.Error(w, "unauthorized", http.StatusUnauthorized)
+                        return
+                }
+                var req silenceRequest
+                if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil || req.Server == "" {
+                        http.Error(w, "expected {\"server\": ..., \"duration\": ...}", http.StatusBadRequest)
+                        return
+                }
+                if req.Duration == "" {
+                        if err := unsilence(req.Server, id.UserID(apiActor(r))); err != nil {
+                                http.Error(w, err.Error(), http.StatusInternalServerError)
+                                return
+                        }
+                        writeJSON(w, map[string]bool{"silenced": false})
+                        return
+                }
+                duration, err := parseAge(req.Duration)
+                if err != nil {
+                        http.Error(w, err.Error(), http.StatusBadRequest)
+                        return
+                }
+                until := time.Now().Add(duration)
+                if err := silence(req.Server, until, id.UserID(apiActor(r))); err != nil {
+                        http.Error(w, err.Error(), http.StatusInternalServerError)
+                        return
+                }
+                writeJSON(w, map[string]interface{}{"silenced": true, "until": until})
+        default:
+                http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "sort"
+        "strings"
+        "time"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/id"
+)
+
+// DevicesConfig watches the number of devices on the monitoring account, which years of password logins can pile up
+type DevicesConfig struct {
+        WarnAt   int  `yaml:"warn_at"`   // Warn in the log room when the account has this many devices, defaults to 50 (-1 = never)
+        Prune    bool `yaml:"prune"`     // Delete old matrix-health devices other than the current one (needs the password)
+        KeepDays int  `yaml:"keep_days"` // Only prune devices unused for this many days, defaults to 30
+}
+
+// deviceDisplayName names the devices the bot logs in with, so old ones can be told apart from the owner's
+const deviceDisplayName = "matrix-health"
+
+// deviceCheckInterval is how often the account's devices are counted
+const deviceCheckInterval = 24 * time.Hour
+
+// lastDeviceCheck is when the account's devices were last counted
+var lastDeviceCheck time.Time
+
+// checkDevices counts the account's devices at most once per check interval, pruning old matrix-health devices
+// if enabled and warning in the log room when there are still too many
+func checkDevices(ctx context.Context, client *mautrix.Client) {
+        if time.Since(lastDeviceCheck) < deviceCheckInterval {
+                return
+        }
+        lastDeviceCheck = time.Now()
+
+        resp, err := client.GetDevicesInfo(ctx)
+        if err != nil {
+                fmt.Println("Failed to list the account's devices:", err)
+                return
+        }
+        devices := resp.Devices
+        if config().Devices.Prune {
+                devices = pruneDevices(ctx, client, devices)
+        }
+
+        warnAt := config().Devices.WarnAt
+        if warnAt == 0 {
+                warnAt = 50
+        }
+        fmt.Printf("The account has %d devices\n", len(devices))
+        if warnAt > 0 && len(devices) >= warnAt {
+                sendReport(ctx, client, severityWarning, fmt.Sprintf("The monitoring account has %d devices, which slows down the account. "+
+                        "Log out unused sessions, or set devices.prune to delete old %s devices.", len(devices), deviceDisplayName))
+        }
+}
+
+// pruneDevices deletes the bot's own devices unused for the keep days, except the current one,
+// and returns the devices that are left
+func pruneDevices(ctx context.Context, client *mautrix.Client, devices []mautrix.RespDeviceInfo) []mautrix.RespDeviceInfo {
+        keepDays := config().Devices.KeepDays
+        if keepDays <= 0 {
+                keepDays = 30
+        }
+        cutoff := time.Now().AddDate(0, 0, -keepDays)
+
+        var stale []id.DeviceID
+        var kept []mautrix.RespDeviceInfo
+        for _, device := range devices {
+                ours := strings.HasPrefix(device.DisplayName, deviceDisplayName)
+                if ours && device.DeviceID != client.DeviceID && time.UnixMilli(device.LastSeenTS).Before(cutoff) {
+                        stale = append(stale, device.DeviceID)
+                } else {
+                        kept = append(kept, device)
+                }
+        }
+        if len(stale) == 0 {
+                return devices
+        }
+        if config().Password == "" {
+                fmt.Printf("Not pruning %d old devices: deleting devices needs the password\n", len(stale))
+                return devices
+        }
+        sort.Slice(stale, func(i, j int) bool { return stale[i] < stale[j] })
+
+        err := client.DeleteDevices(ctx, &mautrix.ReqDeleteDevices{
+                Devices: stale,
+                Auth: mautrix.ReqUIAuthLogin{
+                        BaseAuthData: mautrix.BaseAuthData{Type: mautrix.AuthTypePassword},
+                        User:         config().Username,
+                        Password:     config().Password,
+                },
+        })
+        if err != nil {
+                fmt.Printf("Failed to prune %d old devices: %v\n", len(stale), err)
+                return devices
+        }
+        fmt.Printf("Pruned %d %s devices unused for %d days\n", len(stale), deviceDisplayName, keepDays)
+        recordAudit(client.UserID.String(), "prune devices", strings.Join(idStrings(stale), ", "), "", "")
+        return kept
+}
+
+// idStrings converts device IDs to strings
+func idStrings(devices []id.DeviceID) []string {
+        values := make([]string, len(devices))
+        for i, device := range devices {
+                values[i] = device.String()
+        }
+        return values
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "sort"
+        "strings"
+        "sync"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/id"
+)
+
+var (
+        snapshotsMu   sync.Mutex
+        roomSnapshots = make(map[id.RoomID]map[string]string) // Status class per server of each room in the previous cycle
+)
+
+// reportDiff posts what changed in a room since the previous cycle: servers that newly failed or got warnings,
+// went into maintenance or recovered, joined the room or left it. Nothing is posted when nothing changed.
+// It returns false when there is no previous cycle to compare with (e.g. after a restart), so the caller
+// sends the full report instead.
+func reportDiff(ctx context.Context, client *mautrix.Client, roomID id.RoomID, roomDescription string, servers []string, statuses map[string]string) bool {
+        current := make(map[string]string)
+        for _, server := range servers {
+                if status, ok := statuses[server]; ok {
+                        current[server] = statusClass(status)
+                }
+        }
+
+        snapshotsMu.Lock()
+        previous, ok := roomSnapshots[roomID]
+        // Servers left out by the probe budget keep their previous class rather than looking departed
+        for _, server := range servers {
+                if _, checked := current[server]; !checked && previous[server] != "" {
+                        current[server] = previous[server]
+                }
+        }
+        roomSnapshots[roomID] = current
+        snapshotsMu.Unlock()
+        if !ok {
+                return false
+        }
+
+        var failed, warned, maintenance, recovered, joined, departed []string
+        joinedFailing := false
+        for _, server := range servers {
+                class, checked := current[server]
+                if !checked {
+                        continue
+                }
+                line := formatServerLine(server, statuses[server])
+                before, seen := previous[server]
+                if !seen {
+                        joined = append(joined, line)
+                        joinedFailing = joinedFailing || class == "Failed"
+                        continue
+                }
+                if class == before {
+                        continue
+                }
+                switch class {
+                case "Failed":
+                        failed = append(failed, line)
+                case "Warning":
+                        warned = append(warned, line)
+                case "Maintenance":
+                        maintenance = append(maintenance, line)
+                case "OK":
+                        if before == "Failed" {
+                                if note := recoveryNote(server); note != "" {
+                                        before += ", " + note
+                                }
+                        }
+                        recovered = append(recovered, fmt.Sprintf("%s (was %s)", line, before))
+                }
+        }
+        for server, before := range previous {
+                if _, still := current[server]; !still {
+                        departed = append(departed, fmt.Sprintf("%s (was %s)", server, before))
+                }
+        }
+        sort.Strings(departed)
+
+        var b strings.Builder
+        section := func(title string, lines []string) {
+                if len(lines) > 0 {
+                        fmt.Fprintf(&b, "\n%s:\n%s", title, reportLines(lines))
+                }
+        }
+        section("Newly failed", failed)
+        section("New warnings", warned)
+        section("Now in maintenance", maintenance)
+        section("Recovered", recovered)
+        section("New servers", joined)
+        section("Departed servers", departed)
+        if b.Len() == 0 {
+                return true
+        }
+
+        sev := severityInfo
+        if len(failed) > 0 || joinedFailing {
+                sev = severityCritical
+        } else if len(warned) > 0 {
+                sev = severityWarning
+        }
+        sendRoomReport(ctx, client, roomID, sev, fmt.Sprintf("Changes in room %s since the last cycle:%s", roomDescription, b.String()))
+        return true
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "sort"
+        "strconv"
+        "strings"
+        "time"
+
+        "maunium.net/go/mautrix"
+)
+
+// DigestConfig schedules the digest of the federation's downtime, latency and membership since the previous one
+type DigestConfig struct {
+        Schedule   string `yaml:"schedule"`    // Cron expression in the bot's time zone, e.g. "0 8 * * *" (daily) or "0 8 * * 1" (Mondays); empty disables the digest
+        MaxServers int    `yaml:"max_servers"` // Servers listed per section, defaults to 10
+}
+
+// validateDigest checks the digest schedule
+func validateDigest(c *Config) error {
+        if c.Digest.Schedule == "" {
+                return nil
+        }
+        _, err := parseCron(c.Digest.Schedule)
+        return err
+}
+
+// sendDigest posts the digest when its schedule says so. The first one comes at the first scheduled time after
+// enabling it; each covers the time since the previous one.
+func sendDigest(ctx context.Context, client *mautrix.Client) {
+        if config().Digest.Schedule == "" {
+                return
+        }
+        schedule, err := parseCron(config().Digest.Schedule)
+        if err != nil {
+                return // Refused by validateConfig
+        }
+        now := time.Now()
+        last, err := strconv.ParseInt(loadState("digest_last"), 10, 64)
+        if err != nil {
+                if err := saveState("digest_last", strconv.FormatInt(now.Unix(), 10)); err != nil {
+                        fmt.Println("Failed to save the digest time:", err)
+                }
+                return
+        }
+        since := time.Unix(last, 0)
+        if due := schedule.next(since); due.IsZero() || now.Before(due) {
+                return
+        }
+        if err := saveState("digest_last", strconv.FormatInt(now.Unix(), 10)); err != nil {
+                fmt.Println("Failed to save the digest time:", err)
+        }
+
+        message, err := compileDigest(since, now)
+        if err != nil {
+                fmt.Println("Failed to compile the digest:", err)
+                return
+        }
+        sendReport(ctx, client, severityInfo, message)
+}
+
+// compileDigest describes a period: the servers with downtime, how much and their uptime, the slowest servers,
+// and the servers that appeared or disappeared compared to the period before
+func compileDigest(since, until time.Time) (string, error) {
+        maxServers := config().Digest.MaxServers
+        if maxServers <= 0 {
+                maxServers = 10
+        }
+        start, end := since.Unix(), until.Unix()
+
+        var b strings.Builder
+        fmt.Fprintf(&b, "Digest since %s:", since.Format("2006-01-02 15:04"))
+
+        // Outages overlapping the period, cut to it; open outages last until now
+        rows, err := db.Query(`SELECT server, COUNT(*), SUM(MIN(COALESCE(ended, ?), ?) - MAX(started, ?)) AS downtime FROM outages
+                WHERE started < ? AND (ended IS NULL OR ended > ?) GROUP BY server ORDER BY downtime DESC`,
+                end, end, start, end, start)
+        if err != nil {
+                return "", err
+        }
+        type serverDowntime struct {
+                server           string
+                outages, seconds int64
+        }
+        var down []serverDowntime
+        for rows.Next() {
+                var entry serverDowntime
+                if err := rows.Scan(&entry.server, &entry.outages, &entry.seconds); err != nil {
+                        rows.Close()
+                        return "", err
+                }
+                down = append(down, entry)
+        }
+        rows.Close()
+
+        // The uptime queries need the store's only connection, so they run once the outages are read
+        var downtime []string
+        for _, entry := range down {
+                line := fmt.Sprintf("%s - down %s (%d outages)", entry.server, formatDowntime(time.Duration(entry.seconds)*time.Second), entry.outages)
+                if uptime := uptimeSummary(entry.server); uptime != "" {
+                        line += fmt.Sprintf(" (%s)", uptime)
+                }
+                downtime = append(downtime, withImpact(line, entry.server))
+        }
+        if len(downtime) == 0 {
+                b.WriteString("\nNo downtime.")
+        } else {
+                fmt.Fprintf(&b, "\n%d servers with downtime:\n%s", len(downtime), strings.Join(limitLines(downtime, maxServers), "\n"))
+        }
+
+        // The slowest answers of servers that were up
+        rows, err = db.Query(`SELECT server, MAX(latency_ms) AS worst FROM checks WHERE ts >= ? AND ts < ? AND status != 'Failed'
+                GROUP BY server ORDER BY worst DESC LIMIT ?`, start, end, maxServers)
+        if err != nil {
+                return "", err
+        }
+        var latency []string
+        for rows.Next() {
+                var server string
+                var worst int64
+                if err := rows.Scan(&server, &worst); err != nil {
+                        rows.Close()
+                        return "", err
+                }
+                latency = append(latency, fmt.Sprintf("%s - %s", server, (time.Duration(worst)*time.Millisecond).String()))
+        }
+        rows.Close()
+        if len(latency) > 0 {
+                fmt.Fprintf(&b, "\nWorst latency:\n%s", strings.Join(latency, "\n"))
+        }
+
+        if eol := eolServers(); len(eol) > 0 {
+                fmt.Fprintf(&b, "\nServers running end-of-life releases:\n%s", strings.Join(limitLines(eol, maxServers), "\n"))
+        }
+
+        // Servers checked in this period but not the one before it, and the other way round
+        previous, err := checkedServers(start-(end-start), start)
+        if err != nil {
+                return "", err
+        }
+        current, err := checkedServers(start, end)
+        if err != nil {
+                return "", err
+        }
+        var appeared, disappeared []string
+        for server := range current {
+                if !previous[server] {
+                        appeared = append(appeared, server)
+                }
+        }
+        for server := range previous {
+                if !current[server] {
+                        disappeared = append(disappeared, server)
+                }
+        }
+        sort.Strings(appeared)
+        sort.Strings(disappeared)
+        if len(previous) > 0 && len(appeared) > 0 {
+                fmt.Fprintf(&b, "\nNew servers: %s", strings.Join(limitLines(appeared, maxServers), ", "))
+        }
+        if len(disappeared) > 0 {
+                fmt.Fprintf(&b, "\nServers gone: %s", strings.Join(limitLines(disappeared, maxServers), ", "))
+        }
+        return b.String(), nil
+}
+
+// checkedServers returns the servers with a check result in a period
+func checkedServers(start, end int64) (map[string]bool, error) {
+        rows, err := db.Query(`SELECT DISTINCT server FROM checks WHERE ts >= ? AND ts < ?`, start, end)
+        if err != nil {
+                return nil, err
+        }
+        defer rows.Close()
+        servers := make(map[string]bool)
+        for rows.Next() {
+                var server string
+                if err := rows.Scan(&server); err != nil {
+                        return nil, err
+                }
+                servers[server] = true
+        }
+        return servers, rows.Err()
+}
+
+// limitLines keeps the first max lines, noting how many were left out
+func limitLines(lines []string, max int) []string {
+        if len(lines) <= max {
+                return lines
+        }
+        return append(lines[:max:max], fmt.Sprintf("... and %d more", len(lines)-max))
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+        "context"
+        "errors"
+        "fmt"
+
+        "go.mau.fi/util/dbutil"
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/crypto"
+        "maunium.net/go/mautrix/crypto/backup"
+        "maunium.net/go/mautrix/crypto/cryptohelper"
+        "maunium.net/go/mautrix/event"
+        "maunium.net/go/mautrix/id"
+        "maunium.net/go/mautrix/sqlstatestore"
+)
+
+// EncryptionConfig enables end-to-end encryption, so the log rooms can be encrypted
+type EncryptionConfig struct {
+        Enabled     bool   `yaml:"enabled"`
+        PickleKey   string `yaml:"pickle_key"`   // Secret protecting the encryption keys in the database
+        RecoveryKey string `yaml:"recovery_key"` // Secret storage recovery key, used to verify the bot's device and restore its key backup
+        Bootstrap   bool   `yaml:"bootstrap"`    // Create cross-signing keys and secret storage if the account has none
+}
+
+// cryptoHelper handles encryption when it is enabled
+var cryptoHelper *cryptohelper.CryptoHelper
+
+// loginEncrypted logs in through the crypto helper, which keeps its keys in the store and reuses
+// the device they belong to across restarts, then sets up cross-signing and the key backup
+func loginEncrypted(ctx context.Context, client *mautrix.Client, login *mautrix.ReqLogin) error {
+        if config().Encryption.PickleKey == "" {
+                return fmt.Errorf("encryption.pickle_key is required when encryption is enabled")
+        }
+
+        cryptoDB, err := dbutil.NewWithDB(db, "sqlite3")
+        if err != nil {
+                return err
+        }
+        stateStore := sqlstatestore.NewSQLStateStore(cryptoDB, dbutil.NoopLogger, false)
+        if err := stateStore.Upgrade(ctx); err != nil {
+                return fmt.Errorf("failed to set up the state store: %w", err)
+        }
+        client.StateStore = stateStore
+
+        helper, err := cryptohelper.NewCryptoHelper(client, []byte(config().Encryption.PickleKey), cryptoDB)
+        if err != nil {
+                return err
+        }
+        helper.LoginAs = login
+        if err := helper.Init(ctx); err != nil {
+                return fmt.Errorf("failed to set up encryption: %w", err)
+        }
+        client.Crypto = helper
+        cryptoHelper = helper
+        fmt.Printf("Encryption enabled for device %s\n", client.DeviceID)
+
+        setupCrossSigning(ctx, helper.Machine())
+        return nil
+}
+
+// prepareEncryptedRoom loads the encryption state and members of an encrypted log room into the state store,
+// so the first message is already encrypted and its session shared with every member's devices
+func prepareEncryptedRoom(ctx context.Context, client *mautrix.Client, roomID id.RoomID) error {
+        var encryption event.EncryptionEventContent
+        err := roomState(ctx, client, roomID, event.StateEncryption, "", &encryption)
+        if errors.Is(err, mautrix.MNotFound) {
+                return nil
+        } else if err != nil {
+                return fmt.Errorf("failed to read the encryption state of log room %s: %w", roomID, err)
+        }
+        if cryptoHelper == nil {
+                return fmt.Errorf("log room %s is encrypted, set encryption.enabled to post there", roomID)
+        }
+        if err := client.StateStore.SetEncryptionEvent(ctx, roomID, &encryption); err != nil {
+                return fmt.Errorf("failed to store the encryption state of log room %s: %w", roomID, err)
+        }
+        if _, err := client.Members(ctx, roomID); err != nil {
+                return fmt.Errorf("failed to load the members of encrypted log room %s: %w", roomID, err)
+        }
+        return nil
+}
+
+// setupCrossSigning verifies the bot's device with the recovery key and restores the key backup,
+// or creates cross-signing keys for an account that has none if bootstrap is enabled
+func setupCrossSigning(ctx context.Context, mach *crypto.OlmMachine) {
+        if recoveryKey := config().Encryption.RecoveryKey; recoveryKey != "" {
+                if err := mach.VerifyWithRecoveryKey(ctx, recoveryKey); err != nil {
+                        fmt.Println("Failed to verify the device with the recovery key:", err)
+                } else {
+                        fmt.Println("Device verified with cross-signing.")
+                }
+                restoreKeyBackup(ctx, mach, recoveryKey)
+                return
+        }
+
+        if !config().Encryption.Bootstrap {
+                return
+        }
+        if mach.GetOwnCrossSigningPublicKeys(ctx) != nil {
+                fmt.Println("The account already has cross-signing keys, set encryption.recovery_key to verify the device")
+                return
+        }
+
+        recoveryKey, _, err := mach.GenerateAndUploadCrossSigningKeysWithPassword(ctx, config().Password, "")
+        if err != nil {
+                fmt.Println("Failed to create cross-signing keys:", err)
+                return
+        }
+        if err := mach.SignOwnMasterKey(ctx); err != nil {
+                fmt.Println("Failed to sign the master key:", err)
+        }
+        if err := mach.SignOwnDevice(ctx, mach.OwnIdentity()); err != nil {
+                fmt.Println("Failed to sign the device:", err)
+        }
+        fmt.Printf("Created cross-signing keys. Save this recovery key as encryption.recovery_key: %s\n", recoveryKey)
+}
+
+// restoreKeyBackup imports the room keys from the server-side key backup, using the backup key from secret storage
+func restoreKeyBackup(ctx context.Context, mach *crypto.OlmMachine, recoveryKey string) {
+        keyID, keyData, err := mach.SSSS.GetDefaultKeyData(ctx)
+        if err != nil {
+                fmt.Println("No secret storage found, skipping key backup:", err)
+                return
+        }
+        key, err := keyData.VerifyRecoveryKey(keyID, recoveryKey)
+        if err != nil {
+                fmt.Println("Invalid recovery key:", err)
+                return
+        }
+        data, err := mach.SSSS.GetDecryptedAccountData(ctx, event.AccountDataMegolmBackupKey, key)
+        if err != nil {
+                fmt.Println("No key backup key in secret storage:", err)
+                return
+        }
+        backupKey, err := backup.MegolmBackupKeyFromBytes(data)
+        if err != nil {
+                fmt.Println("Invalid key backup key:", err)
+                return
+        }
+        version, err := mach.DownloadAndStoreLatestKeyBackup(ctx, backupKey)
+        if err != nil {
+                fmt.Println("Failed to restore the key backup:", err)
+                return
+        }
+        fmt.Printf("Restored room keys from key backup version %s\n", version)
+}
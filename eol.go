@@ -0,0 +1,149 @@
+package main
+
+import (
+        "context"
+        "encoding/json"
+        "fmt"
+        "net/http"
+        "sort"
+        "strings"
+        "sync"
+        "time"
+)
+
+// EOLConfig controls flagging servers that run end-of-life releases of their software
+type EOLConfig struct {
+        Enabled  bool              `yaml:"enabled"`
+        URL      string            `yaml:"url"`      // JSON object of software name to oldest supported version, replacing the bundled list
+        Interval int               `yaml:"interval"` // Refresh interval of the URL in seconds, defaults to a day
+        Versions map[string]string `yaml:"versions"` // Oldest supported version per software, overriding the bundled or fetched list
+}
+
+// bundledEOLVersions is the oldest release of each server software still receiving security fixes, as of this
+// build. Older releases are flagged as end of life; other software is never flagged.
+var bundledEOLVersions = map[string]string{
+        "synapse":  "1.98.0",
+        "dendrite": "0.13.7",
+        "conduit":  "0.7.0",
+}
+
+var (
+        eolMu       sync.RWMutex
+        eolVersions = bundledEOLVersions
+)
+
+// startEOLSync fetches the end-of-life list once and then keeps refreshing it in the background, if a URL is set
+func startEOLSync(ctx context.Context) {
+        if !config().EOL.Enabled || config().EOL.URL == "" {
+                return
+        }
+
+        refreshEOL(ctx)
+
+        interval := time.Duration(config().EOL.Interval) * time.Second
+        if interval <= 0 {
+                interval = 24 * time.Hour
+        }
+        go func() {
+                ticker := time.NewTicker(interval)
+                defer ticker.Stop()
+                for {
+                        select {
+                        case <-ctx.Done():
+                                return
+                        case <-ticker.C:
+                                refreshEOL(ctx)
+                        }
+                }
+        }()
+}
+
+// refreshEOL downloads the end-of-life list and replaces the current one; on failure the previous list is kept
+func refreshEOL(ctx context.Context) {
+        versions, err := fetchEOL(ctx, config().EOL.URL)
+        if err != nil {
+                fmt.Println("Failed to fetch the end-of-life list:", err)
+                return
+        }
+        fresh := make(map[string]string, len(versions))
+        for software, version := range versions {
+                fresh[strings.ToLower(software)] = version
+        }
+        eolMu.Lock()
+        eolVersions = fresh
+        eolMu.Unlock()
+        fmt.Printf("End-of-life list refreshed: %d server implementations\n", len(fresh))
+}
+
+// fetchEOL downloads an end-of-life list, e.g. {"synapse": "1.98.0", "dendrite": "0.13.7"}
+func fetchEOL(ctx context.Context, url string) (map[string]string, error) {
+        req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+        if err != nil {
+                return nil, err
+        }
+        client := &http.Client{Timeout: 30 * time.Second}
+        resp, err := client.Do(req)
+        if err != nil {
+                return nil, err
+        }
+        defer resp.Body.Close()
+
+        if resp.StatusCode != http.StatusOK {
+                return nil, fmt.Errorf("unexpected status %s", resp.Status)
+        }
+        var versions map[string]string
+        if err := json.NewDecoder(resp.Body).Decode(&versions); err != nil {
+                return nil, err
+        }
+        return versions, nil
+}
+
+// oldestSupportedVersion returns the oldest supported release of a server software, if known
+func oldestSupportedVersion(software string) (string, bool) {
+        software = strings.ToLower(software)
+        for name, version := range config().EOL.Versions {
+                if strings.ToLower(name) == software {
+                        return version, true
+                }
+        }
+        eolMu.RLock()
+        defer eolMu.RUnlock()
+        version, ok := eolVersions[software]
+        return version, ok
+}
+
+// eolNote describes a server running an end-of-life release as of its latest check, e.g.
+// "end of life: Synapse 1.80.0, supported from 1.98.0", or returns "" if it doesn't or isn't known to
+func eolNote(server string) string {
+        if !config().EOL.Enabled {
+                return ""
+        }
+        result, ok := latestResult(server)
+        if !ok || len(versionParts(result.Version)) == 0 {
+                return ""
+        }
+        supported, ok := oldestSupportedVersion(result.Software)
+        if !ok || compareVersions(result.Version, supported) >= 0 {
+                return ""
+        }
+        return fmt.Sprintf("end of life: %s %s, supported from %s", result.Software, result.Version, supported)
+}
+
+// eolServers lists the servers whose latest check found an end-of-life release, sorted by name
+func eolServers() []string {
+        resultsMu.RLock()
+        servers := make([]string, 0, len(latestResults))
+        for server := range latestResults {
+                servers = append(servers, server)
+        }
+        resultsMu.RUnlock()
+
+        var lines []string
+        for _, server := range servers {
+                if note := eolNote(server); note != "" {
+                        lines = append(lines, fmt.Sprintf("%s - %s", server, strings.TrimPrefix(note, "end of life: ")))
+                }
+        }
+        sort.Strings(lines)
+        return lines
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+        "fmt"
+        "path"
+        "strings"
+)
+
+// NotifyFilter limits what a notifier receives; each list left empty lets everything through, so an empty filter
+// passes every notification
+type NotifyFilter struct {
+        Severities []string `yaml:"severities"` // critical (failed servers) and/or warning (degraded servers)
+        Tags       []string `yaml:"tags"`       // Server tags, e.g. [corp]; a server needs one of them
+        Servers    []string `yaml:"servers"`    // Server name patterns, e.g. ["*.example.org", "matrix.org"]
+        Rooms      []string `yaml:"rooms"`      // Monitored room IDs the problem was seen in
+}
+
+// statusSeverity returns the severity of a server status: critical for failures, warning for degradations
+func statusSeverity(status string) severity {
+        switch {
+        case strings.HasPrefix(status, "Failed"):
+                return severityCritical
+        case strings.HasPrefix(status, "Warning"):
+                return severityWarning
+        }
+        return severityInfo
+}
+
+// matches reports whether a notification about a server, seen in a room with the given severity, passes the filter.
+// An unknown room ("") only passes filters without rooms.
+func (f NotifyFilter) matches(server, room string, sev severity) bool {
+        if len(f.Severities) > 0 && !containsFold(f.Severities, string(sev)) {
+                return false
+        }
+        if len(f.Rooms) > 0 && !containsFold(f.Rooms, room) {
+                return false
+        }
+        if len(f.Servers) > 0 {
+                found := false
+                for _, pattern := range f.Servers {
+                        if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(server)); ok {
+                                found = true
+                                break
+                        }
+                }
+                if !found {
+                        return false
+                }
+        }
+        if len(f.Tags) > 0 {
+                found := false
+                for _, tag := range f.Tags {
+                        if hasTag(server, tag) {
+                                found = true
+                                break
+                        }
+                }
+                if !found {
+                        return false
+                }
+        }
+        return true
+}
+
+// validate checks the filter's severities and server patterns
+func (f NotifyFilter) validate(notifier string) error {
+        for _, sev := range f.Severities {
+                if !containsFold([]string{string(severityCritical), string(severityWarning)}, sev) {
+                        return fmt.Errorf("invalid severity %q in the filter of %s (use critical or warning)", sev, notifier)
+                }
+        }
+        for _, pattern := range f.Servers {
+                if _, err := path.Match(pattern, ""); err != nil {
+                        return fmt.Errorf("invalid server pattern %q in the filter of %s", pattern, notifier)
+                }
+        }
+        return nil
+}
+
+// validateNotifyFilters checks the filters of every notifier
+func validateNotifyFilters(c *Config) error {
+        if err := c.Alertmanager.Filter.validate("alertmanager"); err != nil {
+                return err
+        }
+        if err := c.Webhooks.OutageFilter.validate("webhooks.outage_url"); err != nil {
+                return err
+        }
+        for room, filter := range c.RoomFilters {
+                if err := filter.validate(room); err != nil {
+                        return err
+                }
+        }
+        return nil
+}
+
+// roomFilter returns the filter of a notification room, which lets everything through if none is configured
+func roomFilter(room string) NotifyFilter {
+        return config().RoomFilters[room]
+}
+
+// containsFold reports whether a list contains a value, ignoring case
+func containsFold(values []string, value string) bool {
+        for _, v := range values {
+                if strings.EqualFold(v, value) {
+                        return true
+                }
+        }
+        return false
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+        "bytes"
+        "context"
+        "fmt"
+        "image"
+        "image/color"
+        "image/png"
+        "strconv"
+        "time"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/event"
+)
+
+// HeatmapConfig controls the weekly availability digest with heatmap images
+type HeatmapConfig struct {
+        Enabled    bool `yaml:"enabled"`
+        MaxServers int  `yaml:"max_servers"` // Heatmaps per digest, for the servers with the most downtime; defaults to 5
+}
+
+const (
+        heatmapDays     = 7
+        heatmapCell     = 16 // Cell size in pixels
+        heatmapInterval = 7 * 24 * time.Hour
+)
+
+// recordHourlyAvailability counts a check towards the server's availability in the current hour
+func recordHourlyAvailability(server string, online bool) {
+        hour := time.Now().Truncate(time.Hour).Unix()
+        success := 0
+        if online {
+                success = 1
+        }
+        if _, err := db.Exec(`INSERT INTO server_hourly (server, hour, checks, successes) VALUES (?, ?, 1, ?)
+                ON CONFLICT (server, hour) DO UPDATE SET checks = checks + 1, successes = successes + excluded.successes`,
+                server, hour, success); err != nil {
+                fmt.Printf("Failed to record availability of %s: %v\n", server, err)
+        }
+}
+
+// sendWeeklyDigest posts the availability digest with heatmaps of the servers with the most downtime, once a week
+func sendWeeklyDigest(ctx context.Context, client *mautrix.Client) {
+        if !config().Heatmap.Enabled {
+                return
+        }
+        // The first digest comes a week after enabling it, once there is a week of data
+        now := time.Now()
+        last, err := strconv.ParseInt(loadState("heatmap_last"), 10, 64)
+        if err == nil && now.Sub(time.Unix(last, 0)) < heatmapInterval {
+                return
+        }
+        if err := saveState("heatmap_last", strconv.FormatInt(now.Unix(), 10)); err != nil {
+                fmt.Println("Failed to save the digest time:", err)
+        }
+        if last == 0 {
+                return
+        }
+
+        // Whole days (UTC), up to and including today
+        since := now.UTC().Truncate(24 * time.Hour).Add(-(heatmapDays - 1) * 24 * time.Hour)
+        db.Exec(`DELETE FROM server_hourly WHERE hour < ?`, since.Unix())
+
+        maxServers := config().Heatmap.MaxServers
+        if maxServers <= 0 {
+                maxServers = 5
+        }
+        rows, err := db.Query(`SELECT server, SUM(checks), SUM(successes) FROM server_hourly WHERE hour >= ?
+                GROUP BY server HAVING SUM(successes) < SUM(checks) ORDER BY 1.0 * SUM(successes) / SUM(checks) ASC LIMIT ?`,
+                since.Unix(), maxServers)
+        if err != nil {
+                fmt.Println("Failed to compile the weekly digest:", err)
+                return
+        }
+        type serverDowntime struct {
+                server            string
+                checks, successes int
+        }
+        var servers []serverDowntime
+        for rows.Next() {
+                var s serverDowntime
+                if err := rows.Scan(&s.server, &s.checks, &s.successes); err == nil {
+                        servers = append(servers, s)
+                }
+        }
+        rows.Close()
+
+        if len(servers) == 0 {
+                sendReport(ctx, client, severityInfo, "Weekly availability digest: no downtime in the last week")
+                return
+        }
+
+        var lines []string
+        for _, s := range servers {
+                lines = append(lines, withImpact(fmt.Sprintf("%s - %.1f%% available over %d checks", s.server, 100*float64(s.successes)/float64(s.checks), s.checks), s.server))
+        }
+        sendReport(ctx, client, severityInfo, fmt.Sprintf("Weekly availability digest, servers with the most downtime (heatmaps: one row per day, one column per hour UTC):\n%s", reportLines(lines)))
+
+        for _, s := range servers {
+                data, err := renderHeatmap(s.server, since)
+                if err != nil {
+                        fmt.Printf("Failed to render heatmap for %s: %v\n", s.server, err)
+                        continue
+                }
+                if err := sendImage(ctx, client, fmt.Sprintf("%s-availability.png", s.server), data); err != nil {
+                        fmt.Printf("Failed to upload heatmap for %s: %v\n", s.server, err)
+                }
+        }
+}
+
+// renderHeatmap draws a server's hourly availability since the start of the given day as a PNG,
+// with a row per day and a column per hour; hours without checks are gray
+func renderHeatmap(server string, since time.Time) ([]byte, error) {
+        rows, err := db.Query(`SELECT hour, checks, successes FROM server_hourly WHERE server = ? AND hour >= ?`, server, since.Unix())
+        if err != nil {
+                return nil, err
+        }
+        defer rows.Close()
+
+        img := image.NewRGBA(image.Rect(0, 0, 24*heatmapCell, heatmapDays*heatmapCell))
+        fill := func(day, hour int, c color.Color) {
+                for y := day*heatmapCell + 1; y < (day+1)*heatmapCell; y++ {
+                        for x := hour*heatmapCell + 1; x < (hour+1)*heatmapCell; x++ {
+                                img.Set(x, y, c)
+                        }
+                }
+        }
+        for day := 0; day < heatmapDays; day++ {
+                for hour := 0; hour < 24; hour++ {
+                        fill(day, hour, color.RGBA{220, 220, 220, 255})
+                }
+        }
+
+        for rows.Next() {
+                var hour int64
+                var checks, successes int
+                if err := rows.Scan(&hour, &checks, &successes); err != nil || checks == 0 {
+                        continue
+                }
+                t := time.Unix(hour, 0).UTC()
+                day := int(t.Sub(since) / (24 * time.Hour))
+                if day < 0 || day >= heatmapDays {
+                        continue
+                }
+                // From red (always down) to green (always up)
+                availability := float64(successes) / float64(checks)
+                fill(day, t.Hour(), color.RGBA{uint8(220 * (1 - availability)), uint8(180 * availability), 40, 255})
+        }
+        if err := rows.Err(); err != nil {
+                return nil, err
+        }
+
+        var buf bytes.Buffer
+        if err := png.Encode(&buf, img); err != nil {
+                return nil, err
+        }
+        return buf.Bytes(), nil
+}
+
+// sendImage uploads a PNG and posts it to the info log room
+func sendImage(ctx context.Context, client *mautrix.Client, name string, data []byte) error {
+        upload, err := client.UploadBytes(ctx, data, "image/png")
+        if err != nil {
+                return err
+        }
+        _, err = client.SendMessageEvent(ctx, logRoomFor(severityInfo), event.EventMessage, &event.MessageEventContent{
+                MsgType: event.MsgImage,
+                Body:    name,
+                URL:     upload.ContentURI.CUString(),
+                Info: &event.FileInfo{
+                        MimeType: "image/png",
+                        Width:    24 * heatmapCell,
+                        Height:   heatmapDays * heatmapCell,
+                        Size:     len(data),
+                },
+        })
+        return err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+        "fmt"
+        "strings"
+        "time"
+)
+
+// historyPruneInterval is how often check results past the retention are deleted
+const historyPruneInterval = time.Hour
+
+// lastHistoryPrune is when old check results were last deleted
+var lastHistoryPrune time.Time
+
+// historyRetention returns how long check results are kept
+func historyRetention() time.Duration {
+        days := config().Database.RetentionDays
+        if days <= 0 {
+                days = 90
+        }
+        return time.Duration(days) * 24 * time.Hour
+}
+
+// failureReason returns the explanation in a status, e.g. "Unreachable" for "Failed (Unreachable)"
+func failureReason(status string) string {
+        start, end := strings.Index(status, "("), strings.LastIndex(status, ")")
+        if start < 0 || end < start {
+                return ""
+        }
+        return status[start+1 : end]
+}
+
+// recordCheck stores a check result in the history; room is empty for checks outside a room's cycle
+func recordCheck(server, room, status string, probe probeResult) {
+        if _, err := db.Exec(`INSERT INTO checks (server, room, status, reason, latency_ms, ts) VALUES (?, ?, ?, ?, ?, ?)`,
+                server, room, statusClass(status), failureReason(status), probe.Latency.Milliseconds(), time.Now().Unix()); err != nil {
+                fmt.Printf("Failed to record the check of %s: %v\n", server, err)
+        }
+}
+
+// pruneHistory deletes check results older than the retention, at most once per prune interval
+func pruneHistory() {
+        if time.Since(lastHistoryPrune) < historyPruneInterval {
+                return
+        }
+        lastHistoryPrune = time.Now()
+        result, err := db.Exec(`DELETE FROM checks WHERE ts < ?`, time.Now().Add(-historyRetention()).Unix())
+        if err != nil {
+                fmt.Println("Failed to prune the check history:", err)
+                return
+        }
+        if n, _ := result.RowsAffected(); n > 0 {
+                fmt.Printf("Pruned %d check results older than %d days\n", n, int(historyRetention().Hours()/24))
+        }
+}
+
+// restoreResults loads each server's latest check from the history, so results survive restarts.
+// Availability counters still start at zero, as they cover the time since startup.
+func restoreResults() error {
+        rows, err := db.Query(`SELECT c.server, c.status, c.reason, c.latency_ms, c.ts FROM checks c
+                JOIN (SELECT server, MAX(ts) AS ts FROM checks GROUP BY server) latest ON latest.server = c.server AND latest.ts = c.ts`)
+        if err != nil {
+                return err
+        }
+        defer rows.Close()
+
+        resultsMu.Lock()
+        defer resultsMu.Unlock()
+        for rows.Next() {
+                var server, class, reason string
+                var latency, ts int64
+                if err := rows.Scan(&server, &class, &reason, &latency, &ts); err != nil {
+                        return err
+                }
+                status := class
+                if reason != "" {
+                        status = fmt.Sprintf("%s (%s)", class, reason)
+                }
+                latestResults[server] = serverResult{
+                        Server:    server,
+                        Status:    status,
+                        Online:    class == "OK" || class == "Warning",
+                        Latency:   time.Duration(latency) * time.Millisecond,
+                        CheckedAt: time.Unix(ts, 0),
+                }
+        }
+        return rows.Err()
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "html"
+        "strings"
+        "time"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/event"
+        "maunium.net/go/mautrix/id"
+)
+
+// Report formats: html sends room reports as a table (org.matrix.custom.html) with the plain text as fallback,
+// plain sends the plain text only
+const (
+        reportFormatHTML  = "html"
+        reportFormatPlain = "plain"
+)
+
+// reportFormat returns the configured report format
+func reportFormat() string {
+        if config().ReportFormat == "" {
+                return reportFormatHTML
+        }
+        return config().ReportFormat
+}
+
+// validateReportFormat checks that report_format is known
+func validateReportFormat(c *Config) error {
+        switch c.ReportFormat {
+        case "", reportFormatHTML, reportFormatPlain:
+                return nil
+        }
+        return fmt.Errorf("unknown report_format %q (expected html or plain)", c.ReportFormat)
+}
+
+// reportRow is a server in a room report
+type reportRow struct {
+        Server string `json:"server"`
+        Status string `json:"status"`
+        Line   string `json:"line"` // The server's line in the plain text report, e.g. "example.org - Failed (Unreachable) [critical]"
+}
+
+// newReportRow returns a server's row, with its line as formatServerLine writes it plus an optional note
+func newReportRow(server, status, note string) reportRow {
+        line := formatServerLine(server, status)
+        if note != "" {
+                line = fmt.Sprintf("%s (%s)", line, note)
+        }
+        return reportRow{Server: server, Status: status, Line: line}
+}
+
+// rowLines returns the plain text lines of report rows
+func rowLines(rows []reportRow) []string {
+        lines := make([]string, len(rows))
+        for i, row := range rows {
+                lines[i] = row.Line
+        }
+        return lines
+}
+
+// statusEmoji marks a status in formatted reports
+func statusEmoji(status string) string {
+        switch statusClass(status) {
+        case "OK":
+                return "🟢"
+        case "Warning":
+                return "🟡"
+        case "Maintenance":
+                return "🔧"
+        }
+        return "🔴"
+}
+
+// reportHTML formats a room report as a header with a table of its servers' status, latency and failure reason,
+// followed by an optional footer. Rows past the report_lines limit are left out, as in plain reports.
+func reportHTML(header string, rows []reportRow, footer string) string {
+        var b strings.Builder
+        fmt.Fprintf(&b, "<h4>%s</h4>", html.EscapeString(header))
+        if len(rows) > 0 {
+                b.WriteString("<table><thead><tr><th>Server</th><th>Status</th><th>Latency</th><th>Reason</th></tr></thead><tbody>")
+                shown := rows
+                if max := effectiveLimit(config().Limits.ReportLines, lowMemoryLimits.ReportLines); max > 0 && len(rows) > max {
+                        shown = rows[:max]
+                }
+                for _, row := range shown {
+                        latency := "-"
+                        if result, ok := latestResult(row.Server); ok && result.Online {
+                                latency = result.Latency.Round(time.Millisecond).String()
+                        }
+                        // Whatever the plain line adds after the status (criticality, contact, notes, ...) goes with the reason
+                        reason := failureReason(row.Status)
+                        extra := strings.TrimPrefix(row.Line, fmt.Sprintf("%s - %s", row.Server, row.Status))
+                        if note := latencyNote(row.Server, row.Status); note != "" {
+                                extra = strings.Replace(extra, note, "", 1) // Shown in its own column
+                        }
+                        if extra = strings.TrimSpace(extra); extra != "" {
+                                reason = strings.TrimSpace(reason + " " + extra)
+                        }
+                        fmt.Fprintf(&b, "<tr><td>%s</td><td>%s %s</td><td>%s</td><td>%s</td></tr>", html.EscapeString(row.Server),
+                                statusEmoji(row.Status), html.EscapeString(statusClass(row.Status)), latency, html.EscapeString(reason))
+                }
+                b.WriteString("</tbody></table>")
+                if len(shown) < len(rows) {
+                        fmt.Fprintf(&b, "<p>... and %d more</p>", len(rows)-len(shown))
+                }
+        }
+        if footer != "" {
+                fmt.Fprintf(&b, "<p>%s</p>", html.EscapeString(footer))
+        }
+        return b.String()
+}
+
+// sendRoomReportRows sends a room report about servers, formatted as a table unless plain reports are configured.
+// The plain text, a header line followed by the servers' lines and the footer, is the fallback body.
+func sendRoomReportRows(ctx context.Context, client *mautrix.Client, roomID id.RoomID, sev severity, header string, rows []reportRow, footer string) error {
+        header, rows, footer, ok := applyReportPipeline(ctx, roomID, sev, header, rows, footer)
+        if !ok {
+                return nil
+        }
+        plain := reportPlain(header, rows, footer)
+        if reportFormat() == reportFormatPlain {
+                return sendRoomReport(ctx, client, roomID, sev, plain)
+        }
+        return sendHTMLToRoom(ctx, client, roomReportTarget(roomID, sev), plain, reportHTML(header, rows, footer))
+}
+
+// reportPlain formats a room report as plain text: a header line followed by the servers' lines and the footer
+func reportPlain(header string, rows []reportRow, footer string) string {
+        plain := header
+        if len(rows) > 0 {
+                plain += ":\n" + reportLines(rowLines(rows))
+        }
+        if footer != "" {
+                plain += "\n" + footer
+        }
+        return plain
+}
+
+// sendHTMLToRoom sends a formatted message with a plain text fallback
+func sendHTMLToRoom(ctx context.Context, client *mautrix.Client, roomID id.RoomID, plain, formatted string) error {
+        _, err := sendMessageContent(ctx, client, roomID, htmlContent(roomID, plain, formatted))
+        return err
+}
+
+// htmlContent returns a formatted message's content, with a plain text fallback
+func htmlContent(roomID id.RoomID, plain, formatted string) *event.MessageEventContent {
+        return &event.MessageEventContent{
+                MsgType:       msgTypeFor(roomID),
+                Body:          plain,
+                Format:        event.FormatHTML,
+                FormattedBody: formatted,
+        }
+}
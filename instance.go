@@ -0,0 +1,104 @@
+package main
+
+import (
+        "context"
+        "crypto/rand"
+        "encoding/hex"
+        "encoding/json"
+        "fmt"
+        "os"
+        "time"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/id"
+)
+
+const (
+        heartbeatInterval  = 30 * time.Second
+        heartbeatEventType = "net.daedric.matrix_health.heartbeat" // Account data holding the heartbeat
+)
+
+// heartbeat is written regularly by a running instance to the account data and to the database,
+// so a second instance sharing either of them notices it
+type heartbeat struct {
+        Instance  string      `json:"instance"`
+        Host      string      `json:"host"`
+        DeviceID  id.DeviceID `json:"device_id"`
+        Timestamp int64       `json:"ts"`
+}
+
+// instanceID identifies this process in heartbeats
+var instanceID = newInstanceID()
+
+func newInstanceID() string {
+        b := make([]byte, 8)
+        rand.Read(b)
+        return hex.EncodeToString(b)
+}
+
+// readHeartbeats returns the heartbeats currently stored in the account and in the database
+func readHeartbeats(ctx context.Context, client *mautrix.Client) (account, store heartbeat) {
+        if err := client.GetAccountData(ctx, heartbeatEventType, &account); err != nil {
+                account = heartbeat{}
+        }
+        json.Unmarshal([]byte(loadState("heartbeat")), &store)
+        return account, store
+}
+
+// checkDuplicateInstance fails if another instance is running with the same account or database.
+// A recent heartbeat may be left behind by an instance that just stopped, so it only counts if it is renewed.
+func checkDuplicateInstance(ctx context.Context, client *mautrix.Client) error {
+        recent := func(hb heartbeat) bool {
+                return hb.Instance != "" && hb.Instance != instanceID && time.Since(time.Unix(hb.Timestamp, 0)) < 2*heartbeatInterval
+        }
+        account, store := readHeartbeats(ctx, client)
+        if !recent(account) && !recent(store) {
+                return nil
+        }
+
+        fmt.Println("Found a recent heartbeat of another instance, waiting to see whether it is still running...")
+        select {
+        case <-ctx.Done():
+                return ctx.Err()
+        case <-time.After(heartbeatInterval + 10*time.Second):
+        }
+
+        laterAccount, laterStore := readHeartbeats(ctx, client)
+        switch {
+        case recent(laterAccount) && laterAccount.Timestamp != account.Timestamp:
+                return fmt.Errorf("another instance is running with this account (host %s, device %s)", laterAccount.Host, laterAccount.DeviceID)
+        case recent(laterStore) && laterStore.Timestamp != store.Timestamp:
+                return fmt.Errorf("another instance is running with this database (host %s, device %s)", laterStore.Host, laterStore.DeviceID)
+        }
+        return nil
+}
+
+// startHeartbeat writes this instance's heartbeat regularly in the background
+func startHeartbeat(ctx context.Context, client *mautrix.Client) {
+        host, _ := os.Hostname()
+        beat := func() {
+                hb := heartbeat{Instance: instanceID, Host: host, DeviceID: client.DeviceID, Timestamp: time.Now().Unix()}
+                if err := client.SetAccountData(ctx, heartbeatEventType, hb); err != nil {
+                        fmt.Println("Failed to write heartbeat to the account:", err)
+                }
+                if data, err := json.Marshal(hb); err == nil {
+                        if err := saveState("heartbeat", string(data)); err != nil {
+                                fmt.Println("Failed to write heartbeat to the database:", err)
+                        }
+                }
+        }
+
+        beat()
+        go func() {
+                ticker := time.NewTicker(heartbeatInterval)
+                defer ticker.Stop()
+                for {
+                        select {
+                        case <-ctx.Done():
+                                return
+                        case <-ticker.C:
+                                beat()
+                        }
+                }
+        }()
+}
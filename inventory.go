@@ -0,0 +1,157 @@
+package main
+
+import (
+        "context"
+        "encoding/csv"
+        "encoding/json"
+        "fmt"
+        "io"
+        "net/http"
+        "strings"
+        "sync"
+        "time"
+)
+
+// InventoryConfig points at an external document describing monitored servers
+type InventoryConfig struct {
+        URL      string `yaml:"url"`      // JSON or CSV document with server metadata
+        Interval int    `yaml:"interval"` // Refresh interval in seconds
+}
+
+// inventoryEntry is one server as described by the external inventory
+type inventoryEntry struct {
+        Server      string   `json:"server"`
+        Tags        []string `json:"tags"`
+        Contact     string   `json:"contact"`
+        Criticality string   `json:"criticality"`
+        Impact      string   `json:"impact"`
+}
+
+var (
+        inventoryMu sync.RWMutex
+        inventory   map[string]inventoryEntry
+)
+
+// startInventorySync fetches the inventory once and then keeps refreshing it in the background
+func startInventorySync(ctx context.Context) {
+        if config().Inventory.URL == "" {
+                return
+        }
+
+        refreshInventory(ctx)
+
+        interval := time.Duration(config().Inventory.Interval) * time.Second
+        if interval <= 0 {
+                interval = time.Hour
+        }
+        go func() {
+                ticker := time.NewTicker(interval)
+                defer ticker.Stop()
+                for {
+                        select {
+                        case <-ctx.Done():
+                                return
+                        case <-ticker.C:
+                                refreshInventory(ctx)
+                        }
+                }
+        }()
+}
+
+// refreshInventory downloads the inventory and replaces the current one; on failure the previous inventory is kept
+func refreshInventory(ctx context.Context) {
+        entries, err := fetchInventory(ctx, config().Inventory.URL)
+        if err != nil {
+                fmt.Println("Failed to fetch server inventory:", err)
+                return
+        }
+
+        fresh := make(map[string]inventoryEntry, len(entries))
+        for _, entry := range entries {
+                if entry.Server == "" {
+                        continue
+                }
+                fresh[strings.ToLower(entry.Server)] = entry
+        }
+
+        inventoryMu.Lock()
+        inventory = fresh
+        inventoryMu.Unlock()
+        fmt.Printf("Loaded %d servers from inventory\n", len(fresh))
+}
+
+// fetchInventory downloads and parses the inventory document, as CSV or JSON
+func fetchInventory(ctx context.Context, url string) ([]inventoryEntry, error) {
+        req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+        if err != nil {
+                return nil, err
+        }
+        client := &http.Client{Timeout: 30 * time.Second}
+        resp, err := client.Do(req)
+        if err != nil {
+                return nil, err
+        }
+        defer resp.Body.Close()
+
+        if resp.StatusCode != http.StatusOK {
+                return nil, fmt.Errorf("unexpected status %s", resp.Status)
+        }
+
+        if strings.Contains(resp.Header.Get("Content-Type"), "csv") || strings.HasSuffix(strings.ToLower(url), ".csv") {
+                return parseInventoryCSV(resp.Body)
+        }
+
+        var entries []inventoryEntry
+        if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+                return nil, err
+        }
+        return entries, nil
+}
+
+// parseInventoryCSV parses a CSV inventory with a header row naming the columns
+// (server, tags, contact, criticality, impact); multiple tags are separated by semicolons
+func parseInventoryCSV(r io.Reader) ([]inventoryEntry, error) {
+        records, err := csv.NewReader(r).ReadAll()
+        if err != nil {
+                return nil, err
+        }
+        if len(records) == 0 {
+                return nil, nil
+        }
+
+        columns := make(map[string]int)
+        for i, name := range records[0] {
+                columns[strings.ToLower(strings.TrimSpace(name))] = i
+        }
+        field := func(record []string, name string) string {
+                if i, ok := columns[name]; ok && i < len(record) {
+                        return strings.TrimSpace(record[i])
+                }
+                return ""
+        }
+
+        var entries []inventoryEntry
+        for _, record := range records[1:] {
+                entry := inventoryEntry{
+                        Server:      field(record, "server"),
+                        Contact:     field(record, "contact"),
+                        Criticality: field(record, "criticality"),
+                        Impact:      field(record, "impact"),
+                }
+                for _, tag := range strings.Split(field(record, "tags"), ";") {
+                        if tag = strings.TrimSpace(tag); tag != "" {
+                                entry.Tags = append(entry.Tags, tag)
+                        }
+                }
+                entries = append(entries, entry)
+        }
+        return entries, nil
+}
+
+// inventoryOverride returns the inventory entry for a server, if any
+func inventoryOverride(server string) (inventoryEntry, bool) {
+        inventoryMu.RLock()
+        defer inventoryMu.RUnlock()
+        entry, ok := inventory[strings.ToLower(server)]
+        return entry, ok
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+        "bytes"
+        "context"
+        "crypto/ed25519"
+        "encoding/base64"
+        "encoding/json"
+        "fmt"
+        "io"
+        "net/http"
+        "strings"
+        "time"
+)
+
+// KeyCheckConfig controls the check of each server's signing keys
+type KeyCheckConfig struct {
+        Enabled   bool `yaml:"enabled"`
+        WarnHours int  `yaml:"warn_hours"` // Warn when the keys expire within this many hours, defaults to 24
+}
+
+// checkServerKeys fetches a server's signing keys from /_matrix/key/v2/server on its federation host and returns
+// what is wrong with them: a missing or invalid self-signature, or keys that expired or are about to, "" if nothing
+func checkServerKeys(ctx context.Context, server, host string, timeout time.Duration) string {
+        var redirect string
+        client := newProbeClient(server, timeout, &redirect)
+        req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://%s/_matrix/key/v2/server", host), nil)
+        if err != nil {
+                return "Server keys unreachable"
+        }
+        resp, err := client.Do(req)
+        if err != nil {
+                return "Server keys unreachable"
+        }
+        defer resp.Body.Close()
+        if resp.StatusCode != http.StatusOK {
+                return fmt.Sprintf("Server keys answered %s", resp.Status)
+        }
+
+        var raw map[string]interface{}
+        decoder := json.NewDecoder(io.LimitReader(resp.Body, 1024*1024))
+        decoder.UseNumber()
+        if err := decoder.Decode(&raw); err != nil {
+                return "Invalid server keys JSON"
+        }
+        var keys struct {
+                ServerName   string                          `json:"server_name"`
+                ValidUntilTS int64                           `json:"valid_until_ts"`
+                VerifyKeys   map[string]struct{ Key string } `json:"verify_keys"`
+                Signatures   map[string]map[string]string    `json:"signatures"`
+        }
+        data, _ := json.Marshal(raw)
+        if err := json.Unmarshal(data, &keys); err != nil {
+                return "Invalid server keys JSON"
+        }
+
+        if !strings.EqualFold(keys.ServerName, server) {
+                return fmt.Sprintf("Server keys claim to be %s", keys.ServerName)
+        }
+        if !verifyKeySignature(raw, keys.ServerName, keys.VerifyKeys, keys.Signatures) {
+                return "Server keys not signed by the server"
+        }
+
+        validUntil := time.UnixMilli(keys.ValidUntilTS)
+        warnHours := config().KeyCheck.WarnHours
+        if warnHours <= 0 {
+                warnHours = 24
+        }
+        if time.Now().After(validUntil) {
+                return fmt.Sprintf("Server keys expired %s", validUntil.UTC().Format("2006-01-02 15:04 UTC"))
+        }
+        if time.Until(validUntil) < time.Duration(warnHours)*time.Hour {
+                return fmt.Sprintf("Server keys expire %s", validUntil.UTC().Format("2006-01-02 15:04 UTC"))
+        }
+        return ""
+}
+
+// verifyKeySignature reports whether one of the server's ed25519 verify keys signed the key response
+func verifyKeySignature(raw map[string]interface{}, server string, verifyKeys map[string]struct{ Key string }, signatures map[string]map[string]string) bool {
+        signed := make(map[string]interface{}, len(raw))
+        for key, value := range raw {
+                if key != "signatures" && key != "unsigned" {
+                        signed[key] = value
+                }
+        }
+        message, err := canonicalJSON(signed)
+        if err != nil {
+                return false
+        }
+        for keyID, signature := range signatures[server] {
+                if !strings.HasPrefix(keyID, "ed25519:") {
+                        continue
+                }
+                verifyKey, ok := verifyKeys[keyID]
+                if !ok {
+                        continue
+                }
+                publicKey, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(verifyKey.Key, "="))
+                if err != nil || len(publicKey) != ed25519.PublicKeySize {
+                        continue
+                }
+                sig, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(signature, "="))
+                if err != nil {
+                        continue
+                }
+                if ed25519.Verify(publicKey, message, sig) {
+                        return true
+                }
+        }
+        return false
+}
+
+// canonicalJSON encodes a value as Matrix canonical JSON: sorted keys, no insignificant whitespace, no HTML escaping
+func canonicalJSON(value interface{}) ([]byte, error) {
+        var buf bytes.Buffer
+        encoder := json.NewEncoder(&buf)
+        encoder.SetEscapeHTML(false)
+        if err := encoder.Encode(value); err != nil {
+                return nil, err
+        }
+        return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
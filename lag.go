@@ -0,0 +1,155 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "sort"
+        "sync"
+        "sync/atomic"
+        "time"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/event"
+)
+
+// LagConfig controls federation delivery lag reporting
+type LagConfig struct {
+        Threshold int `yaml:"threshold"` // Median lag in seconds that counts as high, defaults to 30
+        Samples   int `yaml:"samples"`   // Events needed per server before it is judged, defaults to 10
+}
+
+// lagWindow is the number of recent events kept per server
+const lagWindow = 50
+
+// lagSample is the delivery lag of one event, and when we received it
+type lagSample struct {
+        Lag      time.Duration
+        Received time.Time
+}
+
+var (
+        lagMu       sync.Mutex
+        lagSamples  = make(map[string][]lagSample) // Recent delivery lags per originating server
+        lagReported = make(map[string]bool)        // Servers currently reported as lagging
+        lagPaused   atomic.Bool                    // Set while a catch-up sync delivers a backlog of old events
+)
+
+// lagMaxAge is how long a sample counts towards a server's lag: ten check intervals, but at least an hour
+func lagMaxAge() time.Duration {
+        maxAge := 10 * time.Duration(config().Interval) * time.Second
+        if maxAge < time.Hour {
+                maxAge = time.Hour
+        }
+        return maxAge
+}
+
+// expireLagSamples drops samples received before the cutoff
+func expireLagSamples(samples []lagSample, cutoff time.Time) []lagSample {
+        for len(samples) > 0 && samples[0].Received.Before(cutoff) {
+                samples = samples[1:]
+        }
+        return samples
+}
+
+// trackEventLag records how long an event from a remote server took to reach us,
+// comparing its origin_server_ts with the time we received it
+func trackEventLag(evt *event.Event) {
+        if lagPaused.Load() || evt.Sender == "" || evt.Timestamp == 0 {
+                return
+        }
+        if evt.Type.Class != event.MessageEventType && evt.Type.Class != event.StateEventType {
+                return
+        }
+
+        // Events from our own homeserver don't travel over federation
+        server := extractDomain(string(evt.Sender))
+        if server == "" || server == extractDomain(config().Username) {
+                return
+        }
+
+        now := time.Now()
+        lag := now.Sub(time.UnixMilli(evt.Timestamp))
+        if lag < 0 {
+                lag = 0 // Remote clock is ahead of ours
+        }
+
+        lagMu.Lock()
+        defer lagMu.Unlock()
+
+        // Past the tracking limit, new servers are ignored until others expire
+        if _, tracked := lagSamples[server]; !tracked {
+                if max := effectiveLimit(config().Limits.LagServers, lowMemoryLimits.LagServers); max > 0 && len(lagSamples) >= max {
+                        return
+                }
+        }
+        samples := append(expireLagSamples(lagSamples[server], now.Add(-lagMaxAge())), lagSample{Lag: lag, Received: now})
+        if len(samples) > lagWindow {
+                samples = samples[len(samples)-lagWindow:]
+        }
+        lagSamples[server] = samples
+}
+
+// reportFederationLag posts servers whose median delivery lag became high, and those that recovered
+func reportFederationLag(ctx context.Context, client *mautrix.Client) {
+        threshold := time.Duration(config().Lag.Threshold) * time.Second
+        if threshold <= 0 {
+                threshold = 30 * time.Second
+        }
+        minSamples := config().Lag.Samples
+        if minSamples <= 0 {
+                minSamples = 10
+        }
+
+        var lagging, recovered []string
+        cutoff := time.Now().Add(-lagMaxAge())
+        lagMu.Lock()
+        for server, samples := range lagSamples {
+                samples = expireLagSamples(samples, cutoff)
+                if len(samples) == 0 {
+                        delete(lagSamples, server)
+                } else {
+                        lagSamples[server] = samples
+                }
+
+                // Without enough recent events there is nothing to judge, so a lagging server no longer counts as one
+                if len(samples) < minSamples {
+                        if lagReported[server] {
+                                recovered = append(recovered, fmt.Sprintf("%s - no recent slow events", server))
+                                delete(lagReported, server)
+                        }
+                        continue
+                }
+                median := medianLag(samples)
+                high := median >= threshold
+                if high && !lagReported[server] {
+                        lagging = append(lagging, fmt.Sprintf("%s - median lag %s over %d events", server, median.Round(time.Second), len(samples)))
+                } else if !high && lagReported[server] {
+                        recovered = append(recovered, fmt.Sprintf("%s - median lag %s", server, median.Round(time.Second)))
+                }
+                lagReported[server] = high
+        }
+        lagMu.Unlock()
+
+        if len(lagging) > 0 {
+                sort.Strings(lagging)
+                message := fmt.Sprintf("Servers with high federation lag:\n%s", reportLines(lagging))
+                fmt.Println(message)
+                sendReport(ctx, client, severityWarning, message)
+        }
+        if len(recovered) > 0 {
+                sort.Strings(recovered)
+                message := fmt.Sprintf("Federation lag back to normal:\n%s", reportLines(recovered))
+                fmt.Println(message)
+                sendReport(ctx, client, severityInfo, message)
+        }
+}
+
+// medianLag returns the median lag of the given samples
+func medianLag(samples []lagSample) time.Duration {
+        sorted := make([]time.Duration, len(samples))
+        for i, sample := range samples {
+                sorted[i] = sample.Lag
+        }
+        sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+        return sorted[len(sorted)/2]
+}
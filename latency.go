@@ -0,0 +1,169 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "sort"
+        "sync"
+        "time"
+
+        "maunium.net/go/mautrix"
+)
+
+// LatencySLO is a latency objective for the servers with a tag, e.g. 99% of checks answered within 1000ms over 24 hours
+type LatencySLO struct {
+        Tag       string  `yaml:"tag"`       // Servers the objective applies to
+        Latency   int     `yaml:"latency"`   // Latency in milliseconds a check must stay under; failed checks never do
+        Objective float64 `yaml:"objective"` // Percentage of checks that must meet the latency, defaults to 99
+        Window    int     `yaml:"window"`    // Hours the objective is measured over, defaults to 24
+        BurnRate  float64 `yaml:"burn_rate"` // Alert when the error budget burns this many times too fast, defaults to 6
+}
+
+// latencyShortWindow is the recent window a burn must also show up in, so alerts clear soon after recovery
+const latencyShortWindow = time.Hour
+
+// latencySample is the outcome of one check
+type latencySample struct {
+        At      time.Time
+        Latency time.Duration
+        Online  bool
+}
+
+var (
+        latencyMu      sync.Mutex
+        latencySamples = make(map[string][]latencySample) // Recent checks per server
+        sloAlerting    = make(map[string]bool)            // SLO and server pairs currently alerting
+)
+
+// latencyRetention is how long samples are kept: the longest SLO window, but at least a day
+func latencyRetention() time.Duration {
+        retention := 24 * time.Hour
+        for _, slo := range config().LatencySLOs {
+                if window := time.Duration(slo.Window) * time.Hour; window > retention {
+                        retention = window
+                }
+        }
+        return retention
+}
+
+// recordLatency adds a check to the server's latency history
+func recordLatency(server string, result probeResult) {
+        now := time.Now()
+        cutoff := now.Add(-latencyRetention())
+
+        latencyMu.Lock()
+        defer latencyMu.Unlock()
+        samples := latencySamples[server]
+        for len(samples) > 0 && samples[0].At.Before(cutoff) {
+                samples = samples[1:]
+        }
+        latencySamples[server] = append(samples, latencySample{At: now, Latency: result.Latency, Online: result.Online})
+}
+
+// latencyPercentiles returns the 50th, 95th and 99th percentile latency of successful checks within the window
+func latencyPercentiles(server string, window time.Duration) (p50, p95, p99 time.Duration, n int) {
+        cutoff := time.Now().Add(-window)
+        var latencies []time.Duration
+        latencyMu.Lock()
+        for _, sample := range latencySamples[server] {
+                if sample.Online && !sample.At.Before(cutoff) {
+                        latencies = append(latencies, sample.Latency)
+                }
+        }
+        latencyMu.Unlock()
+        if len(latencies) == 0 {
+                return 0, 0, 0, 0
+        }
+
+        sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+        percentile := func(p float64) time.Duration {
+                return latencies[int(p*float64(len(latencies)-1))]
+        }
+        return percentile(0.50), percentile(0.95), percentile(0.99), len(latencies)
+}
+
+// burnRate returns how many times faster than sustainable a server used up the SLO's error budget within the window
+func burnRate(server string, slo LatencySLO, window time.Duration) float64 {
+        cutoff := time.Now().Add(-window)
+        threshold := time.Duration(slo.Latency) * time.Millisecond
+        var total, bad int
+        latencyMu.Lock()
+        for _, sample := range latencySamples[server] {
+                if sample.At.Before(cutoff) {
+                        continue
+                }
+                total++
+                if !sample.Online || sample.Latency > threshold {
+                        bad++
+                }
+        }
+        latencyMu.Unlock()
+        if total == 0 {
+                return 0
+        }
+
+        budget := 1 - sloObjective(slo)/100
+        if budget <= 0 {
+                budget = 0.0001
+        }
+        return float64(bad) / float64(total) / budget
+}
+
+// sloObjective returns the SLO's objective in percent
+func sloObjective(slo LatencySLO) float64 {
+        if slo.Objective <= 0 {
+                return 99
+        }
+        return slo.Objective
+}
+
+// evaluateLatencySLOs alerts about servers burning their latency error budget too fast, and reports when they recover.
+// A server alerts when both the last hour and the whole SLO window burn faster than the threshold.
+func evaluateLatencySLOs(ctx context.Context, client *mautrix.Client) {
+        var burning, recovered []string
+        for _, slo := range config().LatencySLOs {
+                window := time.Duration(slo.Window) * time.Hour
+                if window <= 0 {
+                        window = 24 * time.Hour
+                }
+                threshold := slo.BurnRate
+                if threshold <= 0 {
+                        threshold = 6
+                }
+
+                latencyMu.Lock()
+                var servers []string
+                for server := range latencySamples {
+                        servers = append(servers, server)
+                }
+                latencyMu.Unlock()
+                sort.Strings(servers)
+
+                for _, server := range servers {
+                        if !hasTag(server, slo.Tag) {
+                                continue
+                        }
+                        key := slo.Tag + " " + server
+                        short, long := burnRate(server, slo, latencyShortWindow), burnRate(server, slo, window)
+                        alerting := short >= threshold && long >= threshold
+                        if alerting && !sloAlerting[key] {
+                                burning = append(burning, fmt.Sprintf("%s [%s] - budget burning %.1fx too fast (%.1fx over %s), SLO %.2f%% within %dms",
+                                        server, slo.Tag, short, long, window, sloObjective(slo), slo.Latency))
+                        } else if !alerting && sloAlerting[key] {
+                                recovered = append(recovered, fmt.Sprintf("%s [%s] - burn rate %.1fx", server, slo.Tag, short))
+                        }
+                        sloAlerting[key] = alerting
+                }
+        }
+
+        if len(burning) > 0 {
+                message := fmt.Sprintf("Latency SLO error budget burning:\n%s", reportLines(burning))
+                fmt.Println(message)
+                sendReport(ctx, client, severityCritical, message)
+        }
+        if len(recovered) > 0 {
+                message := fmt.Sprintf("Latency SLO burn back to normal:\n%s", reportLines(recovered))
+                fmt.Println(message)
+                sendReport(ctx, client, severityInfo, message)
+        }
+}
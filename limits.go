@@ -0,0 +1,120 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "sort"
+        "strings"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/id"
+)
+
+// LimitsConfig caps the resources used per cycle, for running on small devices next to the homeserver.
+// A limit of 0 means unlimited, unless low_memory is set, in which case the small-device default applies.
+type LimitsConfig struct {
+        LowMemory   bool `yaml:"low_memory"`   // Apply the small-device defaults to every limit left at 0
+        DNSLookups  int  `yaml:"dns_lookups"`  // Concurrent DNS lookups
+        Probes      int  `yaml:"probes"`       // Concurrent federation probes
+        Members     int  `yaml:"members"`      // Members considered per room and cycle
+        ReportLines int  `yaml:"report_lines"` // Server lines per log room message
+        LagServers  int  `yaml:"lag_servers"`  // Servers whose federation lag is tracked at once
+
+        ProbesPerMinute int `yaml:"probes_per_minute"` // Probes started per minute, shared fairly between rooms each cycle
+}
+
+// lowMemoryLimits are the limits used by low_memory, sized for a Raspberry Pi
+var lowMemoryLimits = LimitsConfig{
+        DNSLookups:  2,
+        Probes:      2,
+        Members:     2000,
+        ReportLines: 50,
+        LagServers:  200,
+}
+
+// limiter bounds how many operations run at once; callers beyond the limit wait for a free slot
+type limiter chan struct{}
+
+var (
+        dnsLimiter   limiter
+        probeLimiter limiter
+)
+
+// initLimits sets up the limiters from the configuration
+func initLimits() {
+        dnsLimiter = newLimiter(effectiveLimit(config().Limits.DNSLookups, lowMemoryLimits.DNSLookups))
+        probeLimiter = newLimiter(effectiveLimit(config().Limits.Probes, lowMemoryLimits.Probes))
+        if config().Limits.LowMemory {
+                fmt.Println("Low-memory mode enabled.")
+        }
+}
+
+// effectiveLimit returns the configured limit, or the small-device default if none is set in low-memory mode
+func effectiveLimit(configured, lowMemory int) int {
+        if configured > 0 {
+                return configured
+        }
+        if config().Limits.LowMemory {
+                return lowMemory
+        }
+        return 0
+}
+
+// newLimiter returns a limiter allowing n concurrent operations, or nil (no limit) if n is 0
+func newLimiter(n int) limiter {
+        if n <= 0 {
+                return nil
+        }
+        return make(limiter, n)
+}
+
+// acquire waits for a free slot and returns the function releasing it, or the context's error if it ends first
+func (l limiter) acquire(ctx context.Context) (func(), error) {
+        if l == nil {
+                return func() {}, ctx.Err()
+        }
+        select {
+        case l <- struct{}{}:
+                return func() { <-l }, nil
+        case <-ctx.Done():
+                return nil, ctx.Err()
+        }
+}
+
+// reportLines joins server lines for a log room message, cutting it off at the report_lines limit
+func reportLines(lines []string) string {
+        max := effectiveLimit(config().Limits.ReportLines, lowMemoryLimits.ReportLines)
+        if max <= 0 || len(lines) <= max {
+                return strings.Join(lines, "\n")
+        }
+        return fmt.Sprintf("%s\n... and %d more", strings.Join(lines[:max], "\n"), len(lines)-max)
+}
+
+// roomMemberServers returns the distinct servers of a room's members, sorted by name, considering at most the
+// member limit. Members are taken in sorted order, so a capped room checks the same servers every cycle.
+// It also returns the number of members left out.
+func roomMemberServers(members map[id.UserID]mautrix.JoinedMember) ([]string, int) {
+        userIDs := make([]string, 0, len(members))
+        for userID := range members {
+                userIDs = append(userIDs, string(userID))
+        }
+        sort.Strings(userIDs)
+
+        skipped := 0
+        if max := effectiveLimit(config().Limits.Members, lowMemoryLimits.Members); max > 0 && len(userIDs) > max {
+                skipped = len(userIDs) - max
+                userIDs = userIDs[:max]
+        }
+
+        seen := make(map[string]bool)
+        var servers []string
+        for _, userID := range userIDs {
+                server := extractDomain(userID)
+                if !seen[server] {
+                        seen[server] = true
+                        servers = append(servers, server)
+                }
+        }
+        sort.Strings(servers)
+        return servers, skipped
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+        "bufio"
+        "context"
+        "fmt"
+        "os"
+        "path/filepath"
+        "regexp"
+        "sort"
+        "strings"
+        "sync"
+
+        "github.com/fsnotify/fsnotify"
+        "gopkg.in/yaml.v3"
+        "maunium.net/go/mautrix"
+)
+
+// Server lists and maintenance schedules loaded from the files named in the configuration.
+// They are reloaded whenever the files change, so they can be edited without a restart.
+var (
+        listsMu             sync.RWMutex
+        ignoreFromFile      map[string]bool
+        staticFromFile      []string
+        maintenanceFromFile map[string][]MaintenanceWindow
+)
+
+// startListFiles loads the configured list files and watches them for changes in the background
+func startListFiles(ctx context.Context) {
+        reloaders := make(map[string]func())
+        if config().IgnoreFile != "" {
+                reloaders[config().IgnoreFile] = reloadIgnoreFile
+        }
+        if config().StaticServersFile != "" {
+                reloaders[config().StaticServersFile] = reloadStaticServersFile
+        }
+        if config().MaintenanceFile != "" {
+                reloaders[config().MaintenanceFile] = reloadMaintenanceFile
+        }
+        if len(reloaders) == 0 {
+                return
+        }
+
+        for _, reload := range reloaders {
+                reload()
+        }
+
+        watcher, err := fsnotify.NewWatcher()
+        if err != nil {
+                fmt.Println("Failed to watch list files, changes need a restart:", err)
+                return
+        }
+
+        // Watch the directories rather than the files, as editors often replace a file instead of writing to it
+        watched := make(map[string]func())
+        for path, reload := range reloaders {
+                abs, err := filepath.Abs(path)
+                if err != nil {
+                        fmt.Printf("Failed to watch %s: %v\n", path, err)
+                        continue
+                }
+                if err := watcher.Add(filepath.Dir(abs)); err != nil {
+                        fmt.Printf("Failed to watch %s: %v\n", path, err)
+                        continue
+                }
+                watched[abs] = reload
+        }
+
+        go func() {
+                defer watcher.Close()
+                for {
+                        select {
+                        case <-ctx.Done():
+                                return
+                        case event, ok := <-watcher.Events:
+                                if !ok {
+                                        return
+                                }
+                                reload, ok := watched[filepath.Clean(event.Name)]
+                                if ok && (event.Has(fsnotify.Write) || event.Has(fsnotify.Create)) {
+                                        reload()
+                                }
+                        case err, ok := <-watcher.Errors:
+                                if !ok {
+                                        return
+                                }
+                                fmt.Println("Error watching list files:", err)
+                        }
+                }
+        }()
+}
+
+// reloadIgnoreFile replaces the ignored servers from the ignore file; on failure the previous list is kept
+func reloadIgnoreFile() {
+        servers, err := readServerList(config().IgnoreFile)
+        if err != nil {
+                fmt.Println("Failed to load ignore_file:", err)
+                return
+        }
+
+        ignored := make(map[string]bool, len(servers))
+        for _, server := range servers {
+                ignored[server] = true
+        }
+
+        listsMu.Lock()
+        ignoreFromFile = ignored
+        listsMu.Unlock()
+        fmt.Printf("Loaded %d ignored servers from %s\n", len(ignored), config().IgnoreFile)
+}
+
+// reloadStaticServersFile replaces the static servers from the static servers file; on failure the previous list is kept
+func reloadStaticServersFile() {
+        servers, err := readServerList(config().StaticServersFile)
+        if err != nil {
+                fmt.Println("Failed to load static_servers_file:", err)
+                return
+        }
+
+        listsMu.Lock()
+        staticFromFile = servers
+        listsMu.Unlock()
+        fmt.Printf("Loaded %d static servers from %s\n", len(servers), config().StaticServersFile)
+}
+
+// reloadMaintenanceFile replaces the maintenance schedules from the maintenance file; on failure the previous schedules are kept
+func reloadMaintenanceFile() {
+        data, err := os.ReadFile(config().MaintenanceFile)
+        if err != nil {
+                fmt.Println("Failed to load maintenance_file:", err)
+                return
+        }
+
+        var schedules map[string][]MaintenanceWindow
+        if err := yaml.Unmarshal(data, &schedules); err != nil {
+                fmt.Println("Failed to load maintenance_file:", err)
+                return
+        }
+        windows := make(map[string][]MaintenanceWindow, len(schedules))
+        for server, schedule := range schedules {
+                windows[strings.ToLower(server)] = schedule
+        }
+
+        listsMu.Lock()
+        maintenanceFromFile = windows
+        listsMu.Unlock()
+        fmt.Printf("Loaded maintenance windows for %d servers from %s\n", len(windows), config().MaintenanceFile)
+}
+
+// readServerList reads a file with one server name per line; empty lines and # comments are skipped
+func readServerList(path string) ([]string, error) {
+        file, err := os.Open(path)
+        if err != nil {
+                return nil, err
+        }
+        defer file.Close()
+
+        var servers []string
+        scanner := bufio.NewScanner(file)
+        for scanner.Scan() {
+                line := scanner.Text()
+                if i := strings.Index(line, "#"); i >= 0 {
+                        line = line[:i]
+                }
+                if line = strings.TrimSpace(line); line != "" {
+                        servers = append(servers, strings.ToLower(line))
+                }
+        }
+        return servers, scanner.Err()
+}
+
+// isIgnored reports whether a server is on the ignore list in the config or the ignore file,
+// or left out by only_servers
+func isIgnored(server string) bool {
+        server = strings.ToLower(server)
+        for _, ignored := range config().Ignore {
+                if strings.EqualFold(ignored, server) {
+                        return true
+                }
+        }
+
+        if matchesServerList(config().IgnoreServers, server) {
+                return true
+        }
+        if len(config().OnlyServers) > 0 && !matchesServerList(config().OnlyServers, server) {
+                return true
+        }
+
+        listsMu.RLock()
+        defer listsMu.RUnlock()
+        return ignoreFromFile[server]
+}
+
+// serverPatterns caches the compiled /regex/ entries of ignore_servers and only_servers
+var (
+        serverPatternsMu sync.Mutex
+        serverPatterns   = make(map[string]*regexp.Regexp)
+)
+
+// matchesServerList reports whether a server matches a list entry: a server name, or a regular expression
+// between slashes, e.g. /\.example\.org$/. Patterns are matched case-insensitively.
+func matchesServerList(list []string, server string) bool {
+        for _, entry := range list {
+                if !isServerPattern(entry) {
+                        if strings.EqualFold(entry, server) {
+                                return true
+                        }
+                        continue
+                }
+                pattern, err := compileServerPattern(entry)
+                if err != nil {
+                        continue // Reported by validateServerLists
+                }
+                if pattern.MatchString(server) {
+                        return true
+                }
+        }
+        return false
+}
+
+// isServerPattern reports whether a list entry is a /regex/ pattern rather than a server name
+func isServerPattern(entry string) bool {
+        return len(entry) >= 2 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/")
+}
+
+// compileServerPattern compiles a /regex/ list entry, once
+func compileServerPattern(entry string) (*regexp.Regexp, error) {
+        serverPatternsMu.Lock()
+        defer serverPatternsMu.Unlock()
+        if pattern, ok := serverPatterns[entry]; ok {
+                return pattern, nil
+        }
+        pattern, err := regexp.Compile("(?i)" + entry[1:len(entry)-1])
+        if err != nil {
+                return nil, err
+        }
+        serverPatterns[entry] = pattern
+        return pattern, nil
+}
+
+// validateServerLists checks the /regex/ patterns of ignore_servers and only_servers
+func validateServerLists(c *Config) error {
+        for name, list := range map[string][]string{"ignore_servers": c.IgnoreServers, "only_servers": c.OnlyServers} {
+                for _, entry := range list {
+                        if !isServerPattern(entry) {
+                                continue
+                        }
+                        if _, err := compileServerPattern(entry); err != nil {
+                                return fmt.Errorf("invalid pattern %s in %s: %w", entry, name, err)
+                        }
+                }
+        }
+        return nil
+}
+
+// staticServers returns the servers checked regardless of room membership, from the config and the static servers file
+func staticServers() []string {
+        seen := make(map[string]bool)
+        var servers []string
+        add := func(server string) {
+                server = strings.ToLower(server)
+                if !seen[server] && !isIgnored(server) {
+                        seen[server] = true
+                        servers = append(servers, server)
+                }
+        }
+
+        for _, server := range config().StaticServers {
+                add(server)
+        }
+        listsMu.RLock()
+        fromFile := staticFromFile
+        listsMu.RUnlock()
+        for _, server := range fromFile {
+                add(server)
+        }
+
+        sort.Strings(servers)
+        return servers
+}
+
+// fileMaintenance returns the maintenance windows for a server from the maintenance file
+func fileMaintenance(server string) []MaintenanceWindow {
+        listsMu.RLock()
+        defer listsMu.RUnlock()
+        return maintenanceFromFile[strings.ToLower(server)]
+}
+
+// checkStaticServers checks the static servers in the probe context and reports their problems to the log rooms.
+// When the probes are stopped early, the servers checked so far are still reported.
+func checkStaticServers(ctx, probeCtx context.Context, client *mautrix.Client) {
+        servers := staticServers()
+        if len(servers) == 0 {
+                return
+        }
+
+        // Down servers between their rechecks keep their latest result
+        due := make(map[string]bool, len(servers))
+        for _, server := range recheckServers(servers) {
+                due[server] = true
+        }
+
+        var failedServers, warnedServers []string
+        for _, server := range servers {
+                var status string
+                if due[server] {
+                        var ok bool
+                        if status, ok = checkServerOnce(probeCtx, client, server, ""); !ok {
+                                continue
+                        }
+                } else if result, ok := latestResult(server); ok {
+                        status = result.Status
+                } else {
+                        continue
+                }
+                if probeCtx.Err() != nil {
+                        break
+                }
+                line := formatServerLine(server, status)
+                fmt.Println("Static server:", line)
+                if isSilenced(server) {
+                        continue
+                }
+
+                switch {
+                case strings.HasPrefix(status, "Failed"):
+                        failedServers = append(failedServers, line)
+                        raiseAlert(server, "", status)
+                case strings.HasPrefix(status, "Warning"):
+                        warnedServers = append(warnedServers, line)
+                        raiseAlert(server, "", status)
+                }
+        }
+
+        if len(failedServers) > 0 {
+                sendReport(ctx, client, severityCritical, fmt.Sprintf("Failed static servers:\n%s", reportLines(failedServers)))
+        }
+        if len(warnedServers) > 0 {
+                sendReport(ctx, client, severityWarning, fmt.Sprintf("Static servers with warnings:\n%s", reportLines(warnedServers)))
+        }
+}
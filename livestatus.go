@@ -0,0 +1,52 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "time"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/event"
+        "maunium.net/go/mautrix/id"
+)
+
+// liveStatusKey is the bot_state key of the status message about a monitored room in one of the log rooms
+func liveStatusKey(roomID, logRoom id.RoomID) string {
+        return fmt.Sprintf("status_message %s %s", roomID, logRoom)
+}
+
+// updateLiveStatus keeps one status message per monitored room in its log room, editing it (m.replace) each
+// cycle rather than posting a new one. A new message is posted the first time, when the log room changed, or
+// when the previous message can't be edited any more, e.g. because it was redacted.
+func updateLiveStatus(ctx context.Context, client *mautrix.Client, roomID id.RoomID, roomDescription string, rows []reportRow, settings roomSettings) {
+        target := roomReportTarget(roomID, severityInfo)
+        header := fmt.Sprintf("Server statuses in room %s", roomDescription)
+        footer := fmt.Sprintf("Room settings: %s\nUpdated %s", settings, time.Now().UTC().Format("2006-01-02 15:04 MST"))
+        header, rows, footer, _ = applyReportPipeline(ctx, roomID, severityInfo, header, rows, footer)
+
+        plain := reportPlain(header, rows, footer)
+        content := &event.MessageEventContent{MsgType: msgTypeFor(target), Body: plain}
+        if reportFormat() == reportFormatHTML {
+                content = htmlContent(target, plain, reportHTML(header, rows, footer))
+        }
+
+        key := liveStatusKey(roomID, target)
+        if previous := id.EventID(loadState(key)); previous != "" {
+                edit := *content
+                edit.SetEdit(previous)
+                _, err := sendMessageContent(ctx, client, target, &edit)
+                if err == nil {
+                        return
+                }
+                fmt.Printf("Failed to edit the status message of room %s, posting a new one: %v\n", roomID, err)
+        }
+
+        eventID, err := sendMessageContent(ctx, client, target, content)
+        if err != nil {
+                fmt.Printf("Failed to post the status message of room %s: %v\n", roomID, err)
+                return
+        }
+        if err := saveState(key, eventID.String()); err != nil {
+                fmt.Printf("Failed to save the status message of room %s: %v\n", roomID, err)
+        }
+}
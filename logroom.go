@@ -0,0 +1,264 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "strings"
+        "sync"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/event"
+        "maunium.net/go/mautrix/id"
+)
+
+// prepareLogRooms joins the log rooms of a configuration about to be put in effect and checks the bot can post in
+// them. Log rooms given as aliases are resolved, and the configuration is updated with their room IDs.
+func prepareLogRooms(ctx context.Context, client *mautrix.Client, c *Config) error {
+        if err := ensureLogRoom(ctx, client, c); err != nil {
+                return err
+        }
+
+        joinedRooms, err := client.JoinedRooms(ctx)
+        if err != nil {
+                return fmt.Errorf("failed to fetch joined rooms: %w", err)
+        }
+        joined := make(map[id.RoomID]bool)
+        for _, roomID := range joinedRooms.JoinedRooms {
+                joined[roomID] = true
+        }
+
+        for _, setting := range []*string{&c.LogRoom, &c.LogRoomCritical, &c.LogRoomWarning, &c.LogRoomInfo, &c.LogRoomCert} {
+                if *setting == "" {
+                        continue
+                }
+                roomID, err := joinLogRoom(ctx, client, *setting, joined)
+                if err != nil {
+                        return err
+                }
+                *setting = roomID.String()
+        }
+        if err := resolveMonitoredRooms(ctx, client, c); err != nil {
+                return err
+        }
+        for room, logRoom := range c.RoomLogRooms {
+                if logRoom == "" {
+                        continue
+                }
+                roomID, err := joinLogRoom(ctx, client, logRoom, joined)
+                if err != nil {
+                        return err
+                }
+                c.RoomLogRooms[room] = roomID.String()
+        }
+        for name, tenant := range c.Tenants {
+                if tenant.LogRoom == "" {
+                        continue
+                }
+                roomID, err := joinLogRoom(ctx, client, tenant.LogRoom, joined)
+                if err != nil {
+                        return err
+                }
+                tenant.LogRoom = roomID.String()
+                c.Tenants[name] = tenant
+        }
+
+        for _, roomID := range logRoomsOf(c) {
+                if err := checkCanSend(ctx, client, roomID); err != nil {
+                        return err
+                }
+        }
+        return nil
+}
+
+// resolveMonitoredRooms replaces the monitored rooms given by alias in room_logrooms and the tenants' rooms
+// with their room IDs, which is how rooms are looked up when reporting. The maps are replaced rather than changed,
+// as c may share them with the configuration in effect.
+func resolveMonitoredRooms(ctx context.Context, client *mautrix.Client, c *Config) error {
+        resolve := func(room string) (string, error) {
+                if !strings.HasPrefix(room, "#") {
+                        return room, nil
+                }
+                resp, err := client.ResolveAlias(ctx, id.RoomAlias(room))
+                if err != nil {
+                        return "", fmt.Errorf("failed to resolve monitored room %s: %w", room, err)
+                }
+                return resp.RoomID.String(), nil
+        }
+
+        resolved := make(map[string]string, len(c.RoomLogRooms))
+        for room, logRoom := range c.RoomLogRooms {
+                roomID, err := resolve(room)
+                if err != nil {
+                        return err
+                }
+                resolved[roomID] = logRoom
+        }
+        c.RoomLogRooms = resolved
+
+        tenants := make(map[string]TenantConfig, len(c.Tenants))
+        for name, tenant := range c.Tenants {
+                rooms := make([]string, len(tenant.Rooms))
+                for i, room := range tenant.Rooms {
+                        roomID, err := resolve(room)
+                        if err != nil {
+                                return err
+                        }
+                        rooms[i] = roomID
+                }
+                tenant.Rooms = rooms
+                tenants[name] = tenant
+        }
+        c.Tenants = tenants
+        return nil
+}
+
+// ensureLogRoom falls back to the log room created on an earlier run when none is configured,
+// or creates one if create_logroom is set
+func ensureLogRoom(ctx context.Context, client *mautrix.Client, c *Config) error {
+        if c.LogRoom != "" {
+                return nil
+        }
+        if stored := loadState("logroom"); stored != "" {
+                fmt.Printf("Using log room %s created on an earlier run\n", stored)
+                c.LogRoom = stored
+                return nil
+        }
+        if !c.CreateLogRoom {
+                return nil
+        }
+
+        var invite []id.UserID
+        for _, admin := range c.Admins {
+                invite = append(invite, id.UserID(admin))
+        }
+
+        stateKey := ""
+        fmt.Println("Creating log room...")
+        resp, err := client.CreateRoom(ctx, &mautrix.ReqCreateRoom{
+                Name:   "Matrix Health",
+                Topic:  fmt.Sprintf("Federation health reports from %s", client.UserID),
+                Preset: "private_chat",
+                Invite: invite,
+                InitialState: []*event.Event{{
+                        Type:     event.StateHistoryVisibility,
+                        StateKey: &stateKey,
+                        Content: event.Content{Parsed: &event.HistoryVisibilityEventContent{
+                                HistoryVisibility: event.HistoryVisibilityShared,
+                        }},
+                }},
+        })
+        if err != nil {
+                return fmt.Errorf("failed to create log room: %w", err)
+        }
+        fmt.Printf("Created log room %s\n", resp.RoomID)
+
+        c.LogRoom = resp.RoomID.String()
+        if err := saveState("logroom", c.LogRoom); err != nil {
+                fmt.Println("Failed to save the log room for future runs:", err)
+        }
+        return nil
+}
+
+// joinLogRoom joins a log room given by ID or alias unless the bot is already in it, accepting a pending invite
+func joinLogRoom(ctx context.Context, client *mautrix.Client, room string, joined map[id.RoomID]bool) (id.RoomID, error) {
+        roomID := id.RoomID(room)
+        if strings.HasPrefix(room, "#") {
+                resp, err := client.ResolveAlias(ctx, id.RoomAlias(room))
+                if err != nil {
+                        return "", fmt.Errorf("failed to resolve log room alias %s: %w", room, err)
+                }
+                roomID = resp.RoomID
+        }
+        if joined[roomID] {
+                return roomID, nil
+        }
+
+        fmt.Printf("Joining log room %s...\n", room)
+        resp, err := client.JoinRoom(ctx, room, nil)
+        if err != nil {
+                return "", fmt.Errorf("failed to join log room %s (invite the bot or make the room joinable): %w", room, err)
+        }
+        joined[resp.RoomID] = true
+        return resp.RoomID, nil
+}
+
+// checkCanSend checks that the bot's power level in a room allows it to send messages, and that it can
+// encrypt them if the room is encrypted
+func checkCanSend(ctx context.Context, client *mautrix.Client, roomID id.RoomID) error {
+        if err := prepareEncryptedRoom(ctx, client, roomID); err != nil {
+                return err
+        }
+        var powerLevels event.PowerLevelsEventContent
+        if err := roomState(ctx, client, roomID, event.StatePowerLevels, "", &powerLevels); err != nil {
+                return fmt.Errorf("failed to read power levels of log room %s: %w", roomID, err)
+        }
+        level, required := powerLevels.GetUserLevel(client.UserID), powerLevels.GetEventLevel(event.EventMessage)
+        if level < required {
+                return fmt.Errorf("cannot send to log room %s: the bot has power level %d, messages need %d", roomID, level, required)
+        }
+        return nil
+}
+
+var (
+        logRoomProblemsMu sync.Mutex
+        logRoomProblems   = make(map[id.RoomID]bool) // Log rooms currently reported as unusable
+)
+
+// verifyLogRooms checks every log room is still usable, reporting rooms that broke or recovered since the last check
+func verifyLogRooms(ctx context.Context, client *mautrix.Client) {
+        if !config().LogRoomChecks {
+                return
+        }
+        for _, roomID := range logRooms() {
+                updateLogRoomProblem(ctx, client, roomID, checkCanSend(ctx, client, roomID))
+        }
+}
+
+// updateLogRoomProblem reports a log room becoming unusable, or usable again, through the other log rooms
+func updateLogRoomProblem(ctx context.Context, client *mautrix.Client, roomID id.RoomID, problem error) {
+        logRoomProblemsMu.Lock()
+        wasBroken := logRoomProblems[roomID]
+        logRoomProblems[roomID] = problem != nil
+        logRoomProblemsMu.Unlock()
+
+        switch {
+        case problem != nil && !wasBroken:
+                reportElsewhere(ctx, client, roomID, severityCritical, fmt.Sprintf("Reports can no longer be sent: %v", problem))
+        case problem == nil && wasBroken:
+                reportElsewhere(ctx, client, roomID, severityInfo, fmt.Sprintf("Reports can be sent to log room %s again", roomID))
+        }
+}
+
+// reportElsewhere reports a problem with a log room to the console and every other log room
+func reportElsewhere(ctx context.Context, client *mautrix.Client, roomID id.RoomID, sev severity, message string) {
+        fmt.Printf("Log room %s (%s): %s\n", roomID, sev, message)
+        for _, room := range logRooms() {
+                if room != roomID {
+                        sendMessageToRoom(ctx, client, room, fmt.Sprintf("Log room %s: %s", roomID, message))
+                }
+        }
+}
+
+// handleLogRoomStateChange reacts to changes in a log room that could stop the bot from reporting:
+// power levels, join rules and the bot's own membership
+func handleLogRoomStateChange(ctx context.Context, client *mautrix.Client, evt *event.Event) {
+        if !config().LogRoomChecks || !isLogRoom(evt.RoomID) {
+                return
+        }
+
+        switch evt.Type {
+        case event.StatePowerLevels:
+                go func() { updateLogRoomProblem(ctx, client, evt.RoomID, checkCanSend(ctx, client, evt.RoomID)) }()
+        case event.StateJoinRules:
+                if rules := evt.Content.AsJoinRules(); rules != nil {
+                        go reportElsewhere(ctx, client, evt.RoomID, severityWarning,
+                                fmt.Sprintf("%s changed the join rule to %s, admins may no longer be able to join", evt.Sender, rules.JoinRule))
+                }
+        case event.StateMember:
+                member := evt.Content.AsMember()
+                if id.UserID(evt.GetStateKey()) == client.UserID && member != nil &&
+                        (member.Membership == event.MembershipLeave || member.Membership == event.MembershipBan) {
+                        go updateLogRoomProblem(ctx, client, evt.RoomID, fmt.Errorf("%s removed the bot (%s)", evt.Sender, member.Membership))
+                }
+        }
+}
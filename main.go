@@ -3,14 +3,21 @@ package main
 import (
         "context"
         "encoding/json"
+        "errors"
+        "flag"
         "fmt"
         "io/ioutil"
         "net"
         "net/http"
+        "os"
+        "os/signal"
+        "runtime/debug"
         "strings"
+        "sync"
+        "sync/atomic"
+        "syscall"
         "time"
 
-        "gopkg.in/yaml.v3"
         "maunium.net/go/mautrix"
         "maunium.net/go/mautrix/event"
         "maunium.net/go/mautrix/id"
@@ -18,220 +25,869 @@ import (
 
 // Config represents the structure of the YAML configuration file
 type Config struct {
-        ServerName string `yaml:"servername"`
-        Username   string `yaml:"username"`
-        Password   string `yaml:"password"`
-        LogRoom    string `yaml:"logroom"`
-        Interval   int    `yaml:"interval"` // Interval in seconds
+        ServerName      string            `yaml:"servername"`
+        Username        string            `yaml:"username"`
+        Password        string            `yaml:"password"`
+        AccessToken     string            `yaml:"access_token"` // Existing session used instead of a password login
+        DeviceID        string            `yaml:"device_id"`    // Device of the access token
+        LogRoom         string            `yaml:"logroom"`
+        LogRoomCritical string            `yaml:"logroom_critical"`    // Failures; defaults to logroom
+        LogRoomWarning  string            `yaml:"logroom_warning"`     // Warnings; defaults to logroom
+        LogRoomInfo     string            `yaml:"logroom_info"`        // Routine summaries; defaults to logroom
+        LogRoomCert     string            `yaml:"logroom_certificate"` // Certificate warnings; defaults to logroom_warning
+        RoomLogRooms    map[string]string `yaml:"room_logrooms"`       // Dedicated log room per monitored room ID or alias
+        MsgType         string            `yaml:"msgtype"`             // msgtype of the bot's messages, m.text (default) or m.notice
+        RoomMsgTypes    map[string]string `yaml:"room_msgtypes"`       // msgtype per destination room ID, overriding msgtype
+        CreateLogRoom   bool              `yaml:"create_logroom"`      // Create a log room if none is configured
+        LogRoomChecks   bool              `yaml:"logroom_checks"`      // Report changes that stop the bot from posting to a log room
+        Admins          []string          `yaml:"admins"`              // Operators kept in the log rooms with command power level
+        Interval        int               `yaml:"interval"`            // Interval in seconds
+        CycleTimeout    int               `yaml:"cycle_timeout"`       // Seconds after which a check cycle's remaining probes are abandoned (0 = no limit)
+
+        Concurrency        int      `yaml:"concurrency"`          // Servers checked at once, defaults to 10
+        PerHostConcurrency int      `yaml:"per_host_concurrency"` // Probes at once per federation host, defaults to 2
+        RoomConcurrency    int      `yaml:"room_concurrency"`     // Rooms checked at once, defaults to 4
+        ReportOrder        []string `yaml:"report_order"`         // Sort keys for servers in reports: status, impact and/or name
+        ReportMode         string   `yaml:"report_mode"`          // full (every cycle), transitions (only status changes), diff (changes per room), live (edited status message) or threads (a thread per failure)
+        ReportFormat       string   `yaml:"report_format"`        // html (tables, with plain text fallback) or plain
+        SummaryInterval    int      `yaml:"summary_interval"`     // Seconds between summaries of all servers (0 = disabled)
+        ThreadReminder     int      `yaml:"thread_reminder"`      // Seconds between "still down" replies in threads mode (0 = disabled)
+        AliasCheckInterval int      `yaml:"alias_check_interval"` // Seconds between checks that monitored rooms' aliases resolve to them (0 = disabled)
+
+        Redirects          RedirectPolicy `yaml:"redirects"`            // Redirect handling for federation probes
+        DialFallbackDelay  int            `yaml:"dial_fallback_delay"`  // Happy Eyeballs fallback delay in milliseconds
+        ProbeSourceAddress string         `yaml:"probe_source_address"` // Local IP address probes are sent from
+        ProbeInterface     string         `yaml:"probe_interface"`      // Network interface probes are bound to
+        TorProxy           string         `yaml:"tor_proxy"`            // SOCKS5 address of a Tor proxy for .onion servers
+        TorTimeout         int            `yaml:"tor_timeout"`          // Timeout in seconds for probes over Tor
+        ProbeCAFile        string         `yaml:"probe_ca_file"`        // PEM file with extra CA certificates probes trust
+        ProbePath          string         `yaml:"probe_path"`           // Path probed on each server, defaults to the federation version endpoint
+        Verbose            bool           `yaml:"verbose"`              // Log details of each probe, such as how the server was resolved
+        ProbeRetries       int            `yaml:"probe_retries"`        // Probes retried within a check before a server counts as failed (0 = none)
+        ProbeRetryDelay    int            `yaml:"probe_retry_delay"`    // Seconds before the first retry, doubling for each further one; defaults to 1
+        FailureThreshold   int            `yaml:"failure_threshold"`    // Failed checks in a row before a server is reported as down, defaults to 1
+        DegradedLatency    int            `yaml:"degraded_latency"`     // Latency in milliseconds above which servers that answer are reported as degraded warnings (0 = never)
+        SLATarget          float64        `yaml:"sla_target"`           // Uptime percentage servers are expected to reach, flagged in !health uptime (0 = none)
+
+        Servers     map[string]ServerOverride `yaml:"servers"`      // Per-server settings, keyed by server name
+        Inventory   InventoryConfig           `yaml:"inventory"`    // External server metadata merged into the per-server settings
+        EOL         EOLConfig                 `yaml:"eol"`          // Flag servers running end-of-life software releases
+        TagRooms    map[string]string         `yaml:"tag_rooms"`    // Additional room per tag receiving failures and warnings of servers with that tag
+        RoomFilters map[string]NotifyFilter   `yaml:"room_filters"` // What each notify or tag room receives, keyed by room ID
+        TagImpacts  map[string]string         `yaml:"tag_impacts"`  // What an outage means to users, per tag, for servers without their own impact
+        Tenants     map[string]TenantConfig   `yaml:"tenants"`      // Communities with their own rooms, log room, API view and alert labels
+
+        Ignore            []string `yaml:"ignore"`              // Servers that are never checked
+        IgnoreFile        string   `yaml:"ignore_file"`         // File with more servers to ignore, reloaded when it changes
+        IgnoreServers     []string `yaml:"ignore_servers"`      // Servers never checked, as names or /regex/ patterns
+        OnlyServers       []string `yaml:"only_servers"`        // If set, only servers matching these names or /regex/ patterns are checked
+        StaticServers     []string `yaml:"static_servers"`      // Servers checked in addition to the room members
+        StaticServersFile string   `yaml:"static_servers_file"` // File with more static servers, reloaded when it changes
+        MaintenanceFile   string   `yaml:"maintenance_file"`    // YAML file with maintenance windows per server, reloaded when it changes
+
+        StatsInterval int           `yaml:"stats_interval"` // Seconds between aggregate federation statistics reports (0 = disabled)
+        Heatmap       HeatmapConfig `yaml:"heatmap"`        // Weekly availability digest with heatmap images
+        Digest        DigestConfig  `yaml:"digest"`         // Scheduled digest of downtime, latency and server changes
+        Trends        TrendConfig   `yaml:"trends"`         // Room membership trend reporting
+        Lag           LagConfig     `yaml:"lag"`            // Federation delivery lag reporting
+        LatencySLOs   []LatencySLO  `yaml:"latency_slos"`   // Latency objectives per server tag
+
+        RoomUpgradeTarget string `yaml:"room_upgrade_target"` // Room version servers are checked against for upgrades, defaults to 10
+
+        Retention         RetentionConfig `yaml:"log_retention"`       // Cleanup of old bot messages in the log room
+        CommandPowerLevel int             `yaml:"command_power_level"` // Power level needed for admin commands in the log room
+
+        Database DatabaseConfig `yaml:"database"` // Persistent storage
+        Limits   LimitsConfig   `yaml:"limits"`   // Resource caps for small devices
+
+        Encryption EncryptionConfig `yaml:"encryption"`   // End-to-end encryption for encrypted log rooms
+        Devices    DevicesConfig    `yaml:"devices"`      // Device count warnings and pruning for the monitoring account
+        Certs      CertsConfig      `yaml:"certificates"` // TLS certificate expiry warnings
+        Sampling   SamplingConfig   `yaml:"sampling"`     // Fewer probes of very stable servers
+        Recheck    RecheckConfig    `yaml:"recheck"`      // Fewer probes of servers that are down
+        Artifacts  ArtifactsConfig  `yaml:"artifacts"`    // Per-room JSON result files
+        KeyCheck   KeyCheckConfig   `yaml:"key_check"`    // Signing key checks through /_matrix/key/v2/server
+        API        APIConfig        `yaml:"api"`          // HTTP API for other tools
+
+        MetricsListen string `yaml:"metrics_listen"` // Address serving Prometheus metrics on /metrics (e.g. 127.0.0.1:9100), empty disables them
+
+        Alertmanager AlertmanagerConfig `yaml:"alertmanager"` // Prometheus Alertmanager receiving alerts
+
+        ProviderGrouping ProviderGroupingConfig `yaml:"provider_grouping"` // One alert for many failures at the same hosting provider
+
+        ReportPipeline []PipelineStep `yaml:"report_pipeline"` // Transforms applied in order to room reports before they are rendered
+
+        AllowDuplicateInstances bool           `yaml:"allow_duplicate_instances"` // Only warn when another instance uses the same account or database
+        FallbackWebhook         string         `yaml:"fallback_webhook"`          // URL receiving {"text": ...} when the bot can't log in to Matrix or alerts aren't delivered
+        CanaryInterval          int            `yaml:"canary_interval"`           // Seconds between synthetic alerts proving the alerting path works (0 = disabled)
+        Webhooks                WebhooksConfig `yaml:"webhooks"`                  // Outage webhook and payload signing
+        LogoutOnExit            bool           `yaml:"logout_on_exit"`            // Log out when stopped by a signal, instead of keeping the session for the next start
+
+        RateLimits RateLimitConfig `yaml:"rate_limits"` // Coping with the account being rate limited by its homeserver
+
+        Chaos ChaosConfig `yaml:"chaos"` // Fault injection for testing
+
+        Include    []string `yaml:"include"`     // Additional configuration files merged into this one
+        IncludeDir string   `yaml:"include_dir"` // Directory of configuration files merged into this one (conf.d style)
 }
 
-var config Config
+// currentConfig is the configuration in effect. It is replaced as a whole once a new one is loaded and validated,
+// so the goroutines reading it never see one half loaded.
+var currentConfig atomic.Pointer[Config]
+
+// config returns the configuration in effect
+func config() *Config {
+        if c := currentConfig.Load(); c != nil {
+                return c
+        }
+        return &Config{}
+}
+
+// publishConfig puts a fully loaded configuration in effect
+func publishConfig(c *Config) {
+        currentConfig.Store(c)
+}
 
 func main() {
+        flags := flag.NewFlagSet("matrix-health", flag.ContinueOnError)
+        flags.StringVar(&configPath, "config", "", "Configuration file (default: the first of "+strings.Join(configFileNames, ", ")+")")
+        flags.Usage = func() {
+                fmt.Fprintln(flags.Output(), usage)
+                flags.PrintDefaults()
+        }
+        if err := flags.Parse(os.Args[1:]); err == flag.ErrHelp {
+                return
+        } else if err != nil {
+                os.Exit(2)
+        }
+        args := flags.Args()
+        command := "serve"
+        if len(args) > 0 {
+                command, args = args[0], args[1:]
+        }
+
+        switch command {
+        case "serve":
+                serve(nil)
+        case "check":
+                os.Exit(runCheck(args))
+        case "bench":
+                os.Exit(runBench(args))
+        case "query":
+                os.Exit(runQuery(args))
+        case "state":
+                os.Exit(runState(args))
+        case "validate-config":
+                os.Exit(runValidateConfig())
+        case "version":
+                fmt.Println("matrix-health", buildVersion)
+        case "mockfed":
+                // The mock federation server doesn't need the configuration or a Matrix account
+                if err := runMockFederation(args); err != nil {
+                        fmt.Println("Mock federation server failed:", err)
+                        os.Exit(1)
+                }
+        case "servers", "config", "notify-test":
+                // These commands run once with the bot's account
+                serve(append([]string{command}, args...))
+        default:
+                fmt.Printf("unknown command: %s\n", command)
+                flags.Usage()
+                os.Exit(2)
+        }
+}
+
+// serve runs the monitor, or a one-off command that needs the bot's account if one is given
+func serve(commandArgs []string) {
         fmt.Println("Starting Matrix client...")
 
         // Load the configuration
-        err := loadConfig("config.yaml")
-        if err != nil {
-                fmt.Println("Failed to load configuration:", err)
-                return
+        if err := loadValidConfig(); err != nil {
+                fmt.Println("Configuration error:", err)
+                os.Exit(1)
         }
-
-        fmt.Println("Configuration loaded successfully.")
         fmt.Printf("ServerName: %s, Username: %s, LogRoom: %s, Interval: %d seconds\n",
-                config.ServerName, config.Username, config.LogRoom, config.Interval)
+                config().ServerName, config().Username, config().LogRoom, config().Interval)
 
-        // Validate username format
-        fmt.Println("Validating username format...")
-        if _, _, err := id.UserID(config.Username).ParseAndValidate(); err != nil {
-                fmt.Println("Invalid username in configuration:", err)
-                return
+        // Set up the resource limits
+        initLimits()
+        initProbeBudget()
+
+        // Open the database
+        if err := openStore(); err != nil {
+                fmt.Println("Failed to open database:", err)
+                os.Exit(1)
+        }
+        if err := initWebhookSigning(); err != nil {
+                fmt.Println("Failed to load the webhook signing key:", err)
+                os.Exit(1)
+        }
+        if err := loadTags(); err != nil {
+                fmt.Println("Failed to load server tags:", err)
+                os.Exit(1)
+        }
+        if err := loadNotes(); err != nil {
+                fmt.Println("Failed to load server notes:", err)
+                os.Exit(1)
+        }
+        if err := loadSilences(); err != nil {
+                fmt.Println("Failed to load silences:", err)
+                os.Exit(1)
+        }
+        if err := restoreResults(); err != nil {
+                fmt.Println("Failed to load the check history:", err)
+                os.Exit(1)
+        }
+        if err := loadReportedStates(); err != nil {
+                fmt.Println("Failed to load server states:", err)
+                os.Exit(1)
         }
-        fmt.Println("Username is valid.")
 
         // Create a new Matrix client
         fmt.Println("Creating Matrix client...")
-        client, err := mautrix.NewClient(config.ServerName, "", "")
+        client, err := mautrix.NewClient(config().ServerName, "", "")
         if err != nil {
                 fmt.Println("Failed to create Matrix client:", err)
-                return
+                os.Exit(1)
+        }
+        if config().Chaos.Enabled {
+                fmt.Println("Chaos testing enabled, injecting faults into homeserver requests and probes")
+                client.Client.Transport = newChaosTransport(client.Client.Transport, true)
         }
         fmt.Println("Matrix client created.")
 
         // Log in to the Matrix account
         fmt.Println("Logging in...")
-        ctx := context.Background()
-        loginResp, err := client.Login(ctx, &mautrix.ReqLogin{
-                Type: mautrix.AuthTypePassword,
-                Identifier: mautrix.UserIdentifier{
-                        Type: mautrix.IdentifierTypeUser,
-                        User: config.Username,
-                },
-                Password: config.Password,
-        })
-        if err != nil {
+        // Stop cleanly on SIGINT and SIGTERM: probes are aborted, and the check loop ends
+        ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+        defer stop()
+        if err := loginWithRetry(ctx, client); err != nil {
                 fmt.Println("Failed to log in:", err)
+                os.Exit(1)
+        }
+        fmt.Printf("Logged in successfully as %s\n", config().Username)
+
+        // Make sure the bot is in the log rooms and allowed to post there
+        prepared := *config()
+        if err := prepareLogRooms(ctx, client, &prepared); err != nil {
+                fmt.Println("Log room check failed:", err)
+                os.Exit(1)
+        }
+        publishConfig(&prepared)
+        ensureAdmins(ctx, client)
+
+        // Rebuild the history from earlier reports the first time the store is used with existing log rooms
+        backfillHistory(ctx, client)
+
+        // Keep external server metadata up to date
+        startInventorySync(ctx)
+        startEOLSync(ctx)
+
+        // Load the server lists and maintenance schedules kept in their own files
+        startListFiles(ctx)
+
+        // Run a one-off subcommand instead of the monitor if one was given.
+        // The session is kept for the next run, so runs don't leave devices behind.
+        if len(commandArgs) > 0 {
+                err := runCommand(ctx, client, commandArgs)
+                if err != nil {
+                        fmt.Println(err)
+                        os.Exit(1)
+                }
                 return
         }
 
-        // Set the access token explicitly
-        client.AccessToken = loginResp.AccessToken
-        fmt.Printf("Logged in successfully as %s\n", config.Username)
+        // Refuse to run next to another instance, which would duplicate every alert
+        if err := checkDuplicateInstance(ctx, client); err != nil {
+                if !config().AllowDuplicateInstances {
+                        fmt.Println("Refusing to start:", err)
+                        os.Exit(1)
+                }
+                fmt.Println("WARNING:", err)
+                sendReport(ctx, client, severityCritical, fmt.Sprintf("Warning: %v, alerts will be duplicated", err))
+        }
+        startHeartbeat(ctx, client)
+        startAlertCanary(ctx, client)
+
+        // Follow live room traffic in the background
+        startSync(ctx, client)
+
+        // Reload the configuration on SIGHUP
+        watchReloadSignal(ctx)
 
-        // Run the server check loop
+        // Answer check requests from other tools, and serve metrics for Prometheus
+        startAPI(ctx, client)
+        startMetrics(ctx)
+
+        // Run the server check loop until a signal arrives
         runServerCheckLoop(ctx, client)
+        shutdown(client)
 }
 
-// resolveMatrixServer resolves the actual Matrix server URL using .well-known, DNS SRV, or fallback to server-name.com:8448
-func resolveMatrixServer(server string) (string, error) {
-        // 1. Try .well-known delegation
-        url := fmt.Sprintf("https://%s/.well-known/matrix/server", server)
-        resp, err := http.Get(url)
-        if err == nil {
-                defer resp.Body.Close()
-
-                if resp.StatusCode == http.StatusOK {
-                        var result struct {
-                                Server string `json:"m.server"`
-                        }
-                        err = json.NewDecoder(resp.Body).Decode(&result)
-                        if err == nil && result.Server != "" {
-                                return result.Server, nil
-                        }
+// shutdown runs after a signal ended the check loop: it optionally logs out, and closes the database
+func shutdown(client *mautrix.Client) {
+        fmt.Println("Shutting down...")
+        if config().LogoutOnExit {
+                ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+                defer cancel()
+                if _, err := client.Logout(ctx); err != nil {
+                        fmt.Println("Failed to log out:", err)
+                } else {
+                        saveState("session_token", "")
+                        fmt.Println("Logged out.")
                 }
         }
+        if err := db.Close(); err != nil {
+                fmt.Println("Failed to close the database:", err)
+        }
+}
 
-        // 2. Try DNS SRV record for _matrix._tcp.server-name.com
-        _, srvRecords, err := net.LookupSRV("matrix", "tcp", server)
-        if err == nil && len(srvRecords) > 0 {
-                srv := srvRecords[0] // Use the first SRV record
-                return fmt.Sprintf("%s:%d", strings.Trim(srv.Target, "."), srv.Port), nil
+// resolveMatrixServer resolves the actual Matrix server URLs using .well-known, DNS SRV, or fallback to server-name.com:8448.
+// Servers with several SRV records get every target, in the order they should be tried.
+func resolveMatrixServer(ctx context.Context, server string) ([]string, error) {
+        // 1. Try .well-known delegation, cached as long as the server allows
+        if delegated := lookupWellKnown(ctx, server); delegated != "" {
+                if config().Verbose {
+                        fmt.Printf("Resolved %s through .well-known to %s\n", server, delegated)
+                }
+                return []string{delegated}, nil
+        }
+
+        // Onion services have no DNS, so SRV lookups are pointless
+        if isOnion(server) {
+                return []string{fmt.Sprintf("%s:8448", server)}, nil
+        }
+
+        // 2. Try DNS SRV records, _matrix-fed._tcp.server-name.com first and then the deprecated _matrix._tcp.server-name.com
+        for _, service := range []string{"matrix-fed", "matrix"} {
+                release, err := dnsLimiter.acquire(ctx)
+                if err != nil {
+                        return nil, err
+                }
+                _, srvRecords, err := net.DefaultResolver.LookupSRV(ctx, service, "tcp", server)
+                release()
+                if err != nil || len(srvRecords) == 0 {
+                        continue
+                }
+                // LookupSRV sorts the records by priority and shuffles them by weight within a priority, as in RFC 2782
+                var candidates []string
+                for _, srv := range srvRecords {
+                        if srv.Target == "." {
+                                continue // The service is decidedly not available at this domain
+                        }
+                        candidates = append(candidates, fmt.Sprintf("%s:%d", strings.Trim(srv.Target, "."), srv.Port))
+                }
+                if len(candidates) > 0 {
+                        if config().Verbose {
+                                fmt.Printf("Resolved %s through _%s._tcp SRV records to %s\n", server, service, strings.Join(candidates, ", "))
+                        }
+                        return candidates, nil
+                }
         }
 
         // 3. Fallback to server-name.com:8448
-        return fmt.Sprintf("%s:8448", server), nil
+        if config().Verbose {
+                fmt.Printf("Resolved %s to the default port 8448\n", server)
+        }
+        return []string{fmt.Sprintf("%s:8448", server)}, nil
 }
 
-
-// runServerCheckLoop performs checks for offline servers at the specified interval
+// runServerCheckLoop performs checks for offline servers at the specified interval, until the context ends
 func runServerCheckLoop(ctx context.Context, client *mautrix.Client) {
-        for {
+        lastStatsReport := time.Now()
+        ticker := time.NewTicker(time.Duration(config().Interval) * time.Second)
+        defer ticker.Stop()
+        defer endCycle()
+
+        for ; ; waitForTick(ctx, ticker) {
+                if ctx.Err() != nil {
+                        return
+                }
+                applyPendingReload(ctx, client, ticker)
                 fmt.Println("Checking server statuses...")
 
                 // Get all joined rooms
-                joinedRooms, err := client.JoinedRooms(ctx)
+                var joinedRooms *mautrix.RespJoinedRooms
+                err := accountCall(ctx, false, func() (err error) {
+                        joinedRooms, err = client.JoinedRooms(ctx)
+                        return err
+                })
                 if err != nil {
                         fmt.Println("Failed to fetch joined rooms:", err)
-                        time.Sleep(time.Duration(config.Interval) * time.Second)
                         continue
                 }
 
-                // Process each room
+                // Servers already sent to their own notification channel this cycle
+                notified := newNotifySet()
+
+                // Share this cycle's probes between the monitored rooms
+                monitoredRooms := 0
                 for _, roomID := range joinedRooms.JoinedRooms {
-                        // Skip the log room
-                        if id.RoomID(roomID) == id.RoomID(config.LogRoom) { // Convert config.LogRoom to id.RoomID
-                                fmt.Printf("Skipping log room: %s\n", config.LogRoom)
-                                continue
+                        if !isLogRoom(roomID) {
+                                monitoredRooms++
                         }
+                }
+                budget := newProbeBudget(monitoredRooms)
+                nextSamplingCycle()
 
-                        // Fetch room details (alias and title)
-                        roomAlias, roomTitle := getRoomDetails(ctx, client, id.RoomID(roomID))
-
-                        // Format the room description
-                        roomDescription := fmt.Sprintf("%s - %s ( %s )", roomAlias, roomTitle, roomID)
-                        fmt.Println("Testing servers in room:", roomDescription)
+                // Probes run in the cycle's context, which the cycle timeout and !health abort end early
+                cycleCtx := startCycle(ctx)
 
-                        // Fetch members of the room
-                        resp, err := client.JoinedMembers(ctx, id.RoomID(roomID))
-                        if err != nil {
-                                fmt.Printf("Failed to get joined members for room %s: %v\n", roomID, err)
+                // Process the rooms, room_concurrency at a time
+                var rooms sync.WaitGroup
+                roomWorkers := make(limiter, roomConcurrency())
+                for _, roomID := range joinedRooms.JoinedRooms {
+                        // Skip the log rooms
+                        if isLogRoom(roomID) {
+                                fmt.Printf("Skipping log room: %s\n", roomID)
                                 continue
                         }
+                        release, err := roomWorkers.acquire(cycleCtx)
+                        if err != nil {
+                                fmt.Println("Check cycle stopped early:", err)
+                                break
+                        }
+                        rooms.Add(1)
+                        go func(roomID id.RoomID) {
+                                defer rooms.Done()
+                                defer release()
+                                checkRoom(ctx, cycleCtx, client, roomID, budget, notified)
+                        }(roomID)
+                }
+                rooms.Wait()
+                if ctx.Err() != nil {
+                        return
+                }
 
-                        // Check server statuses for the room
-                        var serverStatus []string
-                        var failedServers []string
+                // Remember which states have been reported
+                saveReportedStates()
 
-                        for userID := range resp.Joined {
-                                server := extractDomain(string(userID)) // Convert id.UserID to string
-                                status := checkServer(ctx, client, server)
+                // Check the servers that are monitored without sharing a room
+                checkStaticServers(ctx, cycleCtx, client)
+                endCycle()
 
-                                // Add to full status list
-                                serverStatus = append(serverStatus, fmt.Sprintf("%s - %s", server, status))
+                // Report hosting providers whose servers fail together, and the end of their outages
+                checkProviderOutages(ctx, client)
 
-                                // Add only failed servers to the failed list
-                                if strings.HasPrefix(status, "Failed") {
-                                        failedServers = append(failedServers, fmt.Sprintf("%s - %s", server, status))
-                                }
+                // Report servers whose events arrive with high delay
+                reportFederationLag(ctx, client)
+
+                // Alert about servers that are chronically slow
+                evaluateLatencySLOs(ctx, client)
+
+                // Send this cycle's alerts to Alertmanager
+                flushAlerts(ctx)
+
+                // Post aggregate federation statistics when they are due
+                if config().StatsInterval > 0 && time.Since(lastStatsReport) >= time.Duration(config().StatsInterval)*time.Second {
+                        sendReport(ctx, client, severityInfo, compileStats().String())
+                        lastStatsReport = time.Now()
+                }
+
+                // Post the periodic summary when it is due
+                sendSummary(ctx, client)
+
+                // Post the weekly availability digest when it is due
+                sendWeeklyDigest(ctx, client)
+
+                // Post the scheduled digest when it is due
+                sendDigest(ctx, client)
+
+                // Warn about certificates expiring soon
+                reportCertificates(ctx, client)
+
+                // Make sure reports can still be delivered
+                verifyLogRooms(ctx, client)
+
+                // Keep the account's devices from piling up
+                checkDevices(ctx, client)
+
+                // Remove check results past the retention
+                pruneHistory()
+
+                // Remove result files past their keep days
+                pruneArtifacts()
+
+                // Remove old reports from the log room
+                cleanupLogRoom(ctx, client)
+
+                // Check less often while the homeserver rate limits the account
+                adjustPacing(ctx, client, ticker)
+
+                // Print waiting message to console
+                fmt.Printf("Waiting for the next check, every %s\n", cycleInterval())
+        }
+}
+
+// checkRoom checks the servers of a monitored room and reports them. A panic is contained to the room, so one
+// malformed room can't take down the cycle; probes stop when the cycle's context ends.
+func checkRoom(ctx, cycleCtx context.Context, client *mautrix.Client, roomID id.RoomID, budget *probeBudget, notified *notifySet) {
+        defer func() {
+                if r := recover(); r != nil {
+                        fmt.Printf("Checking room %s failed: %v\n%s", roomID, r, debug.Stack())
+                }
+        }()
+
+        // Fetch room details (alias and title)
+        roomAlias, roomTitle := getRoomDetails(ctx, client, roomID)
+
+        // Format the room description
+        roomDescription := fmt.Sprintf("%s - %s ( %s )", roomAlias, roomTitle, roomID)
+        fmt.Println("Testing servers in room:", roomDescription)
+
+        // Fetch members of the room
+        members, err := joinedMembers(ctx, client, roomID)
+        if err != nil {
+                fmt.Printf("Failed to get joined members for room %s: %v\n", roomID, err)
+                return
+        }
+
+        // Record the room's size and report significant changes
+        roomServers := make(map[string]bool)
+        for userID := range members {
+                roomServers[extractDomain(string(userID))] = true
+        }
+        trackRoomTrend(ctx, client, roomID, roomDescription, len(members), len(roomServers))
+
+        // Make sure the room's aliases still lead to it, and notice changes to who can find and join it
+        verifyRoomAliases(ctx, client, roomID, roomDescription)
+        settings := checkRoomSettings(ctx, client, roomID, roomDescription)
+
+        // Check server statuses for the room
+        var serverStatus []string
+        var failedServers []reportRow
+        var warnedServers []reportRow
+        var maintenanceServers []reportRow
+        var recoveredServers []reportRow
+        var allServers []reportRow
+
+        // Check each server of the room once, considering at most the member limit
+        servers, skippedMembers := roomMemberServers(members)
+        recordRoomServers(roomID, servers)
+        recordRegistryRooms(roomID.String(), servers)
+        if skippedMembers > 0 {
+                fmt.Printf("Room %s exceeds the member limit, skipping %d members\n", roomID, skippedMembers)
+        }
+
+        // Check the servers in parallel; stable servers between their samples, down servers between their rechecks
+        // and those left out by the probe budget keep their latest result, if they have one
+        statuses := checkServers(cycleCtx, client, roomID.String(), servers, budget.plan(sampleServers(recheckServers(servers))))
+        if ctx.Err() != nil {
+                // Probes were aborted, so the room's results are incomplete
+                return
+        }
+        if cycleCtx.Err() != nil {
+                fmt.Printf("Check cycle stopped early (%v), room %s is not reported\n", cycleCtx.Err(), roomDescription)
+                return
+        }
+        sortServers(servers, statuses, memberCounts(members))
+
+        // Silenced servers are checked, but left out of the reports
+        dropSilenced(statuses)
+        deferred := 0
+
+        for _, server := range servers {
+                if isIgnored(server) || isSilenced(server) {
+                        continue
+                }
+                status, ok := statuses[server]
+                if !ok {
+                        deferred++
+                        continue
+                }
+
+                line := formatServerLine(server, status)
+
+                // Add to full status list
+                serverStatus = append(serverStatus, line)
+                allServers = append(allServers, reportRow{Server: server, Status: status, Line: line})
+
+                // Add only failed servers to the failed list
+                if strings.HasPrefix(status, "Failed") {
+                        failedServers = append(failedServers, reportRow{Server: server, Status: status, Line: line})
+                }
+
+                // Collect servers that answered but look misconfigured
+                if strings.HasPrefix(status, "Warning") {
+                        warnedServers = append(warnedServers, reportRow{Server: server, Status: status, Line: line})
+                }
+
+                // Failures inside planned maintenance are reported on their own
+                if strings.HasPrefix(status, "Maintenance") {
+                        maintenanceServers = append(maintenanceServers, reportRow{Server: server, Status: status, Line: line})
+                }
+
+                // Servers back from a failure are announced with how long they were down
+                if reportMode() == reportModeFull {
+                        if changed, previous := statusChanged(server, status); changed && previous == "Failed" && statusClass(status) == "OK" {
+                                recoveredServers = append(recoveredServers, newReportRow(server, status, recoveryNote(server)))
                         }
+                }
 
-                        // Combine the full status message for the console
-                        fullStatusMessage := fmt.Sprintf("Server statuses in room %s:\n%s", roomDescription, strings.Join(serverStatus, "\n"))
-                        fmt.Println(fullStatusMessage)
-
-                        // Send only failed servers to the Matrix logroom
-                        if len(failedServers) > 0 {
-                                failedStatusMessage := fmt.Sprintf("Failed servers in room %s:\n%s", roomDescription, strings.Join(failedServers, "\n"))
-                                sendMessageToRoom(ctx, client, id.RoomID(config.LogRoom), failedStatusMessage)
-                        } else {
-                                // If all servers are OK, send a success message to the logroom
-                                successMessage := fmt.Sprintf("All Servers in room %s are OK", roomDescription)
-                                sendMessageToRoom(ctx, client, id.RoomID(config.LogRoom), successMessage)
+                // Servers with their own notification channel, or tags routed to one, are also reported there
+                if strings.HasPrefix(status, "Failed") || strings.HasPrefix(status, "Warning") {
+                        raiseAlert(server, roomID.String(), status)
+                        for _, notify := range notifyRooms(server) {
+                                if !roomFilter(notify.String()).matches(server, roomID.String(), statusSeverity(status)) {
+                                        continue
+                                }
+                                if notified.first(server + " " + notify.String()) {
+                                        sendMessageToRoom(ctx, client, notify, fmt.Sprintf("%s (room %s)", line, roomDescription))
+                                }
                         }
                 }
+        }
 
-                // Print waiting message to console
-                fmt.Printf("Waiting for %d seconds\n", config.Interval)
+        if deferred > 0 {
+                fmt.Printf("Probe budget reached, %d servers in room %s are checked in a later cycle\n", deferred, roomID)
+        }
 
-                // Wait for the specified interval before checking again
-                time.Sleep(time.Duration(config.Interval) * time.Second)
+        // Warn about servers too old for the room's version
+        reportRoomVersionSupport(ctx, client, roomID, roomDescription, servers)
+
+        // Keep the room's results as a JSON file, if enabled
+        writeRoomArtifact(roomID, roomAlias, roomTitle, settings, servers, statuses)
+
+        // Combine the full status message for the console
+        fullStatusMessage := fmt.Sprintf("Server statuses in room %s (%s):\n%s", roomDescription, settings, strings.Join(serverStatus, "\n"))
+        fmt.Println(fullStatusMessage)
+
+        // Post only what changed since the last report, or the room's full state
+        if reportMode() == reportModeTransitions {
+                reportTransitions(ctx, client, roomID, roomDescription, servers, statuses)
+                return
+        }
+        if reportMode() == reportModeThreads {
+                reportThreads(ctx, client, roomID, roomDescription, servers, statuses)
+                return
+        }
+        if reportMode() == reportModeLive {
+                updateLiveStatus(ctx, client, roomID, roomDescription, allServers, settings)
+                return
+        }
+        if reportMode() == reportModeDiff && reportDiff(ctx, client, roomID, roomDescription, servers, statuses) {
+                return
         }
-}
 
+        // Send only failed servers to the Matrix logroom for critical alerts
+        // Failures at a hosting provider with an outage are reported once, as the provider's outage
+        settingsLine := fmt.Sprintf("Room settings: %s", settings)
+        failedServers, providerNote := groupProviderFailures(ctx, client, failedServers)
+        if providerNote != "" {
+                settingsLine = providerNote + "\n" + settingsLine
+        }
+        if len(failedServers) > 0 || providerNote != "" {
+                sendRoomReportRows(ctx, client, roomID, severityCritical, fmt.Sprintf("Failed servers in room %s", roomDescription), failedServers, settingsLine)
+        } else if len(warnedServers) == 0 && len(maintenanceServers) == 0 {
+                // If all servers are OK, send a success message to the logroom
+                sendRoomReportRows(ctx, client, roomID, severityInfo, fmt.Sprintf("All Servers in room %s are OK", roomDescription), nil, settingsLine)
+        }
+
+        // Report misconfigured servers separately from failures
+        if len(warnedServers) > 0 {
+                sendRoomReportRows(ctx, client, roomID, severityWarning, fmt.Sprintf("Servers with warnings in room %s", roomDescription), warnedServers, "")
+        }
+
+        // Report servers that are down for planned maintenance
+        if len(maintenanceServers) > 0 {
+                sendRoomReportRows(ctx, client, roomID, severityInfo, fmt.Sprintf("Servers in maintenance in room %s", roomDescription), maintenanceServers, "")
+        }
+
+        // Report servers that answer again after failing
+        if len(recoveredServers) > 0 {
+                sendRoomReportRows(ctx, client, roomID, severityInfo, fmt.Sprintf("Servers recovered in room %s", roomDescription), recoveredServers, "")
+        }
+}
 
+// waitForTick waits for the next tick of the check interval, or for the context to end
+func waitForTick(ctx context.Context, ticker *time.Ticker) {
+        select {
+        case <-ctx.Done():
+        case <-ticker.C:
+        }
+}
 
 const CanonicalAliasEventType = "m.room.canonical_alias" // Define the event type as a string
 
-// getRoomDetails fetches the main alias and title of a room
+// getRoomDetails fetches the main alias and title of a room. Rooms without a canonical alias fall back to their
+// first alternative alias and then their room ID, and rooms without a name get a label from their creation event.
+// Missing, redacted or unreadable state is only logged when it changes, not every cycle.
 func getRoomDetails(ctx context.Context, client *mautrix.Client, roomID id.RoomID) (string, string) {
+        var problems []string
+
         // Fetch the room name (title)
         var roomName struct {
                 Name string `json:"name"`
         }
-        err := client.StateEvent(ctx, roomID, event.StateRoomName, "", &roomName)
-        if err != nil || roomName.Name == "" {
-                roomName.Name = "(unknown title)"
+        if err := roomState(ctx, client, roomID, event.StateRoomName, "", &roomName); err != nil && !errors.Is(err, mautrix.MNotFound) {
+                problems = append(problems, fmt.Sprintf("cannot read the name: %v", err))
         }
 
-        // Fetch the canonical alias
-        canonicalAliasType := event.NewEventType("m.room.canonical_alias") // Create the type for m.room.canonical_alias
+        // Fetch the canonical alias, with its alternatives
         var canonicalAlias struct {
-                Alias string `json:"alias"`
+                Alias      string   `json:"alias"`
+                AltAliases []string `json:"alt_aliases"`
         }
-        err = client.StateEvent(ctx, roomID, canonicalAliasType, "", &canonicalAlias)
-        if err != nil || canonicalAlias.Alias == "" {
-                fmt.Printf("No canonical alias found for room %s\n", roomID)
-                return roomID.String(), roomName.Name // Use Room ID as fallback for alias
+        if err := roomState(ctx, client, roomID, event.StateCanonicalAlias, "", &canonicalAlias); err != nil && !errors.Is(err, mautrix.MNotFound) {
+                problems = append(problems, fmt.Sprintf("cannot read the aliases: %v", err))
+        }
+        alias := canonicalAlias.Alias
+        if alias == "" && len(canonicalAlias.AltAliases) > 0 {
+                alias = canonicalAlias.AltAliases[0]
+                problems = append(problems, "no canonical alias, using an alternative alias")
+        }
+        if alias == "" {
+                alias = roomID.String() // Use Room ID as fallback for alias
+                problems = append(problems, "no alias, using the room ID")
         }
 
-        // Use the canonical alias as the main alias
-        return canonicalAlias.Alias, roomName.Name
+        title := roomName.Name
+        if title == "" {
+                title = unnamedRoomTitle(ctx, client, roomID)
+        }
+        noteRoomDetailProblems(roomID, problems)
+        return alias, title
 }
 
+// unnamedRoomTitle labels a room without a name from its creation event, e.g. "(unnamed space by @alice:example.org)"
+func unnamedRoomTitle(ctx context.Context, client *mautrix.Client, roomID id.RoomID) string {
+        var create struct {
+                Creator string `json:"creator"`
+                Type    string `json:"type"`
+        }
+        if err := roomState(ctx, client, roomID, event.StateCreate, "", &create); err != nil {
+                return "(unnamed room)"
+        }
+        kind := "room"
+        if create.Type == "m.space" {
+                kind = "space"
+        }
+        if create.Creator != "" {
+                return fmt.Sprintf("(unnamed %s by %s)", kind, create.Creator)
+        }
+        return fmt.Sprintf("(unnamed %s)", kind)
+}
 
+var (
+        roomDetailProblemsMu sync.Mutex
+        roomDetailProblems   = make(map[id.RoomID]string) // Last logged state problems of each room
+)
 
+// noteRoomDetailProblems logs a room's missing or unreadable state when it differs from what was last logged
+func noteRoomDetailProblems(roomID id.RoomID, problems []string) {
+        summary := strings.Join(problems, "; ")
+        roomDetailProblemsMu.Lock()
+        defer roomDetailProblemsMu.Unlock()
+        if roomDetailProblems[roomID] == summary {
+                return
+        }
+        roomDetailProblems[roomID] = summary
+        if summary != "" {
+                fmt.Printf("Room %s: %s\n", roomID, summary)
+        }
+}
 
-// checkServer resolves and checks the online status of a server
+// checkServer checks a server outside a room's cycle
 func checkServer(ctx context.Context, client *mautrix.Client, server string) string {
-        matrixServer, err := resolveMatrixServer(server)
+        return checkServerInRoom(ctx, client, server, "")
+}
+
+// checkServerInRoom checks a server for a room's report, recording the result in the history,
+// and reports failures inside its maintenance windows as maintenance
+func checkServerInRoom(ctx context.Context, client *mautrix.Client, server, room string) string {
+        if pacer.wait(ctx) != nil {
+                return "Failed (Check aborted)"
+        }
+        status, result := probeWithRetries(ctx, client, server, config().ProbeRetries)
+        status, result = confirmRecovery(ctx, client, server, status, result)
+        if ctx.Err() != nil {
+                // An aborted probe says nothing about the server
+                return status
+        }
+        if strings.HasPrefix(status, "Failed") && inMaintenance(server, time.Now()) {
+                status = "Maintenance" + strings.TrimPrefix(status, "Failed")
+        }
+        // The check history keeps every failure, the reports only those past the failure threshold
+        recordCheck(server, room, status, result)
+        recordRegistry(server, status, result)
+        recordStreak(server, status)
+        status = confirmFailure(server, status)
+        recordBackoff(server, status)
+        recordResult(server, status, result)
+        recordCertificate(server, result)
+        trackOutage(server, room, status)
+        if !strings.HasPrefix(status, "Maintenance") {
+                recordHourlyAvailability(server, result.Online)
+                recordLatency(server, result)
+                if result.Online {
+                        observeLatency(server, result.Latency)
+                }
+        }
+        return status
+}
+
+// probeServer resolves and checks the online status of a server
+func probeServer(ctx context.Context, client *mautrix.Client, server string) (string, probeResult) {
+        if isOnion(server) && config().TorProxy == "" {
+                return "Failed (Onion service, no tor_proxy configured)", probeResult{}
+        }
+
+        candidates, err := resolveMatrixServer(ctx, server)
         if err != nil {
-                return fmt.Sprintf("Failed (Delegation Failed: %v)", err)
+                return fmt.Sprintf("Failed (Delegation Failed: %v)", err), probeResult{}
         }
 
-        if checkServerOnline(matrixServer) {
-                return "OK"
+        // Try each federation endpoint in turn until one answers
+        var result probeResult
+        var host string
+        for _, host = range candidates {
+                release, err := hostLimiter(host).acquire(ctx)
+                if err != nil {
+                        return "Failed (Check aborted)", result
+                }
+                result = checkServerOnline(ctx, host, probePath(server), probeTimeout(server))
+                release()
+                if result.Online || ctx.Err() != nil {
+                        break
+                }
+        }
+        if !result.Online {
+                if result.Redirect != "" {
+                        return fmt.Sprintf("Failed (Redirected to %s)", result.Redirect), result
+                }
+                if result.CertProblem != "" {
+                        return fmt.Sprintf("Failed (%s)", result.CertProblem), result
+                }
+                return "Failed (Unreachable)", result
+        }
+        if result.Redirect != "" {
+                return fmt.Sprintf("Warning (Redirected to %s)", result.Redirect), result
+        }
+
+        // Servers with invalid or expiring signing keys answer, but other servers stop accepting their events
+        if config().KeyCheck.Enabled {
+                release, err := hostLimiter(host).acquire(ctx)
+                if err != nil {
+                        return "Failed (Check aborted)", result
+                }
+                problem := checkServerKeys(ctx, server, host, probeTimeout(server))
+                release()
+                if problem != "" {
+                        return fmt.Sprintf("Warning (%s)", problem), result
+                }
         }
-        return "Failed (Unreachable)"
+
+        // Flag servers that don't run the software they are expected to; custom probe paths don't tell the software
+        expected := serverOverride(server).Software
+        if expected != "" && probePath(server) == versionPath && !strings.EqualFold(expected, result.Software) {
+                return fmt.Sprintf("Warning (Expected %s, found %s %s)", expected, result.Software, result.Version), result
+        }
+
+        // Servers that answer, but slowly, are degraded
+        if threshold := degradedLatency(server); threshold > 0 && result.Latency > threshold {
+                return fmt.Sprintf("Warning (Degraded: %s latency, over %s)", result.Latency.Round(time.Millisecond), threshold), result
+        }
+        return "OK", result
 }
 
 // extractDomain extracts the domain part of a Matrix UserID
@@ -243,40 +899,115 @@ func extractDomain(userID string) string {
         return ""
 }
 
-// checkServerOnline checks if a server is online by sending a GET request to the Matrix federation version endpoint
-func checkServerOnline(server string) bool {
-        url := fmt.Sprintf("https://%s/_matrix/federation/v1/version", server)
-        client := &http.Client{
-                Timeout: 5 * time.Second,
+// probeResult holds what a single federation probe learned about a server
+type probeResult struct {
+        Online   bool
+        Redirect string        // First redirect target seen, if the endpoint redirected
+        Software string        // server.name from the version response
+        Version  string        // server.version from the version response
+        Latency  time.Duration // Time until the version response was read
+
+        CertExpiry  time.Time // When the server's certificate expires, if the TLS handshake succeeded
+        CertProblem string    // Why the certificate was rejected, e.g. "Certificate expired"
+}
+
+// checkServerOnline checks if a server is online by sending a GET request to the Matrix federation version endpoint,
+// or to a custom probe path, which counts as online with any successful answer
+func checkServerOnline(ctx context.Context, server, path string, timeout time.Duration) probeResult {
+        var result probeResult
+        url := fmt.Sprintf("https://%s%s", server, path)
+        client := newProbeClient(server, timeout, &result.Redirect)
+        start := time.Now()
+        req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+        if err != nil {
+                fmt.Printf("Invalid probe URL %s: %v\n", url, err)
+                return result
         }
-        resp, err := client.Get(url)
+        resp, err := client.Do(req)
         if err != nil {
                 fmt.Printf("Failed to reach server %s: %v\n", server, err)
-                return false
+                result.CertProblem = certProblem(err)
+                return result
         }
         defer resp.Body.Close()
+        if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+                result.CertExpiry = resp.TLS.PeerCertificates[0].NotAfter
+        }
+
+        // A redirect the policy refused to follow leaves us with the 3xx response itself
+        if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+                fmt.Printf("Server %s redirects its federation endpoint to %s\n", server, result.Redirect)
+                return result
+        }
+
+        // Custom health paths needn't answer like the version endpoint
+        if path != versionPath {
+                if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+                        fmt.Printf("Server %s answered %s on %s\n", server, resp.Status, path)
+                        return result
+                }
+                result.Online = true
+                result.Latency = time.Since(start)
+                return result
+        }
 
         // Check if the response is valid JSON
-        var result map[string]interface{}
-        err = json.NewDecoder(resp.Body).Decode(&result)
+        var version struct {
+                Server struct {
+                        Name    string `json:"name"`
+                        Version string `json:"version"`
+                } `json:"server"`
+        }
+        err = json.NewDecoder(resp.Body).Decode(&version)
         if err != nil {
                 fmt.Printf("Invalid JSON response from server %s: %v\n", server, err)
-                return false
+                return result
         }
-        return true
+
+        result.Online = true
+        result.Latency = time.Since(start)
+        result.Software = version.Server.Name
+        result.Version = version.Server.Version
+        return result
 }
 
 // sendMessageToRoom sends a message to a Matrix room
 func sendMessageToRoom(ctx context.Context, client *mautrix.Client, roomID id.RoomID, message string) error {
-        _, err := client.SendText(ctx, roomID, message)
+        _, err := sendMessageContent(ctx, client, roomID, &event.MessageEventContent{MsgType: msgTypeFor(roomID), Body: message})
         return err
 }
 
-func loadConfig(path string) error {
+// sendMessageContent sends a message event to a Matrix room, logging in again if the session was lost,
+// and returns the event's ID
+func sendMessageContent(ctx context.Context, client *mautrix.Client, roomID id.RoomID, content *event.MessageEventContent) (id.EventID, error) {
+        token := client.AccessToken
+        var resp *mautrix.RespSendEvent
+        send := func() (err error) {
+                resp, err = client.SendMessageEvent(ctx, roomID, event.EventMessage, content)
+                return err
+        }
+        err := accountCall(ctx, true, send)
+        if isUnknownToken(err) {
+                if err := recoverSession(ctx, client, token); err != nil {
+                        return "", err
+                }
+                err = accountCall(ctx, true, send)
+        }
+        if err != nil {
+                return "", err
+        }
+        return resp.EventID, nil
+}
+
+// loadConfig reads a configuration file and its includes into c
+func loadConfig(path string, c *Config) error {
         fmt.Printf("Loading configuration from: %s\n", path)
         data, err := ioutil.ReadFile(path)
         if err != nil {
                 return err
         }
-        return yaml.Unmarshal(data, &config)
+        if err := decodeConfig(path, data, c); err != nil {
+                return err
+        }
+        return loadConfigIncludes(path, c)
 }
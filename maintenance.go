@@ -0,0 +1,94 @@
+package main
+
+import (
+        "fmt"
+        "strings"
+        "time"
+)
+
+// MaintenanceWindow describes recurring planned downtime, e.g. Sundays 03:00-04:00 UTC.
+// A window whose end is before its start runs past midnight into the next day.
+type MaintenanceWindow struct {
+        Days     []string `yaml:"days"`     // Weekdays the window starts on (e.g. sunday, sun); empty means every day
+        Start    string   `yaml:"start"`    // Start time as HH:MM
+        End      string   `yaml:"end"`      // End time as HH:MM
+        Timezone string   `yaml:"timezone"` // IANA timezone name, defaults to UTC
+}
+
+// inMaintenance reports whether the server is inside one of its maintenance windows at the given time
+func inMaintenance(server string, now time.Time) bool {
+        for _, window := range serverOverride(server).Maintenance {
+                active, err := window.Contains(now)
+                if err != nil {
+                        fmt.Printf("Invalid maintenance window for server %s: %v\n", server, err)
+                        continue
+                }
+                if active {
+                        return true
+                }
+        }
+        return false
+}
+
+// Contains reports whether the given time falls inside the window
+func (w MaintenanceWindow) Contains(now time.Time) (bool, error) {
+        location := time.UTC
+        if w.Timezone != "" {
+                var err error
+                location, err = time.LoadLocation(w.Timezone)
+                if err != nil {
+                        return false, err
+                }
+        }
+
+        start, err := parseClock(w.Start)
+        if err != nil {
+                return false, err
+        }
+        end, err := parseClock(w.End)
+        if err != nil {
+                return false, err
+        }
+
+        local := now.In(location)
+        minute := local.Hour()*60 + local.Minute()
+
+        // Same-day window
+        if start <= end {
+                return minute >= start && minute < end && w.onDay(local.Weekday()), nil
+        }
+
+        // Window running past midnight: either the evening part of a listed day,
+        // or the early part of the day after one
+        if minute >= start {
+                return w.onDay(local.Weekday()), nil
+        }
+        if minute < end {
+                return w.onDay((local.Weekday() + 6) % 7), nil
+        }
+        return false, nil
+}
+
+// onDay reports whether the window starts on the given weekday
+func (w MaintenanceWindow) onDay(day time.Weekday) bool {
+        if len(w.Days) == 0 {
+                return true
+        }
+        for _, d := range w.Days {
+                d = strings.ToLower(strings.TrimSpace(d))
+                name := strings.ToLower(day.String())
+                if d == name || d == name[:3] || d == name+"s" {
+                        return true
+                }
+        }
+        return false
+}
+
+// parseClock parses an HH:MM time of day into minutes since midnight
+func parseClock(value string) (int, error) {
+        t, err := time.Parse("15:04", value)
+        if err != nil {
+                return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+        }
+        return t.Hour()*60 + t.Minute(), nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+        "context"
+        "sync"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/event"
+        "maunium.net/go/mautrix/id"
+)
+
+// Joined members of the monitored rooms, fetched once per room with /members and kept up to date from the
+// membership events in the sync, so large rooms aren't downloaded in full every cycle
+var (
+        membersMu   sync.Mutex
+        roomMembers = make(map[id.RoomID]map[id.UserID]mautrix.JoinedMember)
+)
+
+// lazyLoadFilter keeps the sync from sending the full member list of every room; the timeline still
+// carries each membership change, which keeps the member cache current
+var lazyLoadFilter = &mautrix.Filter{
+        Room: &mautrix.RoomFilter{
+                State: &mautrix.FilterPart{LazyLoadMembers: true},
+        },
+}
+
+// joinedMembers returns the joined members of a room from the cache, fetching them if the room isn't cached yet
+func joinedMembers(ctx context.Context, client *mautrix.Client, roomID id.RoomID) (map[id.UserID]mautrix.JoinedMember, error) {
+        membersMu.Lock()
+        members, ok := roomMembers[roomID]
+        membersMu.Unlock()
+        if ok {
+                return members, nil
+        }
+
+        // Only joined members, instead of every membership event the room ever had
+        var resp *mautrix.RespMembers
+        err := accountCall(ctx, false, func() (err error) {
+                resp, err = client.Members(ctx, roomID, mautrix.ReqMembers{Membership: event.MembershipJoin})
+                return err
+        })
+        if err != nil {
+                return nil, err
+        }
+        members = make(map[id.UserID]mautrix.JoinedMember, len(resp.Chunk))
+        for _, evt := range resp.Chunk {
+                if evt.StateKey == nil {
+                        continue
+                }
+                _ = evt.Content.ParseRaw(evt.Type)
+                content := evt.Content.AsMember()
+                if content == nil || content.Membership != event.MembershipJoin {
+                        continue
+                }
+                members[id.UserID(*evt.StateKey)] = mautrix.JoinedMember{DisplayName: content.Displayname}
+        }
+
+        membersMu.Lock()
+        defer membersMu.Unlock()
+        roomMembers[roomID] = members
+        return members, nil
+}
+
+// updateMembers applies a membership event from the sync to the cached members of its room.
+// The cached map is replaced rather than changed, as callers may still be reading the old one.
+func updateMembers(evt *event.Event) {
+        if evt.StateKey == nil {
+                return
+        }
+        content := evt.Content.AsMember()
+        if content == nil {
+                return
+        }
+        membersMu.Lock()
+        defer membersMu.Unlock()
+        members, ok := roomMembers[evt.RoomID]
+        if !ok {
+                return
+        }
+        updated := make(map[id.UserID]mautrix.JoinedMember, len(members)+1)
+        for userID, member := range members {
+                updated[userID] = member
+        }
+        userID := id.UserID(*evt.StateKey)
+        if content.Membership == event.MembershipJoin {
+                updated[userID] = mautrix.JoinedMember{DisplayName: content.Displayname}
+        } else {
+                delete(updated, userID)
+        }
+        roomMembers[evt.RoomID] = updated
+}
+
+// dropStaleMembers forgets the members of rooms whose timeline had a gap in this sync, as membership changes
+// may have been skipped; they are fetched again when next needed
+func dropStaleMembers(resp *mautrix.RespSync) {
+        membersMu.Lock()
+        defer membersMu.Unlock()
+        for roomID, room := range resp.Rooms.Join {
+                if room.Timeline.Limited {
+                        delete(roomMembers, roomID)
+                }
+        }
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "net/http"
+        "sort"
+        "strconv"
+        "strings"
+        "sync"
+        "time"
+)
+
+// latencyBuckets are the upper bounds in seconds of the check latency histogram
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// latencyHistogram counts a server's successful checks by latency
+type latencyHistogram struct {
+        counts []uint64 // Per bucket, not cumulative
+        count  uint64
+        sum    float64
+}
+
+var (
+        histogramsMu sync.Mutex
+        histograms   = make(map[string]*latencyHistogram)
+)
+
+// observeLatency adds a successful check to the server's latency histogram
+func observeLatency(server string, latency time.Duration) {
+        histogramsMu.Lock()
+        defer histogramsMu.Unlock()
+        h := histograms[server]
+        if h == nil {
+                h = &latencyHistogram{counts: make([]uint64, len(latencyBuckets))}
+                histograms[server] = h
+        }
+        seconds := latency.Seconds()
+        for i, bound := range latencyBuckets {
+                if seconds <= bound {
+                        h.counts[i]++
+                        break
+                }
+        }
+        h.count++
+        h.sum += seconds
+}
+
+// startMetrics serves Prometheus metrics on metrics_listen in the background
+func startMetrics(ctx context.Context) {
+        if config().MetricsListen == "" {
+                return
+        }
+
+        mux := http.NewServeMux()
+        mux.HandleFunc("/metrics", handleMetrics)
+        server := &http.Server{Addr: config().MetricsListen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+        go func() {
+                <-ctx.Done()
+                server.Close()
+        }()
+        go func() {
+                fmt.Printf("Metrics listening on %s\n", config().MetricsListen)
+                if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+                        fmt.Println("Metrics server failed:", err)
+                }
+        }()
+}
+
+// handleMetrics writes the metrics of every checked server in the Prometheus text format
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+        resultsMu.RLock()
+        results := make([]serverResult, 0, len(latestResults))
+        for _, result := range latestResults {
+                results = append(results, result)
+        }
+        resultsMu.RUnlock()
+        sort.Slice(results, func(i, j int) bool { return results[i].Server < results[j].Server })
+
+        var b strings.Builder
+        writeFamily := func(name, kind, help string, value func(result serverResult) string) {
+                fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+                for _, result := range results {
+                        fmt.Fprintf(&b, "%s{server=%q} %s\n", name, result.Server, value(result))
+                }
+        }
+        writeFamily("matrix_health_server_up", "gauge", "Whether the server answered its last federation check", func(result serverResult) string {
+                if result.Online {
+                        return "1"
+                }
+                return "0"
+        })
+        writeFamily("matrix_health_last_check_timestamp_seconds", "gauge", "Time of the server's last check", func(result serverResult) string {
+                return strconv.FormatInt(result.CheckedAt.Unix(), 10)
+        })
+        writeFamily("matrix_health_checks_total", "counter", "Checks of the server since startup, outside maintenance", func(result serverResult) string {
+                return strconv.Itoa(result.Checks)
+        })
+        writeFamily("matrix_health_check_failures_total", "counter", "Checks the server didn't answer since startup, outside maintenance", func(result serverResult) string {
+                return strconv.Itoa(result.Checks - result.Successes)
+        })
+
+        b.WriteString("# HELP matrix_health_check_latency_seconds Latency of successful federation checks\n# TYPE matrix_health_check_latency_seconds histogram\n")
+        histogramsMu.Lock()
+        for _, result := range results {
+                h := histograms[result.Server]
+                if h == nil {
+                        continue
+                }
+                var cumulative uint64
+                for i, bound := range latencyBuckets {
+                        cumulative += h.counts[i]
+                        fmt.Fprintf(&b, "matrix_health_check_latency_seconds_bucket{server=%q,le=\"%s\"} %d\n",
+                                result.Server, strconv.FormatFloat(bound, 'f', -1, 64), cumulative)
+                }
+                fmt.Fprintf(&b, "matrix_health_check_latency_seconds_bucket{server=%q,le=\"+Inf\"} %d\n", result.Server, h.count)
+                fmt.Fprintf(&b, "matrix_health_check_latency_seconds_sum{server=%q} %s\n", result.Server, strconv.FormatFloat(h.sum, 'f', -1, 64))
+                fmt.Fprintf(&b, "matrix_health_check_latency_seconds_count{server=%q} %d\n", result.Server, h.count)
+        }
+        histogramsMu.Unlock()
+
+        w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+        fmt.Fprint(w, b.String())
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+        "context"
+        "crypto/ecdsa"
+        "crypto/elliptic"
+        "crypto/rand"
+        "crypto/tls"
+        "crypto/x509"
+        "crypto/x509/pkix"
+        "encoding/json"
+        "encoding/pem"
+        "flag"
+        "fmt"
+        "math/big"
+        "net"
+        "net/http"
+        "os"
+        "os/signal"
+        "strconv"
+        "strings"
+        "time"
+)
+
+// mockBehaviors are the fake federation servers mockfed can serve, each on its own port
+var mockBehaviors = map[string]string{
+        "healthy": "answers the version request",
+        "slow":    "answers after the configured delay",
+        "badjson": "answers with invalid JSON",
+        "error":   "answers with HTTP 500",
+        "expired": "presents an expired certificate",
+}
+
+// runMockFederation serves fake federation endpoints for demos and integration tests:
+//
+//	matrix-health mockfed [-listen 127.0.0.1] [-port 8441] [-servers healthy,slow,badjson,error,expired]
+//	        [-delay 5s] [-software Synapse] [-version 1.99.0] [-ca-out mockfed-ca.pem]
+//
+// Every server gets its own port, counting up from -port, and delegates to itself through .well-known,
+// so "127.0.0.1:8441" can be used as a server name, e.g. in static_servers. The certificates are issued
+// by a throwaway CA written to -ca-out, which probes trust when it is set as probe_ca_file.
+func runMockFederation(args []string) error {
+        flags := flag.NewFlagSet("mockfed", flag.ContinueOnError)
+        listen := flags.String("listen", "127.0.0.1", "address to listen on")
+        port := flags.Int("port", 8441, "port of the first server")
+        servers := flags.String("servers", "healthy,slow,badjson,error,expired", "comma-separated servers to run, in port order")
+        delay := flags.Duration("delay", 5*time.Second, "response delay of the slow server")
+        software := flags.String("software", "Synapse", "software name in version responses")
+        version := flags.String("version", "1.99.0", "software version in version responses")
+        caOut := flags.String("ca-out", "mockfed-ca.pem", "file the CA certificate is written to")
+        if err := flags.Parse(args); err != nil {
+                return err
+        }
+
+        ca, caKey, err := mockCertificateAuthority()
+        if err != nil {
+                return err
+        }
+        if err := os.WriteFile(*caOut, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0644); err != nil {
+                return err
+        }
+        fmt.Printf("CA certificate written to %s, set it as probe_ca_file to trust the mock servers\n", *caOut)
+
+        ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+        defer stop()
+
+        errs := make(chan error, 1)
+        var httpServers []*http.Server
+        for i, behavior := range strings.Split(*servers, ",") {
+                behavior = strings.TrimSpace(behavior)
+                if _, ok := mockBehaviors[behavior]; !ok {
+                        return fmt.Errorf("unknown mock server %q", behavior)
+                }
+                name := net.JoinHostPort(*listen, strconv.Itoa(*port+i))
+                cert, err := mockCertificate(ca, caKey, *listen, behavior == "expired")
+                if err != nil {
+                        return err
+                }
+
+                server := &http.Server{
+                        Addr:              name,
+                        Handler:           mockFederationHandler(name, behavior, *delay, *software, *version),
+                        TLSConfig:         &tls.Config{Certificates: []tls.Certificate{cert}},
+                        ReadHeaderTimeout: 10 * time.Second,
+                }
+                httpServers = append(httpServers, server)
+                go func() {
+                        if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+                                select {
+                                case errs <- err:
+                                default:
+                                }
+                        }
+                }()
+                fmt.Printf("Mock server %s: %s\n", name, mockBehaviors[behavior])
+        }
+
+        select {
+        case <-ctx.Done():
+        case err = <-errs:
+        }
+        for _, server := range httpServers {
+                server.Close()
+        }
+        return err
+}
+
+// mockFederationHandler serves the endpoints probes use, behaving as configured
+func mockFederationHandler(name, behavior string, delay time.Duration, software, version string) http.Handler {
+        mux := http.NewServeMux()
+        mux.HandleFunc("/.well-known/matrix/server", func(w http.ResponseWriter, r *http.Request) {
+                writeJSON(w, map[string]string{"m.server": name})
+        })
+        mux.HandleFunc("/_matrix/federation/v1/version", func(w http.ResponseWriter, r *http.Request) {
+                switch behavior {
+                case "slow":
+                        select {
+                        case <-r.Context().Done():
+                                return
+                        case <-time.After(delay):
+                        }
+                case "badjson":
+                        w.Header().Set("Content-Type", "application/json")
+                        fmt.Fprint(w, `{"server": {"name": "`)
+                        return
+                case "error":
+                        http.Error(w, `{"errcode":"M_UNKNOWN","error":"Internal server error"}`, http.StatusInternalServerError)
+                        return
+                }
+                var resp struct {
+                        Server struct {
+                                Name    string `json:"name"`
+                                Version string `json:"version"`
+                        } `json:"server"`
+                }
+                resp.Server.Name, resp.Server.Version = software, version
+                w.Header().Set("Content-Type", "application/json")
+                json.NewEncoder(w).Encode(resp)
+        })
+        return mux
+}
+
+// mockCertificateAuthority creates the throwaway CA issuing the mock servers' certificates
+func mockCertificateAuthority() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+        key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+        if err != nil {
+                return nil, nil, err
+        }
+        template := &x509.Certificate{
+                SerialNumber:          big.NewInt(1),
+                Subject:               pkix.Name{CommonName: "matrix-health mockfed CA"},
+                NotBefore:             time.Now().Add(-time.Hour),
+                NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+                KeyUsage:              x509.KeyUsageCertSign,
+                BasicConstraintsValid: true,
+                IsCA:                  true,
+        }
+        der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+        if err != nil {
+                return nil, nil, err
+        }
+        ca, err := x509.ParseCertificate(der)
+        return ca, key, err
+}
+
+// mockCertificate issues a server certificate for the listen address, expired a day ago if asked to
+func mockCertificate(ca *x509.Certificate, caKey *ecdsa.PrivateKey, host string, expired bool) (tls.Certificate, error) {
+        key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+        if err != nil {
+                return tls.Certificate{}, err
+        }
+        serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+        if err != nil {
+                return tls.Certificate{}, err
+        }
+        template := &x509.Certificate{
+                SerialNumber: serial,
+                Subject:      pkix.Name{CommonName: host},
+                NotBefore:    time.Now().Add(-time.Hour),
+                NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+                KeyUsage:     x509.KeyUsageDigitalSignature,
+                ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+        }
+        if expired {
+                template.NotBefore = time.Now().Add(-48 * time.Hour)
+                template.NotAfter = time.Now().Add(-24 * time.Hour)
+        }
+        if ip := net.ParseIP(host); ip != nil {
+                template.IPAddresses = []net.IP{ip}
+        } else {
+                template.DNSNames = []string{host}
+        }
+
+        der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+        if err != nil {
+                return tls.Certificate{}, err
+        }
+        return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+        "fmt"
+        "strings"
+        "sync"
+        "time"
+
+        "maunium.net/go/mautrix/id"
+)
+
+// serverNote is a note attached to a server at runtime with !note
+type serverNote struct {
+        Text   string
+        Author id.UserID
+        Added  time.Time
+}
+
+// Notes attached at runtime, kept in the store, on top of those from the config
+var (
+        notesMu      sync.RWMutex
+        runtimeNotes = make(map[string][]serverNote)
+)
+
+// loadNotes loads the notes attached at runtime from the store
+func loadNotes() error {
+        rows, err := db.Query(`SELECT server, note, author, added FROM server_notes ORDER BY added`)
+        if err != nil {
+                return err
+        }
+        defer rows.Close()
+
+        notesMu.Lock()
+        defer notesMu.Unlock()
+        for rows.Next() {
+                var server, text, author string
+                var added int64
+                if err := rows.Scan(&server, &text, &author, &added); err != nil {
+                        return err
+                }
+                runtimeNotes[server] = append(runtimeNotes[server], serverNote{Text: text, Author: id.UserID(author), Added: time.Unix(added, 0)})
+        }
+        return rows.Err()
+}
+
+// addNote attaches a note to a server at runtime
+func addNote(server, text string, author id.UserID) error {
+        server = strings.ToLower(server)
+        note := serverNote{Text: text, Author: author, Added: time.Now()}
+        if _, err := db.Exec(`INSERT INTO server_notes (server, note, author, added) VALUES (?, ?, ?, ?)`,
+                server, note.Text, note.Author.String(), note.Added.Unix()); err != nil {
+                return err
+        }
+        recordAudit(author.String(), "note", server, "", text)
+        notesMu.Lock()
+        defer notesMu.Unlock()
+        runtimeNotes[server] = append(runtimeNotes[server], note)
+        return nil
+}
+
+// clearNotes removes the notes attached to a server at runtime
+func clearNotes(server string, actor id.UserID) error {
+        server = strings.ToLower(server)
+        if _, err := db.Exec(`DELETE FROM server_notes WHERE server = ?`, server); err != nil {
+                return err
+        }
+        notesMu.Lock()
+        defer notesMu.Unlock()
+        var old []string
+        for _, note := range runtimeNotes[server] {
+                old = append(old, note.Text)
+        }
+        delete(runtimeNotes, server)
+        recordAudit(actor.String(), "unnote", server, strings.Join(old, "; "), "")
+        return nil
+}
+
+// serverNotes returns a server's notes: the one from the config first, then those attached with !note
+func serverNotes(server string) []string {
+        var notes []string
+        if note := serverOverride(server).Notes; note != "" {
+                notes = append(notes, note)
+        }
+        notesMu.RLock()
+        for _, note := range runtimeNotes[strings.ToLower(server)] {
+                notes = append(notes, note.Text)
+        }
+        notesMu.RUnlock()
+        return notes
+}
+
+// commandNote shows a server's notes and runbook, or attaches a note, e.g. "!note example.org reboots nightly at 03:00"
+func commandNote(args []string, author id.UserID) string {
+        if len(args) == 0 {
+                return "Usage: !note <server> [text]"
+        }
+        server := args[0]
+        if len(args) > 1 {
+                if err := addNote(server, strings.Join(args[1:], " "), author); err != nil {
+                        return fmt.Sprintf("Failed to add a note to %s: %v", server, err)
+                }
+        }
+
+        var b strings.Builder
+        fmt.Fprintf(&b, "Notes for %s:", server)
+        notes := serverNotes(server)
+        for _, note := range notes {
+                fmt.Fprintf(&b, "\n- %s", note)
+        }
+        if runbook := serverOverride(server).Runbook; runbook != "" {
+                fmt.Fprintf(&b, "\nRunbook: %s", runbook)
+        } else if len(notes) == 0 {
+                b.WriteString(" none")
+        }
+        return b.String()
+}
+
+// commandUnnote removes the notes attached to a server with !note, e.g. "!unnote example.org"
+func commandUnnote(args []string, actor id.UserID) string {
+        if len(args) != 1 {
+                return "Usage: !unnote <server>"
+        }
+        if err := clearNotes(args[0], actor); err != nil {
+                return fmt.Sprintf("Failed to remove the notes of %s: %v", args[0], err)
+        }
+        return fmt.Sprintf("Removed the notes of %s", args[0])
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+        "fmt"
+        "sort"
+        "strings"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/id"
+)
+
+// reportOrderKeys are the sort keys report_order accepts
+var reportOrderKeys = map[string]bool{"status": true, "impact": true, "name": true}
+
+// statusRank orders statuses from most to least severe
+func statusRank(status string) int {
+        switch {
+        case strings.HasPrefix(status, "Failed"):
+                return 0
+        case strings.HasPrefix(status, "Warning"):
+                return 1
+        case strings.HasPrefix(status, "Maintenance"):
+                return 2
+        case strings.HasPrefix(status, "OK"):
+                return 3
+        }
+        return 4
+}
+
+// reportOrder returns the configured sort keys, defaulting to status, then impact, then name
+func reportOrder() []string {
+        if len(config().ReportOrder) == 0 {
+                return []string{"status", "impact", "name"}
+        }
+        return config().ReportOrder
+}
+
+// validateReportOrder checks that report_order only uses known keys
+func validateReportOrder(c *Config) error {
+        for _, key := range c.ReportOrder {
+                if !reportOrderKeys[key] {
+                        return fmt.Errorf("unknown report_order key %q (expected status, impact or name)", key)
+                }
+        }
+        return nil
+}
+
+// memberCounts returns how many of a room's members are on each server, the impact of that server failing
+func memberCounts(members map[id.UserID]mautrix.JoinedMember) map[string]int {
+        counts := make(map[string]int)
+        for userID := range members {
+                counts[extractDomain(string(userID))]++
+        }
+        return counts
+}
+
+// sortServers orders servers for a report by the report_order keys, so reports are stable between cycles
+// and easy to diff. The server name always breaks remaining ties.
+func sortServers(servers []string, statuses map[string]string, impact map[string]int) {
+        keys := reportOrder()
+        sort.SliceStable(servers, func(i, j int) bool {
+                a, b := servers[i], servers[j]
+                for _, key := range keys {
+                        switch key {
+                        case "status":
+                                if ra, rb := statusRank(statuses[a]), statusRank(statuses[b]); ra != rb {
+                                        return ra < rb
+                                }
+                        case "impact":
+                                if impact[a] != impact[b] {
+                                        return impact[a] > impact[b]
+                                }
+                        case "name":
+                                if a != b {
+                                        return a < b
+                                }
+                        }
+                }
+                return a < b
+        })
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+        "database/sql"
+        "fmt"
+        "strings"
+        "time"
+)
+
+// trackOutage opens an outage in the store when a server starts failing and closes it when it answers again,
+// as seen from the given room. Checks inside maintenance windows neither open nor close outages.
+func trackOutage(server, room, status string) {
+        if strings.HasPrefix(status, "Maintenance") {
+                return
+        }
+
+        var started int64
+        err := db.QueryRow(`SELECT started FROM outages WHERE server = ? AND ended IS NULL`, server).Scan(&started)
+        open := err == nil
+        if err != nil && err != sql.ErrNoRows {
+                fmt.Printf("Failed to look up outages of %s: %v\n", server, err)
+                return
+        }
+
+        now := time.Now().Unix()
+        switch failed := strings.HasPrefix(status, "Failed"); {
+        case failed && !open:
+                if _, err = db.Exec(`INSERT INTO outages (server, started) VALUES (?, ?)`, server, now); err == nil {
+                        notifyOutage(server, room, "started", status, now, 0)
+                }
+        case !failed && open:
+                if _, err = db.Exec(`UPDATE outages SET ended = ? WHERE server = ? AND ended IS NULL`, now, server); err == nil {
+                        notifyOutage(server, room, "ended", status, started, now)
+                }
+                // Keep a month of outages, enough to describe the last week and the outage before it
+                db.Exec(`DELETE FROM outages WHERE server = ? AND ended < ?`, server, time.Now().Add(-30*24*time.Hour).Unix())
+        }
+        if err != nil {
+                fmt.Printf("Failed to record outage of %s: %v\n", server, err)
+        }
+}
+
+// outageHistory describes a failing server's recent outages, e.g. "3rd outage this week; last one lasted 45m"
+func outageHistory(server string) string {
+        var count int
+        if err := db.QueryRow(`SELECT COUNT(*) FROM outages WHERE server = ? AND started >= ?`,
+                server, time.Now().Add(-7*24*time.Hour).Unix()).Scan(&count); err != nil || count == 0 {
+                return ""
+        }
+        if count == 1 {
+                return "first outage this week"
+        }
+
+        history := fmt.Sprintf("%s outage this week", ordinal(count))
+        var started, ended int64
+        if err := db.QueryRow(`SELECT started, ended FROM outages WHERE server = ? AND ended IS NOT NULL ORDER BY ended DESC LIMIT 1`,
+                server).Scan(&started, &ended); err == nil {
+                history += fmt.Sprintf("; last one lasted %s", time.Duration(ended-started)*time.Second)
+        }
+        return history
+}
+
+// recoveryNote describes how long a recovering server was down, e.g. "recovered after 3h 24m", from the outage that
+// ended after its failure was reported, or returns "" if no such outage is stored
+func recoveryNote(server string) string {
+        var started, ended int64
+        err := db.QueryRow(`SELECT started, ended FROM outages WHERE server = ? AND ended IS NOT NULL
+                AND ended >= COALESCE((SELECT changed FROM server_state WHERE server = ?), 0) ORDER BY ended DESC LIMIT 1`,
+                server, server).Scan(&started, &ended)
+        if err != nil {
+                if err != sql.ErrNoRows {
+                        fmt.Printf("Failed to look up the last outage of %s: %v\n", server, err)
+                }
+                return ""
+        }
+        return "recovered after " + formatDowntime(time.Duration(ended-started)*time.Second)
+}
+
+// formatDowntime formats a duration in its two largest units, e.g. "2d 5h", "3h 24m" or "45s"
+func formatDowntime(d time.Duration) string {
+        days, hours, minutes := int(d/(24*time.Hour)), int(d/time.Hour)%24, int(d/time.Minute)%60
+        switch {
+        case days > 0:
+                return fmt.Sprintf("%dd %dh", days, hours)
+        case hours > 0:
+                return fmt.Sprintf("%dh %dm", hours, minutes)
+        case minutes > 0:
+                return fmt.Sprintf("%dm", minutes)
+        }
+        return fmt.Sprintf("%ds", int(d/time.Second))
+}
+
+// ordinal formats a number as 1st, 2nd, 3rd, 4th...
+func ordinal(n int) string {
+        suffix := "th"
+        if n%100 < 11 || n%100 > 13 {
+                switch n % 10 {
+                case 1:
+                        suffix = "st"
+                case 2:
+                        suffix = "nd"
+                case 3:
+                        suffix = "rd"
+                }
+        }
+        return fmt.Sprintf("%d%s", n, suffix)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+        "fmt"
+        "strings"
+)
+
+// ServerOverride holds settings for a single monitored server that replace the global defaults
+type ServerOverride struct {
+        Timeout     int      `yaml:"timeout"`     // Probe timeout in seconds
+        ProbePath   string   `yaml:"probe_path"`  // Path probed instead of the global probe_path
+        Software    string   `yaml:"software"`    // Expected server software (e.g. Synapse), mismatches are reported as warnings
+        Criticality string   `yaml:"criticality"` // Free-form criticality tag shown in reports (e.g. critical, low)
+        Contact     string   `yaml:"contact"`     // Who to contact when the server has problems
+        Notify      string   `yaml:"notify"`      // Additional room ID that receives this server's failures and warnings
+        Tags        []string `yaml:"tags"`        // Arbitrary labels for grouping servers
+        Notes       string   `yaml:"notes"`       // Free-form notes for responders, shown with the server's problems
+        Runbook     string   `yaml:"runbook"`     // Runbook URL, shown with the server's problems
+        Impact      string   `yaml:"impact"`      // What an outage of the server means to users, e.g. "bridge to corporate IRC"
+
+        FailureThreshold int `yaml:"failure_threshold"` // Failed checks in a row before this server is reported as down
+        DegradedLatency  int `yaml:"degraded_latency"`  // Latency in milliseconds above which this server is reported as degraded
+
+        Maintenance []MaintenanceWindow `yaml:"maintenance"` // Recurring planned downtime
+}
+
+// serverOverride returns the override block for a server, or an empty one if none is configured.
+// Metadata from the external inventory takes precedence over the config file, as it is the source of truth.
+func serverOverride(server string) ServerOverride {
+        override := config().Servers[strings.ToLower(server)]
+        if windows := fileMaintenance(server); len(windows) > 0 {
+                override.Maintenance = append(override.Maintenance[:len(override.Maintenance):len(override.Maintenance)], windows...)
+        }
+        if entry, ok := inventoryOverride(server); ok {
+                if len(entry.Tags) > 0 {
+                        override.Tags = entry.Tags
+                }
+                if entry.Contact != "" {
+                        override.Contact = entry.Contact
+                }
+                if entry.Criticality != "" {
+                        override.Criticality = entry.Criticality
+                }
+                if entry.Impact != "" {
+                        override.Impact = entry.Impact
+                }
+        }
+        return override
+}
+
+// serverImpact describes what an outage of a server means to users: its own impact description, or those of its tags
+func serverImpact(server string) string {
+        if impact := serverOverride(server).Impact; impact != "" {
+                return impact
+        }
+        var impacts []string
+        for _, tag := range serverTags(server) {
+                if impact := config().TagImpacts[tag]; impact != "" {
+                        impacts = append(impacts, impact)
+                }
+        }
+        return strings.Join(impacts, "; ")
+}
+
+// withImpact appends a server's impact description to a report line, e.g. "example.org - 99.1% available (impact: ...)"
+func withImpact(line, server string) string {
+        if impact := serverImpact(server); impact != "" {
+                return fmt.Sprintf("%s (impact: %s)", line, impact)
+        }
+        return line
+}
+
+// formatServerLine formats a report line for a server, annotated with its criticality, contact, notes, runbook and recent outages
+func formatServerLine(server, status string) string {
+        line := fmt.Sprintf("%s - %s", server, status)
+        override := serverOverride(server)
+        if override.Criticality != "" {
+                line += fmt.Sprintf(" [%s]", override.Criticality)
+        }
+        if status != "OK" && override.Contact != "" {
+                line += fmt.Sprintf(" (contact: %s)", override.Contact)
+        }
+        if status != "OK" {
+                if notes := serverNotes(server); len(notes) > 0 {
+                        line += fmt.Sprintf(" (notes: %s)", strings.Join(notes, "; "))
+                }
+                if override.Runbook != "" {
+                        line += fmt.Sprintf(" (runbook: %s)", override.Runbook)
+                }
+        }
+        if strings.HasPrefix(status, "Failed") {
+                if history := outageHistory(server); history != "" {
+                        line += fmt.Sprintf(" (%s)", history)
+                }
+        }
+        line += latencyNote(server, status)
+        if eol := eolNote(server); eol != "" {
+                line += fmt.Sprintf(" (%s)", eol)
+        }
+        return line
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+        "bytes"
+        "context"
+        "encoding/json"
+        "fmt"
+        "os/exec"
+        "path"
+        "sort"
+        "strings"
+        "time"
+
+        "maunium.net/go/mautrix/id"
+)
+
+// PipelineStep is one transform of report_pipeline, applied to every room report before it is rendered
+type PipelineStep struct {
+        Type     string   `yaml:"type"`     // filter, sort, truncate or command
+        Statuses []string `yaml:"statuses"` // filter: status classes kept (OK, Failed, Warning, Maintenance)
+        Servers  []string `yaml:"servers"`  // filter: server name patterns kept (path.Match globs, e.g. *.example.org)
+        Tags     []string `yaml:"tags"`     // filter: tags kept
+        Exclude  bool     `yaml:"exclude"`  // filter: drop the matching servers instead of keeping them
+        By       string   `yaml:"by"`       // sort: name, status (most severe first) or latency (slowest first)
+        Max      int      `yaml:"max"`      // truncate: servers kept, the others are counted in the footer
+        Command  []string `yaml:"command"`  // command: program and arguments, reading the report as JSON and printing it back changed
+        Timeout  int      `yaml:"timeout"`  // command: seconds it may run, defaults to 10
+}
+
+// structuredReport is a room report before rendering, as report_pipeline commands read and write it
+type structuredReport struct {
+        Room     string      `json:"room"`
+        Severity string      `json:"severity"`
+        Header   string      `json:"header"`
+        Rows     []reportRow `json:"rows"`
+        Footer   string      `json:"footer"`
+}
+
+// validateReportPipeline checks the steps of report_pipeline
+func validateReportPipeline(c *Config) error {
+        for i, step := range c.ReportPipeline {
+                switch step.Type {
+                case "filter":
+                        for _, pattern := range step.Servers {
+                                if _, err := path.Match(pattern, ""); err != nil {
+                                        return fmt.Errorf("invalid server pattern %q in report_pipeline step %d", pattern, i+1)
+                                }
+                        }
+                case "sort":
+                        if step.By != "name" && step.By != "status" && step.By != "latency" {
+                                return fmt.Errorf("unknown sort key %q in report_pipeline step %d (expected name, status or latency)", step.By, i+1)
+                        }
+                case "truncate":
+                        if step.Max <= 0 {
+                                return fmt.Errorf("report_pipeline step %d truncates to %d servers", i+1, step.Max)
+                        }
+                case "command":
+                        if len(step.Command) == 0 {
+                                return fmt.Errorf("report_pipeline step %d has no command", i+1)
+                        }
+                default:
+                        return fmt.Errorf("unknown report_pipeline step type %q (expected filter, sort, truncate or command)", step.Type)
+                }
+        }
+        return nil
+}
+
+// applyReportPipeline runs a room report through the report_pipeline steps. A failing command leaves the report
+// as it was before that step. It reports false when the steps removed every server of a report that had some,
+// so there is nothing left to send.
+func applyReportPipeline(ctx context.Context, roomID id.RoomID, sev severity, header string, rows []reportRow, footer string) (string, []reportRow, string, bool) {
+        if len(config().ReportPipeline) == 0 {
+                return header, rows, footer, true
+        }
+        report := structuredReport{Room: roomID.String(), Severity: string(sev), Header: header, Rows: rows, Footer: footer}
+        for i, step := range config().ReportPipeline {
+                switch step.Type {
+                case "filter":
+                        report.Rows = filterRows(report.Rows, step)
+                case "sort":
+                        sortRows(report.Rows, step.By)
+                case "truncate":
+                        if left := len(report.Rows) - step.Max; left > 0 {
+                                report.Rows = report.Rows[:step.Max]
+                                report.Footer = strings.TrimSpace(fmt.Sprintf("%d more servers not shown\n%s", left, report.Footer))
+                        }
+                case "command":
+                        transformed, err := runPipelineCommand(ctx, step, report)
+                        if err != nil {
+                                fmt.Printf("report_pipeline step %d (%s) failed, skipping it: %v\n", i+1, step.Command[0], err)
+                                continue
+                        }
+                        report = transformed
+                }
+        }
+        return report.Header, report.Rows, report.Footer, len(rows) == 0 || len(report.Rows) > 0
+}
+
+// filterRows keeps the rows a filter step matches, or drops them if it excludes
+func filterRows(rows []reportRow, step PipelineStep) []reportRow {
+        match := NotifyFilter{Servers: step.Servers, Tags: step.Tags}
+        var kept []reportRow
+        for _, row := range rows {
+                matches := match.matches(row.Server, "", statusSeverity(row.Status))
+                if len(step.Statuses) > 0 && !containsFold(step.Statuses, statusClass(row.Status)) {
+                        matches = false
+                }
+                if matches != step.Exclude {
+                        kept = append(kept, row)
+                }
+        }
+        return kept
+}
+
+// sortRows orders rows by name, status (most severe first) or latency (slowest first), keeping ties in order
+func sortRows(rows []reportRow, by string) {
+        sort.SliceStable(rows, func(i, j int) bool {
+                switch by {
+                case "status":
+                        return statusRank(rows[i].Status) < statusRank(rows[j].Status)
+                case "latency":
+                        a, _ := latestResult(rows[i].Server)
+                        b, _ := latestResult(rows[j].Server)
+                        return a.Latency > b.Latency
+                }
+                return rows[i].Server < rows[j].Server
+        })
+}
+
+// runPipelineCommand passes a report to a command as JSON on its standard input and reads the transformed report
+// from its standard output. The room and severity can't be changed, as they decide where the report goes.
+func runPipelineCommand(ctx context.Context, step PipelineStep, report structuredReport) (structuredReport, error) {
+        input, err := json.Marshal(report)
+        if err != nil {
+                return report, err
+        }
+        timeout := time.Duration(step.Timeout) * time.Second
+        if timeout <= 0 {
+                timeout = 10 * time.Second
+        }
+        ctx, cancel := context.WithTimeout(ctx, timeout)
+        defer cancel()
+
+        cmd := exec.CommandContext(ctx, step.Command[0], step.Command[1:]...)
+        cmd.Stdin = bytes.NewReader(input)
+        var stderr bytes.Buffer
+        cmd.Stderr = &stderr
+        output, err := cmd.Output()
+        if message := strings.TrimSpace(stderr.String()); err != nil && message != "" {
+                return report, fmt.Errorf("%v: %s", err, message)
+        } else if err != nil {
+                return report, err
+        }
+
+        var transformed structuredReport
+        if err := json.Unmarshal(output, &transformed); err != nil {
+                return report, fmt.Errorf("invalid output: %w", err)
+        }
+        transformed.Room, transformed.Severity = report.Room, report.Severity
+        return transformed, nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+        "context"
+        "crypto/tls"
+        "crypto/x509"
+        "fmt"
+        "net"
+        "net/http"
+        "net/url"
+        "os"
+        "strings"
+        "sync"
+        "time"
+
+        "maunium.net/go/mautrix"
+)
+
+// RedirectPolicy controls how federation probes treat HTTP redirects.
+// Federation requests are not supposed to be redirected at all, so by default none are followed.
+type RedirectPolicy struct {
+        Max            int  `yaml:"max"`              // Maximum number of redirects to follow (0 = never follow)
+        AllowCrossHost bool `yaml:"allow_cross_host"` // Follow redirects that point to a different host
+}
+
+// newProbeClient creates the HTTP client used for federation probes of the given server.
+// The first redirect target encountered is stored in redirect, whether or not it was followed.
+func newProbeClient(server string, timeout time.Duration, redirect *string) *http.Client {
+        return &http.Client{
+                Transport: newProbeTransport(server, timeout),
+                Timeout:   timeout,
+                CheckRedirect: func(req *http.Request, via []*http.Request) error {
+                        if *redirect == "" {
+                                *redirect = req.URL.String()
+                        }
+
+                        // Stop at the limit and hand back the redirect response itself
+                        if len(via) > config().Redirects.Max {
+                                return http.ErrUseLastResponse
+                        }
+
+                        // Never leave the original host unless explicitly allowed
+                        if !config().Redirects.AllowCrossHost && req.URL.Host != via[0].URL.Host {
+                                return http.ErrUseLastResponse
+                        }
+                        return nil
+                },
+        }
+}
+
+// newProbeTransport creates the transport used for federation probes, with connect and TLS
+// handshake timeouts matching the server's probe timeout.
+// Connections race IPv6 and IPv4 (RFC 8305 "Happy Eyeballs"): when the first address family
+// doesn't connect within the fallback delay, the other family is tried in parallel, so servers
+// with broken AAAA records don't use up the whole probe timeout before IPv4 is attempted.
+func newProbeTransport(server string, timeout time.Duration) http.RoundTripper {
+        // Onion services are only reachable through Tor, which resolves the name itself
+        if isOnion(server) {
+                return newChaosTransport(&http.Transport{
+                        Proxy:             http.ProxyURL(&url.URL{Scheme: "socks5", Host: config().TorProxy}),
+                        DisableKeepAlives: true,
+                }, false)
+        }
+
+        fallbackDelay := 250 * time.Millisecond // Connection Attempt Delay recommended by RFC 8305
+        if config().DialFallbackDelay > 0 {
+                fallbackDelay = time.Duration(config().DialFallbackDelay) * time.Millisecond
+        }
+
+        dialer := &net.Dialer{
+                Timeout:       timeout,
+                FallbackDelay: fallbackDelay,
+        }
+
+        // Pin probe traffic to a local address and/or interface, e.g. a specific WAN link
+        if config().ProbeSourceAddress != "" {
+                dialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(config().ProbeSourceAddress)}
+        }
+        if config().ProbeInterface != "" {
+                dialer.Control = bindToInterface(config().ProbeInterface)
+        }
+
+        return newChaosTransport(&http.Transport{
+                Proxy:               http.ProxyFromEnvironment,
+                DialContext:         dialer.DialContext,
+                TLSClientConfig:     probeTLSConfig(),
+                TLSHandshakeTimeout: timeout,
+                DisableKeepAlives:   true, // Every probe should measure a fresh connection
+        }, false)
+}
+
+var (
+        probeRootsOnce sync.Once
+        probeRoots     *x509.CertPool
+)
+
+// probeTLSConfig returns the TLS settings for probes: the system roots, plus the certificates
+// in probe_ca_file if set (e.g. the CA of mockfed or of a private federation)
+func probeTLSConfig() *tls.Config {
+        if config().ProbeCAFile == "" {
+                return nil
+        }
+        probeRootsOnce.Do(func() {
+                roots, err := x509.SystemCertPool()
+                if err != nil {
+                        roots = x509.NewCertPool()
+                }
+                data, err := os.ReadFile(config().ProbeCAFile)
+                if err == nil && !roots.AppendCertsFromPEM(data) {
+                        err = fmt.Errorf("no certificates found")
+                }
+                if err != nil {
+                        fmt.Printf("Failed to load probe_ca_file %s: %v\n", config().ProbeCAFile, err)
+                }
+                probeRoots = roots
+        })
+        return &tls.Config{RootCAs: probeRoots}
+}
+
+// probeWithRetries probes a server, retrying a failed probe up to the given number of times with the delay between them
+// doubling, so a single lost or timed-out request on a congested link doesn't decide the verdict. Failures that
+// retrying can't change, such as certificate problems and redirects, are not retried.
+func probeWithRetries(ctx context.Context, client *mautrix.Client, server string, retries int) (string, probeResult) {
+        delay := time.Duration(config().ProbeRetryDelay) * time.Second
+        if delay <= 0 {
+                delay = time.Second
+        }
+        for attempt := 0; ; attempt++ {
+                release, err := probeLimiter.acquire(ctx)
+                if err != nil {
+                        return "Failed (Check aborted)", probeResult{}
+                }
+                status, result := probeServer(ctx, client, server)
+                release()
+                if !strings.HasPrefix(status, "Failed") || result.CertProblem != "" || result.Redirect != "" ||
+                        isOnion(server) && config().TorProxy == "" || attempt >= retries || ctx.Err() != nil {
+                        if attempt > 0 && config().Verbose {
+                                fmt.Printf("%s after %d retries: %s\n", server, attempt, status)
+                        }
+                        return status, result
+                }
+                if config().Verbose {
+                        fmt.Printf("Probe of %s failed (%s), retrying in %s\n", server, status, delay)
+                }
+                if sleepContext(ctx, delay) != nil {
+                        return status, result
+                }
+                delay *= 2
+        }
+}
+
+// versionPath is the federation endpoint probed by default, which also tells the server's software
+const versionPath = "/_matrix/federation/v1/version"
+
+// probePath returns the path probed on the given server: its override, the global probe_path, or the version endpoint
+func probePath(server string) string {
+        path := serverOverride(server).ProbePath
+        if path == "" {
+                path = config().ProbePath
+        }
+        if path == "" {
+                return versionPath
+        }
+        if !strings.HasPrefix(path, "/") {
+                path = "/" + path
+        }
+        return path
+}
+
+// probeTimeout returns the timeout for probing the given server; Tor circuits need more patience
+func probeTimeout(server string) time.Duration {
+        if timeout := serverOverride(server).Timeout; timeout > 0 {
+                return time.Duration(timeout) * time.Second
+        }
+        if isOnion(server) {
+                if config().TorTimeout > 0 {
+                        return time.Duration(config().TorTimeout) * time.Second
+                }
+                return 30 * time.Second
+        }
+        return 5 * time.Second
+}
+
+// degradedLatency returns the latency above which a server that answers is reported as degraded, or 0 for none.
+// Onion services, slow by nature of Tor, only have the one configured for them.
+func degradedLatency(server string) time.Duration {
+        if latency := serverOverride(server).DegradedLatency; latency > 0 {
+                return time.Duration(latency) * time.Millisecond
+        }
+        if isOnion(server) {
+                return 0
+        }
+        return time.Duration(config().DegradedLatency) * time.Millisecond
+}
+
+// latencyNote describes the latest latency of a server that answers, for its report line, e.g. " (latency 120ms)".
+// Degraded servers already name theirs in their status.
+func latencyNote(server, status string) string {
+        if class := statusClass(status); class != "OK" && class != "Warning" || strings.Contains(status, "Degraded") {
+                return ""
+        }
+        result, ok := latestResult(server)
+        if !ok || !result.Online || result.Latency <= 0 {
+                return ""
+        }
+        return fmt.Sprintf(" (latency %s)", result.Latency.Round(time.Millisecond))
+}
+
+// isOnion reports whether a server name (optionally with port) is a Tor onion service
+func isOnion(server string) bool {
+        host := server
+        if h, _, err := net.SplitHostPort(server); err == nil {
+                host = h
+        }
+        return strings.HasSuffix(strings.ToLower(host), ".onion")
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "net"
+        "sort"
+        "strings"
+        "sync"
+        "time"
+
+        "maunium.net/go/mautrix"
+)
+
+// ProviderGroupingConfig groups failures of servers at the same hosting provider into one alert
+type ProviderGroupingConfig struct {
+        Enabled    bool                `yaml:"enabled"`
+        MinServers int                 `yaml:"min_servers"` // Failing servers at one provider that make a provider outage, defaults to 3
+        Providers  map[string][]string `yaml:"providers"`   // Provider names with their networks (CIDR), checked before the ASN lookup
+}
+
+// serverProvider is the hosting provider of a server's federation host
+type serverProvider struct {
+        Name     string // e.g. "HETZNER-AS (AS24940)", "" if unknown
+        Resolved time.Time
+}
+
+const (
+        providerCacheTime        = 24 * time.Hour
+        providerFailureCacheTime = time.Hour // Servers whose provider couldn't be found are looked up again sooner
+)
+
+var (
+        providersMu     sync.Mutex
+        providers       = make(map[string]serverProvider)
+        providerOutages = make(map[string]bool) // Providers currently reported as having an outage
+)
+
+// minProviderServers returns how many failing servers at one provider make a provider outage
+func minProviderServers() int {
+        if config().ProviderGrouping.MinServers > 0 {
+                return config().ProviderGrouping.MinServers
+        }
+        return 3
+}
+
+// providerOf returns the hosting provider of a server's federation host, from the configured networks or the
+// origin AS of its address, or "" if it can't be found
+func providerOf(ctx context.Context, server string) string {
+        providersMu.Lock()
+        cached, ok := providers[server]
+        providersMu.Unlock()
+        if ok && (time.Since(cached.Resolved) < providerFailureCacheTime || cached.Name != "" && time.Since(cached.Resolved) < providerCacheTime) {
+                return cached.Name
+        }
+
+        name, err := lookupProvider(ctx, server)
+        if err != nil && config().Verbose {
+                fmt.Printf("Failed to find the hosting provider of %s: %v\n", server, err)
+        }
+        if ctx.Err() != nil {
+                return name
+        }
+        providersMu.Lock()
+        providers[server] = serverProvider{Name: name, Resolved: time.Now()}
+        providersMu.Unlock()
+        return name
+}
+
+// lookupProvider resolves a server's federation host to an address and finds the network it belongs to
+func lookupProvider(ctx context.Context, server string) (string, error) {
+        candidates, err := resolveMatrixServer(ctx, server)
+        if err != nil || len(candidates) == 0 {
+                return "", fmt.Errorf("failed to resolve %s: %v", server, err)
+        }
+        host, _, err := net.SplitHostPort(candidates[0])
+        if err != nil {
+                host = candidates[0]
+        }
+
+        ip := net.ParseIP(host)
+        if ip == nil {
+                release, err := dnsLimiter.acquire(ctx)
+                if err != nil {
+                        return "", err
+                }
+                addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+                release()
+                if err != nil || len(addrs) == 0 {
+                        return "", fmt.Errorf("failed to look up %s: %v", host, err)
+                }
+                ip = addrs[0].IP
+        }
+
+        for name, networks := range config().ProviderGrouping.Providers {
+                for _, network := range networks {
+                        if _, ipNet, err := net.ParseCIDR(network); err == nil && ipNet.Contains(ip) {
+                                return name, nil
+                        }
+                }
+        }
+        return lookupASN(ctx, ip)
+}
+
+// lookupASN finds the origin AS of an address and its name through Team Cymru's DNS interface,
+// e.g. "HETZNER-AS (AS24940)"
+func lookupASN(ctx context.Context, ip net.IP) (string, error) {
+        var query string
+        if v4 := ip.To4(); v4 != nil {
+                query = fmt.Sprintf("%d.%d.%d.%d.origin.asn.cymru.com", v4[3], v4[2], v4[1], v4[0])
+        } else {
+                const hexDigits = "0123456789abcdef"
+                nibbles := make([]string, 0, 32)
+                for i := len(ip) - 1; i >= 0; i-- {
+                        nibbles = append(nibbles, string(hexDigits[ip[i]&0xf]), string(hexDigits[ip[i]>>4]))
+                }
+                query = strings.Join(nibbles, ".") + ".origin6.asn.cymru.com"
+        }
+
+        // "24940 | 88.99.0.0/16 | DE | ripencc | 2008-07-25", with several ASNs separated by spaces for anycast
+        origin, err := lookupTXTField(ctx, query, 0)
+        if err != nil {
+                return "", err
+        }
+        asn := strings.Fields(origin)[0]
+
+        // "24940 | DE | ripencc | 2008-07-25 | HETZNER-AS, DE"
+        name, err := lookupTXTField(ctx, "AS"+asn+".asn.cymru.com", 4)
+        if err != nil {
+                return "AS" + asn, nil
+        }
+        if i := strings.LastIndex(name, ","); i > 0 {
+                name = name[:i] // Country code
+        }
+        return fmt.Sprintf("%s (AS%s)", name, asn), nil
+}
+
+// lookupTXTField returns a field of the first |-separated TXT record of a name
+func lookupTXTField(ctx context.Context, name string, field int) (string, error) {
+        release, err := dnsLimiter.acquire(ctx)
+        if err != nil {
+                return "", err
+        }
+        records, err := net.DefaultResolver.LookupTXT(ctx, name)
+        release()
+        if err != nil {
+                return "", err
+        }
+        if len(records) == 0 {
+                return "", fmt.Errorf("no TXT record for %s", name)
+        }
+        fields := strings.Split(records[0], "|")
+        if len(fields) <= field || strings.TrimSpace(fields[field]) == "" {
+                return "", fmt.Errorf("unexpected TXT record for %s: %q", name, records[0])
+        }
+        return strings.TrimSpace(fields[field]), nil
+}
+
+// failingByProvider groups the servers currently failing by hosting provider, keeping providers with at least
+// min_servers of them. Servers not checked in the last two cycles, e.g. because they left every room, don't count.
+func failingByProvider(ctx context.Context) map[string][]string {
+        var failing []string
+        recent := time.Now().Add(-2 * time.Duration(config().Interval) * time.Second)
+        resultsMu.RLock()
+        for server, result := range latestResults {
+                if strings.HasPrefix(result.Status, "Failed") && result.CheckedAt.After(recent) {
+                        failing = append(failing, server)
+                }
+        }
+        resultsMu.RUnlock()
+
+        groups := make(map[string][]string)
+        for _, server := range failing {
+                if isIgnored(server) || isSilenced(server) {
+                        continue
+                }
+                if provider := providerOf(ctx, server); provider != "" {
+                        groups[provider] = append(groups[provider], server)
+                }
+        }
+        for provider, servers := range groups {
+                if len(servers) < minProviderServers() {
+                        delete(groups, provider)
+                        continue
+                }
+                sort.Strings(servers)
+        }
+        return groups
+}
+
+// groupProviderFailures takes the failed servers of a report whose hosting provider has an outage out of it, as they
+// are reported once as a provider outage instead. It returns the remaining rows and a note about those left out.
+func groupProviderFailures(ctx context.Context, client *mautrix.Client, rows []reportRow) ([]reportRow, string) {
+        if !config().ProviderGrouping.Enabled || len(rows) == 0 {
+                return rows, ""
+        }
+        groups := failingByProvider(ctx)
+        reportProviderOutages(ctx, client, groups)
+        if len(groups) == 0 {
+                return rows, ""
+        }
+
+        grouped := make(map[string]string)
+        for provider, servers := range groups {
+                for _, server := range servers {
+                        grouped[server] = provider
+                }
+        }
+        var kept []reportRow
+        left := make(map[string]int)
+        for _, row := range rows {
+                if provider, ok := grouped[row.Server]; ok {
+                        left[provider]++
+                        continue
+                }
+                kept = append(kept, row)
+        }
+        var notes []string
+        for provider, count := range left {
+                notes = append(notes, fmt.Sprintf("%d at %s", count, provider))
+        }
+        if len(notes) == 0 {
+                return kept, ""
+        }
+        sort.Strings(notes)
+        return kept, fmt.Sprintf("Also failing, reported as provider outages: %s", strings.Join(notes, ", "))
+}
+
+// checkProviderOutages reports provider outages starting or ending, once per cycle
+func checkProviderOutages(ctx context.Context, client *mautrix.Client) {
+        if !config().ProviderGrouping.Enabled {
+                return
+        }
+        reportProviderOutages(ctx, client, failingByProvider(ctx))
+}
+
+// reportProviderOutages posts providers whose servers started failing together, with the servers affected,
+// and providers whose outage is over. Each outage is reported once, whichever room noticed it first.
+func reportProviderOutages(ctx context.Context, client *mautrix.Client, groups map[string][]string) {
+        var started []string
+        var ended []string
+        providersMu.Lock()
+        for provider, servers := range groups {
+                if !providerOutages[provider] {
+                        providerOutages[provider] = true
+                        started = append(started, fmt.Sprintf("Possible %s outage affecting %d servers:\n%s",
+                                provider, len(servers), reportLines(servers)))
+                }
+        }
+        for provider := range providerOutages {
+                if _, ok := groups[provider]; !ok {
+                        delete(providerOutages, provider)
+                        ended = append(ended, fmt.Sprintf("The possible %s outage is over, fewer than %d of its servers are failing", provider, minProviderServers()))
+                }
+        }
+        providersMu.Unlock()
+
+        sort.Strings(started)
+        for _, message := range started {
+                sendReport(ctx, client, severityCritical, message)
+        }
+        sort.Strings(ended)
+        for _, message := range ended {
+                sendReport(ctx, client, severityInfo, message)
+        }
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+        "encoding/json"
+        "flag"
+        "fmt"
+        "io"
+        "os"
+        "strings"
+        "text/tabwriter"
+        "time"
+)
+
+// queriedCheck is a stored check result printed by the query command
+type queriedCheck struct {
+        Time      time.Time `json:"time"`
+        Server    string    `json:"server"`
+        Room      string    `json:"room"`
+        Status    string    `json:"status"`
+        Reason    string    `json:"reason,omitempty"`
+        LatencyMs int64     `json:"latency_ms"`
+}
+
+// runQuery prints stored check results matching the filters, newest first, e.g.
+// "matrix-health query --server example.org --since 7d --status failed"; it returns the exit code
+func runQuery(args []string) int {
+        flags := flag.NewFlagSet("query", flag.ContinueOnError)
+        server := flags.String("server", "", "only this server")
+        room := flags.String("room", "", "only checks made for this room ID")
+        since := flags.String("since", "24h", "only checks newer than this age (e.g. 45m, 12h, 7d, 2w) or date (2006-01-02 or RFC 3339)")
+        status := flags.String("status", "", "only this status: ok, failed, warning or maintenance")
+        format := flags.String("format", "table", "output format (table or json)")
+        limit := flags.Int("limit", 100, "print at most this many results (0 = all)")
+        output := flags.String("o", "", "output file (defaults to stdout)")
+        if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+                return 2
+        }
+        if *format != "table" && *format != "json" {
+                fmt.Printf("unsupported format: %s\n", *format)
+                return 2
+        }
+        from, err := parseSince(*since)
+        if err != nil {
+                fmt.Println(err)
+                return 2
+        }
+
+        query := `SELECT ts, server, room, status, reason, latency_ms FROM checks WHERE ts >= ?`
+        params := []interface{}{from.Unix()}
+        if *server != "" {
+                query += ` AND server = ?`
+                params = append(params, strings.ToLower(*server))
+        }
+        if *room != "" {
+                query += ` AND room = ?`
+                params = append(params, *room)
+        }
+        if *status != "" {
+                class := ""
+                for _, known := range []string{"OK", "Failed", "Warning", "Maintenance"} {
+                        if strings.EqualFold(known, *status) {
+                                class = known
+                        }
+                }
+                if class == "" {
+                        fmt.Printf("unknown status %q, expected ok, failed, warning or maintenance\n", *status)
+                        return 2
+                }
+                query += ` AND status = ?`
+                params = append(params, class)
+        }
+        query += ` ORDER BY ts DESC`
+        if *limit > 0 {
+                query += fmt.Sprintf(` LIMIT %d`, *limit)
+        }
+
+        if err := loadValidConfig(); err != nil {
+                fmt.Println("Configuration error:", err)
+                return 2
+        }
+        if err := openStore(); err != nil {
+                fmt.Println("Failed to open the database:", err)
+                return 1
+        }
+        defer db.Close()
+
+        rows, err := db.Query(query, params...)
+        if err != nil {
+                fmt.Println("Query failed:", err)
+                return 1
+        }
+        defer rows.Close()
+        results := []queriedCheck{}
+        for rows.Next() {
+                var check queriedCheck
+                var ts int64
+                if err := rows.Scan(&ts, &check.Server, &check.Room, &check.Status, &check.Reason, &check.LatencyMs); err != nil {
+                        fmt.Println("Query failed:", err)
+                        return 1
+                }
+                check.Time = time.Unix(ts, 0).UTC()
+                results = append(results, check)
+        }
+        if err := rows.Err(); err != nil {
+                fmt.Println("Query failed:", err)
+                return 1
+        }
+
+        var out io.Writer = os.Stdout
+        if *output != "" {
+                file, err := os.Create(*output)
+                if err != nil {
+                        fmt.Println("Failed to write the results:", err)
+                        return 1
+                }
+                defer file.Close()
+                out = file
+        }
+        if *format == "json" {
+                encoder := json.NewEncoder(out)
+                encoder.SetIndent("", "  ")
+                if err := encoder.Encode(results); err != nil {
+                        fmt.Println("Failed to write the results:", err)
+                        return 1
+                }
+                return 0
+        }
+        table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+        fmt.Fprintln(table, "TIME\tSERVER\tSTATUS\tREASON\tLATENCY\tROOM")
+        for _, check := range results {
+                fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%dms\t%s\n", check.Time.Format("2006-01-02 15:04:05"), check.Server, check.Status,
+                        check.Reason, check.LatencyMs, check.Room)
+        }
+        table.Flush()
+        fmt.Printf("%d results since %s\n", len(results), from.UTC().Format(time.RFC3339))
+        return 0
+}
+
+// parseSince parses the start of a query: an age before now, e.g. 7d, or a date or time
+func parseSince(value string) (time.Time, error) {
+        if age, err := parseAge(value); err == nil {
+                return time.Now().Add(-age), nil
+        }
+        for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+                if t, err := time.Parse(layout, value); err == nil {
+                        return t, nil
+                }
+        }
+        return time.Time{}, fmt.Errorf("invalid --since %q, expected an age like 12h or 7d, or a date like 2006-01-02", value)
+}
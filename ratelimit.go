@@ -0,0 +1,118 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "sync"
+        "time"
+
+        "maunium.net/go/mautrix"
+)
+
+// RateLimitConfig controls how the bot copes with its own account being rate limited by its homeserver
+type RateLimitConfig struct {
+        SlowSend   int `yaml:"slow_send"`   // Milliseconds a message send may take before it counts as held back by the homeserver, defaults to 10000
+        MaxStretch int `yaml:"max_stretch"` // Largest factor the check interval is stretched by while the account is limited, defaults to 4
+        MaxRetries int `yaml:"max_retries"` // Retries of a rate limited request after waiting as asked, defaults to 3
+}
+
+// maxRateLimitWait bounds how long a single rate limited request waits before its retry
+const maxRateLimitWait = time.Minute
+
+var (
+        accountMu       sync.Mutex
+        accountLimited  int // Rate limited requests of the account in the current cycle
+        accountSlow     int // Sends of the current cycle slower than slow_send
+        intervalStretch = 1 // Factor the check interval is stretched by
+)
+
+// slowSendThreshold returns how long a message send may take before it counts as held back
+func slowSendThreshold() time.Duration {
+        if config().RateLimits.SlowSend > 0 {
+                return time.Duration(config().RateLimits.SlowSend) * time.Millisecond
+        }
+        return 10 * time.Second
+}
+
+// maxIntervalStretch returns the largest factor the check interval is stretched by
+func maxIntervalStretch() int {
+        if config().RateLimits.MaxStretch > 0 {
+                return config().RateLimits.MaxStretch
+        }
+        return 4
+}
+
+// accountCall runs a request of the bot's account against its homeserver, waiting as asked and retrying when it is
+// rate limited. Rate limited requests and slow sends are counted towards stretching the check interval.
+func accountCall(ctx context.Context, send bool, call func() error) error {
+        maxRetries := config().RateLimits.MaxRetries
+        if maxRetries <= 0 {
+                maxRetries = 3
+        }
+        for attempt := 0; ; attempt++ {
+                start := time.Now()
+                err := call()
+                retryAfter, limited := rateLimited(err)
+
+                accountMu.Lock()
+                if limited {
+                        accountLimited++
+                } else if send && err == nil && time.Since(start) > slowSendThreshold() {
+                        accountSlow++
+                }
+                accountMu.Unlock()
+
+                if !limited || attempt >= maxRetries {
+                        return err
+                }
+                if retryAfter > maxRateLimitWait {
+                        retryAfter = maxRateLimitWait
+                }
+                fmt.Printf("Rate limited by the homeserver, retrying in %s\n", retryAfter)
+                if err := sleepContext(ctx, retryAfter); err != nil {
+                        return err
+                }
+        }
+}
+
+// cycleInterval returns the time between check cycles, stretched while the account is rate limited
+func cycleInterval() time.Duration {
+        accountMu.Lock()
+        defer accountMu.Unlock()
+        return time.Duration(config().Interval*intervalStretch) * time.Second
+}
+
+// adjustPacing ends a cycle's count of rate limited requests and slow sends: a cycle with any doubles the check
+// interval, up to max_stretch times the configured one, and a cycle without halves it again. Stretching and
+// returning to the configured interval are reported, so gaps between reports don't go unexplained.
+func adjustPacing(ctx context.Context, client *mautrix.Client, ticker *time.Ticker) {
+        accountMu.Lock()
+        limited, slow, previous := accountLimited, accountSlow, intervalStretch
+        accountLimited, accountSlow = 0, 0
+        if limited > 0 || slow > 0 {
+                intervalStretch *= 2
+                if max := maxIntervalStretch(); intervalStretch > max {
+                        intervalStretch = max
+                }
+        } else if intervalStretch > 1 {
+                intervalStretch /= 2
+        }
+        stretch := intervalStretch
+        accountMu.Unlock()
+
+        if stretch == previous {
+                return
+        }
+        interval := time.Duration(config().Interval*stretch) * time.Second
+        ticker.Reset(interval)
+        switch {
+        case stretch > previous && previous == 1:
+                sendReport(ctx, client, severityWarning, fmt.Sprintf("The homeserver is rate limiting %s (%d rate limited requests, %d sends slower than %s in the last cycle). "+
+                        "Checking every %s instead of every %d seconds until it stops; reports may come late.",
+                        config().Username, limited, slow, slowSendThreshold(), interval, config().Interval))
+        case stretch == 1:
+                sendReport(ctx, client, severityInfo, fmt.Sprintf("The homeserver no longer rate limits %s, checking every %d seconds again", config().Username, config().Interval))
+        default:
+                fmt.Printf("Account still rate limited (%d rate limited requests, %d slow sends), checking every %s\n", limited, slow, interval)
+        }
+}
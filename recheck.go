@@ -0,0 +1,122 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "strings"
+        "sync"
+        "time"
+
+        "maunium.net/go/mautrix"
+)
+
+// RecheckConfig checks servers confirmed down less often: the time between their checks doubles after each failed
+// one, up to a maximum, and a down server that answers again must keep answering before it counts as recovered
+type RecheckConfig struct {
+        Enabled        bool `yaml:"enabled"`
+        MaxInterval    int  `yaml:"max_interval"`    // Longest time in seconds between checks of a down server, defaults to an hour
+        RecoveryChecks int  `yaml:"recovery_checks"` // Probes in a row a down server must answer to count as recovered, defaults to 3
+}
+
+// downBackoff is when a server confirmed down is checked next
+type downBackoff struct {
+        delay time.Duration // Time between its last check and the next one, doubled after each failure
+        next  time.Time
+}
+
+var (
+        backoffMu   sync.Mutex
+        downServers = make(map[string]downBackoff)
+)
+
+// maxRecheckInterval returns the longest time between checks of a down server
+func maxRecheckInterval() time.Duration {
+        if config().Recheck.MaxInterval > 0 {
+                return time.Duration(config().Recheck.MaxInterval) * time.Second
+        }
+        return time.Hour
+}
+
+// recoveryChecks returns how many probes in a row a down server must answer to count as recovered
+func recoveryChecks() int {
+        if config().Recheck.RecoveryChecks > 0 {
+                return config().Recheck.RecoveryChecks
+        }
+        return 3
+}
+
+// recordBackoff puts a server reported as failed on a longer time until its next check than the last one, starting
+// at two check intervals; any other status puts it back on every cycle
+func recordBackoff(server, status string) {
+        backoffMu.Lock()
+        defer backoffMu.Unlock()
+        if !config().Recheck.Enabled || !strings.HasPrefix(status, "Failed") {
+                delete(downServers, server)
+                return
+        }
+        delay := 2 * time.Duration(config().Interval) * time.Second
+        if previous, ok := downServers[server]; ok {
+                delay = previous.delay * 2
+        }
+        if max := maxRecheckInterval(); delay > max {
+                delay = max
+        }
+        downServers[server] = downBackoff{delay: delay, next: time.Now().Add(delay)}
+}
+
+// isBackedOff reports whether a server is down and checked less often
+func isBackedOff(server string) bool {
+        backoffMu.Lock()
+        defer backoffMu.Unlock()
+        _, ok := downServers[server]
+        return ok
+}
+
+// recheckServers returns the servers due for a probe: all of them, except down servers whose next check hasn't
+// come. A check due within half an interval counts as due, so cycles running a little early don't skip it.
+func recheckServers(servers []string) []string {
+        if !config().Recheck.Enabled {
+                return servers
+        }
+        soon := time.Now().Add(time.Duration(config().Interval) * time.Second / 2)
+
+        backoffMu.Lock()
+        defer backoffMu.Unlock()
+        due := make([]string, 0, len(servers))
+        for _, server := range servers {
+                if backoff, ok := downServers[server]; ok && backoff.next.After(soon) {
+                        if _, ok := latestResult(server); ok {
+                                continue
+                        }
+                }
+                due = append(due, server)
+        }
+        return due
+}
+
+// confirmRecovery probes a down server that answered again until it has answered recoveryChecks probes in a row,
+// returning the first failure if it doesn't, so a server flapping on its way back isn't reported as recovered
+func confirmRecovery(ctx context.Context, client *mautrix.Client, server, status string, result probeResult) (string, probeResult) {
+        if !config().Recheck.Enabled || strings.HasPrefix(status, "Failed") || !isBackedOff(server) {
+                return status, result
+        }
+        delay := time.Duration(config().ProbeRetryDelay) * time.Second
+        if delay <= 0 {
+                delay = time.Second
+        }
+        for answered := 1; answered < recoveryChecks(); answered++ {
+                if sleepContext(ctx, delay) != nil {
+                        return status, result
+                }
+                recheck, recheckResult := probeWithRetries(ctx, client, server, 0)
+                if ctx.Err() != nil {
+                        return status, result
+                }
+                if strings.HasPrefix(recheck, "Failed") {
+                        fmt.Printf("%s answered %d probes after being down, then failed again: %s\n", server, answered, recheck)
+                        return recheck, recheckResult
+                }
+                status, result = recheck, recheckResult
+        }
+        return status, result
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+        "database/sql"
+        "fmt"
+        "net/http"
+        "strings"
+        "time"
+
+        "maunium.net/go/mautrix/id"
+)
+
+// registryServer is everything the registry knows about a server observed since the monitor started keeping it.
+// Unlike the check history, the registry is never pruned.
+type registryServer struct {
+        Server       string            `json:"server"`
+        FirstSeen    time.Time         `json:"first_seen"`
+        LastSeen     time.Time         `json:"last_seen"`
+        Checks       int64             `json:"checks"`       // Checks outside maintenance windows
+        Successes    int64             `json:"successes"`    // Of those, checks the server answered
+        Availability float64           `json:"availability"` // Lifetime availability in percent
+        Rooms        []registryRoom    `json:"rooms,omitempty"`
+        Versions     []registryVersion `json:"versions,omitempty"`
+}
+
+// registryRoom is a room a server was seen in
+type registryRoom struct {
+        Room      string    `json:"room"`
+        FirstSeen time.Time `json:"first_seen"`
+        LastSeen  time.Time `json:"last_seen"`
+}
+
+// registryVersion is a server software version a server reported, and when
+type registryVersion struct {
+        Software  string    `json:"software"`
+        Version   string    `json:"version"`
+        FirstSeen time.Time `json:"first_seen"`
+        LastSeen  time.Time `json:"last_seen"`
+}
+
+// recordRegistry notes a checked server in the registry, with the version it reported. Checks inside maintenance
+// windows don't count towards its availability.
+func recordRegistry(server, status string, probe probeResult) {
+        now := time.Now().Unix()
+        checks, successes := 1, 0
+        if strings.HasPrefix(status, "Maintenance") {
+                checks = 0
+        } else if probe.Online {
+                successes = 1
+        }
+        if _, err := db.Exec(`INSERT INTO servers (server, first_seen, last_seen, checks, successes) VALUES (?, ?, ?, ?, ?)
+                ON CONFLICT (server) DO UPDATE SET last_seen = excluded.last_seen, checks = checks + excluded.checks,
+                successes = successes + excluded.successes`, server, now, now, checks, successes); err != nil {
+                fmt.Printf("Failed to record %s in the server registry: %v\n", server, err)
+                return
+        }
+        if probe.Version != "" {
+                if _, err := db.Exec(`INSERT INTO server_versions (server, software, version, first_seen, last_seen) VALUES (?, ?, ?, ?, ?)
+                        ON CONFLICT (server, software, version) DO UPDATE SET last_seen = excluded.last_seen`,
+                        server, probe.Software, probe.Version, now, now); err != nil {
+                        fmt.Printf("Failed to record the version of %s in the server registry: %v\n", server, err)
+                }
+        }
+}
+
+// recordRegistryRooms notes in the registry that servers were seen in a room. Every room's servers are recorded,
+// although a server in several rooms is only checked for one of them each cycle.
+func recordRegistryRooms(room string, servers []string) {
+        tx, err := db.Begin()
+        if err != nil {
+                fmt.Println("Failed to record room servers in the server registry:", err)
+                return
+        }
+        defer tx.Rollback()
+        now := time.Now().Unix()
+        for _, server := range servers {
+                if isIgnored(server) {
+                        continue
+                }
+                if _, err := tx.Exec(`INSERT INTO server_rooms (server, room, first_seen, last_seen) VALUES (?, ?, ?, ?)
+                        ON CONFLICT (server, room) DO UPDATE SET last_seen = excluded.last_seen`, server, room, now, now); err != nil {
+                        fmt.Printf("Failed to record the servers of %s in the server registry: %v\n", room, err)
+                        return
+                }
+        }
+        if err := tx.Commit(); err != nil {
+                fmt.Printf("Failed to record the servers of %s in the server registry: %v\n", room, err)
+        }
+}
+
+// lookupRegistry returns a server's registry entry with its rooms (those of the tenant, if any) and versions,
+// or nil if it was never seen
+func lookupRegistry(server, tenant string) (*registryServer, error) {
+        entry := registryServer{Server: server}
+        var firstSeen, lastSeen int64
+        err := db.QueryRow(`SELECT first_seen, last_seen, checks, successes FROM servers WHERE server = ?`, server).
+                Scan(&firstSeen, &lastSeen, &entry.Checks, &entry.Successes)
+        if err != nil {
+                if err == sql.ErrNoRows {
+                        return nil, nil
+                }
+                return nil, err
+        }
+        entry.FirstSeen, entry.LastSeen = time.Unix(firstSeen, 0), time.Unix(lastSeen, 0)
+        if entry.Checks > 0 {
+                entry.Availability = 100 * float64(entry.Successes) / float64(entry.Checks)
+        }
+
+        rows, err := db.Query(`SELECT room, first_seen, last_seen FROM server_rooms WHERE server = ? ORDER BY last_seen DESC`, server)
+        if err != nil {
+                return nil, err
+        }
+        for rows.Next() {
+                var room registryRoom
+                if err := rows.Scan(&room.Room, &firstSeen, &lastSeen); err != nil {
+                        rows.Close()
+                        return nil, err
+                }
+                if !inTenant(id.RoomID(room.Room), tenant) {
+                        continue
+                }
+                room.FirstSeen, room.LastSeen = time.Unix(firstSeen, 0), time.Unix(lastSeen, 0)
+                entry.Rooms = append(entry.Rooms, room)
+        }
+        rows.Close()
+
+        rows, err = db.Query(`SELECT software, version, first_seen, last_seen FROM server_versions WHERE server = ? ORDER BY first_seen`, server)
+        if err != nil {
+                return nil, err
+        }
+        defer rows.Close()
+        for rows.Next() {
+                var version registryVersion
+                if err := rows.Scan(&version.Software, &version.Version, &firstSeen, &lastSeen); err != nil {
+                        return nil, err
+                }
+                version.FirstSeen, version.LastSeen = time.Unix(firstSeen, 0), time.Unix(lastSeen, 0)
+                entry.Versions = append(entry.Versions, version)
+        }
+        return &entry, rows.Err()
+}
+
+// registryServers lists the registry's servers (those seen in the tenant's rooms, if any), most recently seen first
+func registryServers(tenant string) ([]registryServer, error) {
+        // Read before the servers, as the store has a single connection
+        var allowed map[string]bool
+        if tenant != "" {
+                allowed = tenantRegistryServers(tenant)
+        }
+
+        rows, err := db.Query(`SELECT server, first_seen, last_seen, checks, successes FROM servers ORDER BY last_seen DESC, server`)
+        if err != nil {
+                return nil, err
+        }
+        defer rows.Close()
+        servers := []registryServer{}
+        for rows.Next() {
+                var entry registryServer
+                var firstSeen, lastSeen int64
+                if err := rows.Scan(&entry.Server, &firstSeen, &lastSeen, &entry.Checks, &entry.Successes); err != nil {
+                        return nil, err
+                }
+                if allowed != nil && !allowed[entry.Server] {
+                        continue
+                }
+                entry.FirstSeen, entry.LastSeen = time.Unix(firstSeen, 0), time.Unix(lastSeen, 0)
+                if entry.Checks > 0 {
+                        entry.Availability = 100 * float64(entry.Successes) / float64(entry.Checks)
+                }
+                servers = append(servers, entry)
+        }
+        return servers, rows.Err()
+}
+
+// tenantRegistryServers returns the servers ever seen in a tenant's rooms
+func tenantRegistryServers(tenant string) map[string]bool {
+        servers := make(map[string]bool)
+        for _, room := range config().Tenants[tenant].Rooms {
+                rows, err := db.Query(`SELECT server FROM server_rooms WHERE room = ?`, room)
+                if err != nil {
+                        fmt.Println("Failed to read the server registry:", err)
+                        continue
+                }
+                for rows.Next() {
+                        var server string
+                        if rows.Scan(&server) == nil {
+                                servers[server] = true
+                        }
+                }
+                rows.Close()
+        }
+        return servers
+}
+
+// commandServer describes what the registry knows about a server: when it was first and last seen, its lifetime
+// availability, the rooms it was seen in and the versions it ran, e.g. "!server example.org"
+func commandServer(args []string, tenant string) string {
+        if len(args) != 1 {
+                return "Usage: !server <server>"
+        }
+        server := strings.ToLower(args[0])
+        if tenant != "" && !tenantRegistryServers(tenant)[server] {
+                return fmt.Sprintf("%s was never seen in the rooms of %s", server, tenant)
+        }
+        entry, err := lookupRegistry(server, tenant)
+        if err != nil {
+                return fmt.Sprintf("Failed to read the server registry: %v", err)
+        }
+        if entry == nil {
+                return fmt.Sprintf("%s was never seen", server)
+        }
+
+        const day = "2006-01-02"
+        lines := []string{
+                fmt.Sprintf("%s: first seen %s, last seen %s", server, entry.FirstSeen.Format(day), entry.LastSeen.Format("2006-01-02 15:04")),
+        }
+        if entry.Checks > 0 {
+                lines = append(lines, fmt.Sprintf("Lifetime availability: %.2f%% over %d checks", entry.Availability, entry.Checks))
+        }
+        if len(entry.Rooms) > 0 {
+                lines = append(lines, fmt.Sprintf("Seen in %d rooms:", len(entry.Rooms)))
+                for _, room := range limitLines(registryRoomLines(entry.Rooms), 10) {
+                        lines = append(lines, room)
+                }
+        }
+        if len(entry.Versions) > 0 {
+                lines = append(lines, "Versions:")
+                for _, version := range entry.Versions {
+                        lines = append(lines, fmt.Sprintf("%s %s - %s to %s", version.Software, version.Version,
+                                version.FirstSeen.Format(day), version.LastSeen.Format(day)))
+                }
+        }
+        return strings.Join(lines, "\n")
+}
+
+// registryRoomLines describes the rooms a server was seen in, one line each
+func registryRoomLines(rooms []registryRoom) []string {
+        lines := make([]string, 0, len(rooms))
+        for _, room := range rooms {
+                lines = append(lines, fmt.Sprintf("%s - %s to %s", room.Room, room.FirstSeen.Format("2006-01-02"), room.LastSeen.Format("2006-01-02")))
+        }
+        return lines
+}
+
+// handleRegistry lists the registry's servers, or returns one server's entry,
+// e.g. GET /api/v1/servers or GET /api/v1/servers/example.org. A tenant only sees servers seen in its rooms.
+func handleRegistry(w http.ResponseWriter, r *http.Request, tenant string) {
+        if r.Method != http.MethodGet {
+                http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+                return
+        }
+        server := strings.ToLower(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/servers"), "/"))
+        if server == "" {
+                servers, err := registryServers(tenant)
+                if err != nil {
+                        http.Error(w, err.Error(), http.StatusInternalServerError)
+                        return
+                }
+                writeJSON(w, servers)
+                return
+        }
+        if strings.Contains(server, "/") || tenant != "" && !tenantRegistryServers(tenant)[server] {
+                http.NotFound(w, r)
+                return
+        }
+        entry, err := lookupRegistry(server, tenant)
+        if err != nil {
+                http.Error(w, err.Error(), http.StatusInternalServerError)
+                return
+        }
+        if entry == nil {
+                http.NotFound(w, r)
+                return
+        }
+        writeJSON(w, entry)
+}
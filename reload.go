@@ -0,0 +1,129 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "net"
+        "os"
+        "os/signal"
+        "sync/atomic"
+        "syscall"
+        "time"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/id"
+)
+
+// configPath is the configuration file the bot was started with, read again on SIGHUP
+var configPath string
+
+// reloadRequested is set by SIGHUP; the reload happens before the next check cycle, between rooms' checks
+var reloadRequested atomic.Bool
+
+// validateConfig checks the settings that would otherwise only fail once they are used
+func validateConfig(c *Config) error {
+        if _, _, err := id.UserID(c.Username).ParseAndValidate(); err != nil {
+                return fmt.Errorf("invalid username: %w", err)
+        }
+        if c.Interval <= 0 {
+                return fmt.Errorf("interval must be a positive number of seconds")
+        }
+        if err := validateReportOrder(c); err != nil {
+                return err
+        }
+        if err := validateReportMode(c); err != nil {
+                return err
+        }
+        if err := validateReportFormat(c); err != nil {
+                return err
+        }
+        if err := validateMsgTypes(c); err != nil {
+                return err
+        }
+        if err := validateServerLists(c); err != nil {
+                return err
+        }
+        if err := validateNotifyFilters(c); err != nil {
+                return err
+        }
+        if err := validateDigest(c); err != nil {
+                return err
+        }
+        if err := validateReportPipeline(c); err != nil {
+                return err
+        }
+        if c.ProbeSourceAddress != "" && net.ParseIP(c.ProbeSourceAddress) == nil {
+                return fmt.Errorf("invalid probe_source_address %q", c.ProbeSourceAddress)
+        }
+        return nil
+}
+
+// watchReloadSignal requests a configuration reload whenever the process receives SIGHUP
+func watchReloadSignal(ctx context.Context) {
+        signals := make(chan os.Signal, 1)
+        signal.Notify(signals, syscall.SIGHUP)
+        go func() {
+                defer signal.Stop(signals)
+                for {
+                        select {
+                        case <-ctx.Done():
+                                return
+                        case <-signals:
+                                fmt.Println("SIGHUP received, reloading the configuration before the next check")
+                                reloadRequested.Store(true)
+                        }
+                }
+        }()
+}
+
+// applyPendingReload reloads the configuration if SIGHUP asked for it. An invalid file, or log rooms the bot can't
+// post to, keep the current configuration. Settings used only at startup (account, database, encryption, listen
+// addresses, list files, chaos testing) keep their current values until a restart.
+func applyPendingReload(ctx context.Context, client *mautrix.Client, ticker *time.Ticker) {
+        if !reloadRequested.Swap(false) {
+                return
+        }
+
+        // The new configuration is loaded, checked and its log rooms resolved on the side, and only then put in effect
+        next := &Config{}
+        err := loadConfig(configPath, next)
+        if err == nil {
+                err = validateConfig(next)
+        }
+        if err == nil {
+                keepStartupSettings(next, config())
+                err = prepareLogRooms(ctx, client, next)
+        }
+        if err != nil {
+                fmt.Println("Failed to reload the configuration, keeping the current one:", err)
+                sendReport(ctx, client, severityWarning, fmt.Sprintf("Failed to reload the configuration, keeping the current one: %v", err))
+                return
+        }
+
+        publishConfig(next)
+        ensureAdmins(ctx, client)
+        initLimits()
+        initProbeBudget()
+        ticker.Reset(cycleInterval())
+        recordAudit("SIGHUP", "reload", configPath, "", "")
+        fmt.Println("Configuration reloaded.")
+        sendReport(ctx, client, severityInfo, fmt.Sprintf("Configuration reloaded from %s", configPath))
+}
+
+// keepStartupSettings carries over the settings that only take effect at startup from the running configuration
+// to the next one
+func keepStartupSettings(next, running *Config) {
+        next.ServerName = running.ServerName
+        next.Username = running.Username
+        next.Password = running.Password
+        next.AccessToken = running.AccessToken
+        next.DeviceID = running.DeviceID
+        next.Database = running.Database
+        next.Encryption = running.Encryption
+        next.API.Listen = running.API.Listen
+        next.MetricsListen = running.MetricsListen
+        next.IgnoreFile = running.IgnoreFile
+        next.StaticServersFile = running.StaticServersFile
+        next.MaintenanceFile = running.MaintenanceFile
+        next.Chaos = running.Chaos
+}
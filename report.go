@@ -0,0 +1,161 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "strings"
+        "time"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/id"
+)
+
+// commandReport checks a room, a server or every monitored room right away and replies with a full report,
+// e.g. "!report", "!report #room:example.org" or "!report example.org". In a tenant's log room
+// only the tenant's rooms and servers can be reported on.
+func commandReport(ctx context.Context, client *mautrix.Client, args []string, tenant string) string {
+        if len(args) > 1 {
+                return "Usage: !report [all|<room>|<server>]"
+        }
+        target := "all"
+        if len(args) == 1 {
+                target = args[0]
+        }
+
+        switch {
+        case target == "all":
+                joinedRooms, err := client.JoinedRooms(ctx)
+                if err != nil {
+                        return fmt.Sprintf("Failed to fetch joined rooms: %v", err)
+                }
+                var reports []string
+                for _, roomID := range joinedRooms.JoinedRooms {
+                        if !isLogRoom(roomID) && inTenant(roomID, tenant) {
+                                reports = append(reports, roomReport(ctx, client, roomID))
+                        }
+                }
+                if len(reports) == 0 {
+                        return "No monitored rooms"
+                }
+                return strings.Join(reports, "\n\n")
+        case strings.HasPrefix(target, "!"), strings.HasPrefix(target, "#"):
+                roomID := id.RoomID(target)
+                if strings.HasPrefix(target, "#") {
+                        resp, err := client.ResolveAlias(ctx, id.RoomAlias(target))
+                        if err != nil {
+                                return fmt.Sprintf("Failed to resolve %s: %v", target, err)
+                        }
+                        roomID = resp.RoomID
+                }
+                if !inTenant(roomID, tenant) {
+                        return fmt.Sprintf("%s is not monitored for %s", target, tenant)
+                }
+                return roomReport(ctx, client, roomID)
+        default:
+                server := strings.ToLower(target)
+                if tenant != "" && !tenantServers(tenant)[server] {
+                        return fmt.Sprintf("%s is not monitored for %s", target, tenant)
+                }
+                return serverReport(ctx, client, server)
+        }
+}
+
+// roomReport checks every server in a room and describes the result along with the room's history
+func roomReport(ctx context.Context, client *mautrix.Client, roomID id.RoomID) string {
+        roomAlias, roomTitle := getRoomDetails(ctx, client, roomID)
+        roomDescription := fmt.Sprintf("%s - %s ( %s )", roomAlias, roomTitle, roomID)
+
+        members, err := joinedMembers(ctx, client, roomID)
+        if err != nil {
+                return fmt.Sprintf("Failed to get joined members for room %s: %v", roomDescription, err)
+        }
+        servers, _ := roomMemberServers(members)
+
+        statuses := checkServers(ctx, client, roomID.String(), servers, nil)
+        sortServers(servers, statuses, memberCounts(members))
+
+        var lines []string
+        counts := make(map[string]int)
+        for _, server := range servers {
+                status, ok := statuses[server]
+                if !ok {
+                        continue
+                }
+                counts[strings.Fields(status)[0]]++
+                lines = append(lines, formatServerLine(server, status))
+        }
+
+        var b strings.Builder
+        fmt.Fprintf(&b, "Report for room %s\n", roomDescription)
+        if version := roomVersion(ctx, client, roomID); version != "" {
+                fmt.Fprintf(&b, "Room version: %s\n", version)
+        }
+        fmt.Fprintf(&b, "Members: %d on %d servers\n", len(members), len(servers))
+        if history := roomHistory(roomID); history != "" {
+                fmt.Fprintf(&b, "History: %s\n", history)
+        }
+        fmt.Fprintf(&b, "Servers: %d OK, %d failed, %d with warnings, %d in maintenance\n",
+                counts["OK"], counts["Failed"], counts["Warning"], counts["Maintenance"])
+        b.WriteString(reportLines(lines))
+        return b.String()
+}
+
+// roomHistory summarizes how a room's size changed over the trend window, from the store
+func roomHistory(roomID id.RoomID) string {
+        window := time.Duration(config().Trends.Window) * time.Hour
+        if window <= 0 {
+                window = 7 * 24 * time.Hour
+        }
+
+        var ts int64
+        var members, servers int
+        err := db.QueryRow(`SELECT ts, members, servers FROM room_stats WHERE room_id = ? AND ts >= ? ORDER BY ts ASC LIMIT 1`,
+                roomID.String(), time.Now().Add(-window).Unix()).Scan(&ts, &members, &servers)
+        if err != nil {
+                return ""
+        }
+        return fmt.Sprintf("%d members on %d servers at %s", members, servers, time.Unix(ts, 0).Format("2006-01-02 15:04"))
+}
+
+// serverReport checks a server and describes it along with what is known about its history
+func serverReport(ctx context.Context, client *mautrix.Client, server string) string {
+        status := checkServer(ctx, client, server)
+
+        var b strings.Builder
+        fmt.Fprintf(&b, "Report for server %s\n", formatServerLine(server, status))
+        if result, ok := latestResult(server); ok {
+                if result.Software != "" {
+                        fmt.Fprintf(&b, "Software: %s %s\n", result.Software, result.Version)
+                }
+                if result.Checks > 0 {
+                        fmt.Fprintf(&b, "Availability: %.1f%% over %d checks since startup\n",
+                                100*float64(result.Successes)/float64(result.Checks), result.Checks)
+                }
+        }
+        for _, window := range []time.Duration{time.Hour, 24 * time.Hour} {
+                if p50, p95, p99, n := latencyPercentiles(server, window); n > 0 {
+                        fmt.Fprintf(&b, "Latency over %s: p50 %s, p95 %s, p99 %s (%d checks)\n", window,
+                                p50.Round(time.Millisecond), p95.Round(time.Millisecond), p99.Round(time.Millisecond), n)
+                }
+        }
+        if tags := serverTags(server); len(tags) > 0 {
+                fmt.Fprintf(&b, "Tags: %s\n", strings.Join(tags, ", "))
+        }
+        if notes := serverNotes(server); len(notes) > 0 {
+                fmt.Fprintf(&b, "Notes: %s\n", strings.Join(notes, "; "))
+        }
+        if runbook := serverOverride(server).Runbook; runbook != "" {
+                fmt.Fprintf(&b, "Runbook: %s\n", runbook)
+        }
+        if windows := len(serverOverride(server).Maintenance); windows > 0 {
+                fmt.Fprintf(&b, "Maintenance windows: %d (currently in maintenance: %t)\n", windows, inMaintenance(server, time.Now()))
+        }
+
+        lagMu.Lock()
+        samples := expireLagSamples(lagSamples[server], time.Now().Add(-lagMaxAge()))
+        lagMu.Unlock()
+        if len(samples) > 0 {
+                fmt.Fprintf(&b, "Federation lag: median %s over %d recent events\n", medianLag(samples).Round(time.Second), len(samples))
+        }
+        return strings.TrimSuffix(b.String(), "\n")
+}
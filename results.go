@@ -0,0 +1,67 @@
+package main
+
+import (
+        "strings"
+        "sync"
+        "time"
+)
+
+// serverResult is the most recent check outcome for a server
+type serverResult struct {
+        Server    string
+        Status    string
+        Online    bool
+        Software  string
+        Version   string
+        Latency   time.Duration
+        CheckedAt time.Time
+
+        Checks    int // Checks counted towards availability since startup
+        Successes int // Checks in which the server answered
+}
+
+var (
+        resultsMu     sync.RWMutex
+        latestResults = make(map[string]serverResult)
+)
+
+// recordResult stores the outcome of a server check as its latest result
+func recordResult(server, status string, probe probeResult) {
+        resultsMu.Lock()
+        defer resultsMu.Unlock()
+        previous := latestResults[server]
+        result := serverResult{
+                Server:    server,
+                Status:    status,
+                Online:    probe.Online,
+                Software:  probe.Software,
+                Version:   probe.Version,
+                Latency:   probe.Latency,
+                CheckedAt: time.Now(),
+                Checks:    previous.Checks,
+                Successes: previous.Successes,
+        }
+
+        // Planned maintenance doesn't count against availability
+        if !strings.HasPrefix(status, "Maintenance") {
+                result.Checks++
+                if probe.Online {
+                        result.Successes++
+                }
+        }
+
+        // Keep the last known software when the server is down
+        if result.Software == "" {
+                result.Software = previous.Software
+                result.Version = previous.Version
+        }
+        latestResults[server] = result
+}
+
+// latestResult returns the most recent check outcome for a server, if it has been checked
+func latestResult(server string) (serverResult, bool) {
+        resultsMu.RLock()
+        defer resultsMu.RUnlock()
+        result, ok := latestResults[server]
+        return result, ok
+}
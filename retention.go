@@ -0,0 +1,195 @@
+package main
+
+import (
+        "context"
+        "errors"
+        "fmt"
+        "sync/atomic"
+        "time"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/event"
+        "maunium.net/go/mautrix/id"
+)
+
+// RetentionConfig controls automatic cleanup of the bot's own messages in the log room
+type RetentionConfig struct {
+        Days        int `yaml:"days"`         // Redact messages older than this many days (0 = keep forever)
+        MaxMessages int `yaml:"max_messages"` // Redact all but the newest this many messages (0 = no limit)
+}
+
+// retentionInterval is how often the log room is cleaned up
+const retentionInterval = time.Hour
+
+// maxRetentionPages bounds how far back a single cleanup pages through the room history
+const maxRetentionPages = 50
+
+// maxRetentionRedactions bounds the redactions per room in a single cleanup; the rest waits for the next run
+const maxRetentionRedactions = 200
+
+var (
+        lastRetentionRun time.Time
+        retentionRunning atomic.Bool
+)
+
+// cleanupLogRoom applies the retention policy to the log room in the background, at most once per retention interval
+func cleanupLogRoom(ctx context.Context, client *mautrix.Client) {
+        if config().Retention.Days <= 0 && config().Retention.MaxMessages <= 0 {
+                return
+        }
+        if time.Since(lastRetentionRun) < retentionInterval || !retentionRunning.CompareAndSwap(false, true) {
+                return
+        }
+        lastRetentionRun = time.Now()
+        go func() {
+                defer retentionRunning.Store(false)
+                runRetention(ctx, client)
+        }()
+}
+
+// runRetention redacts expired messages in every log room
+func runRetention(ctx context.Context, client *mautrix.Client) {
+        var cutoff time.Time
+        if config().Retention.Days > 0 {
+                cutoff = time.Now().AddDate(0, 0, -config().Retention.Days)
+        }
+
+        for _, roomID := range logRooms() {
+                redacted, err := redactOwnMessages(ctx, client, roomID, cutoff, config().Retention.MaxMessages, maxRetentionRedactions)
+                if err != nil {
+                        fmt.Printf("Failed to clean up log room %s: %v\n", roomID, err)
+                }
+                if redacted > 0 {
+                        fmt.Printf("Redacted %d old messages in log room %s\n", redacted, roomID)
+                }
+        }
+}
+
+// redactOwnMessages redacts the bot's messages in a room that are older than cutoff (if set),
+// or that come after the newest keep messages (if keep > 0), at most limit of them (if limit > 0).
+// The messages the bot still refers to, live status messages and alert thread events, and edits of them are kept.
+// It returns the number of redacted messages.
+func redactOwnMessages(ctx context.Context, client *mautrix.Client, roomID id.RoomID, cutoff time.Time, keep, limit int) (int, error) {
+        filter := &mautrix.FilterPart{
+                Senders: []id.UserID{client.UserID},
+                Types:   []event.Type{event.EventMessage},
+        }
+
+        referenced := referencedEvents(roomID)
+
+        // Page backwards from the newest message, collecting what has to go
+        var expired []id.EventID
+        seen := 0
+        from := ""
+        for page := 0; page < maxRetentionPages; page++ {
+                resp, err := client.Messages(ctx, roomID, from, "", mautrix.DirectionBackward, filter, 100)
+                if err != nil {
+                        return 0, err
+                }
+
+                for _, evt := range resp.Chunk {
+                        if evt.Sender != client.UserID || evt.Unsigned.RedactedBecause != nil || referenced[evt.ID] {
+                                continue
+                        }
+                        _ = evt.Content.ParseRaw(evt.Type)
+                        if content := evt.Content.AsMessage(); content != nil && referenced[content.RelatesTo.GetReplaceID()] {
+                                continue
+                        }
+                        seen++
+                        tooOld := !cutoff.IsZero() && time.UnixMilli(evt.Timestamp).Before(cutoff)
+                        tooMany := keep > 0 && seen > keep
+                        if tooOld || tooMany {
+                                expired = append(expired, evt.ID)
+                        }
+                }
+                if limit > 0 && len(expired) >= limit {
+                        expired = expired[:limit]
+                        break
+                }
+
+                if resp.End == "" || len(resp.Chunk) == 0 {
+                        break
+                }
+                from = resp.End
+        }
+
+        // Redact slowly to stay clear of the homeserver's rate limits, and back off when we hit them anyway
+        redacted := 0
+        for i := 0; i < len(expired); i++ {
+                _, err := client.RedactEvent(ctx, roomID, expired[i], mautrix.ReqRedact{Reason: "Log retention"})
+                if retryAfter, limited := rateLimited(err); limited {
+                        fmt.Printf("Rate limited while redacting, waiting %s\n", retryAfter)
+                        if err := sleepContext(ctx, retryAfter); err != nil {
+                                return redacted, err
+                        }
+                        i--
+                        continue
+                }
+                if err != nil {
+                        return redacted, err
+                }
+                redacted++
+                if err := sleepContext(ctx, 500*time.Millisecond); err != nil {
+                        return redacted, err
+                }
+        }
+        return redacted, nil
+}
+
+// sleepContext waits for the given duration, returning early with the context's error if it is cancelled
+func sleepContext(ctx context.Context, d time.Duration) error {
+        timer := time.NewTimer(d)
+        defer timer.Stop()
+        select {
+        case <-ctx.Done():
+                return ctx.Err()
+        case <-timer.C:
+                return nil
+        }
+}
+
+// rateLimited reports whether err is an M_LIMIT_EXCEEDED error, and how long the homeserver asked us to wait
+func rateLimited(err error) (time.Duration, bool) {
+        var httpErr mautrix.HTTPError
+        if !errors.As(err, &httpErr) || httpErr.RespError == nil || httpErr.RespError.ErrCode != mautrix.MLimitExceeded.ErrCode {
+                return 0, false
+        }
+
+        retryAfter := 5 * time.Second
+        if ms, ok := httpErr.RespError.ExtraData["retry_after_ms"].(float64); ok && ms > 0 {
+                retryAfter = time.Duration(ms) * time.Millisecond
+        }
+        return retryAfter, true
+}
+
+// referencedEvents returns the bot's messages in a log room that it still refers to: the live status messages it
+// edits each cycle, and the root and latest events of its alert threads
+func referencedEvents(roomID id.RoomID) map[id.EventID]bool {
+        referenced := make(map[id.EventID]bool)
+        rows, err := db.Query(`SELECT value FROM bot_state WHERE key LIKE ?`, "status_message % "+roomID.String())
+        if err != nil {
+                fmt.Println("Failed to read the live status messages:", err)
+        } else {
+                for rows.Next() {
+                        var eventID string
+                        if rows.Scan(&eventID) == nil {
+                                referenced[id.EventID(eventID)] = true
+                        }
+                }
+                rows.Close()
+        }
+
+        rows, err = db.Query(`SELECT root_event, last_event FROM alert_threads WHERE log_room = ?`, roomID.String())
+        if err != nil {
+                fmt.Println("Failed to read the alert threads:", err)
+                return referenced
+        }
+        defer rows.Close()
+        for rows.Next() {
+                var root, last string
+                if rows.Scan(&root, &last) == nil {
+                        referenced[id.EventID(root)], referenced[id.EventID(last)] = true, true
+                }
+        }
+        return referenced
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+        "context"
+        "database/sql"
+        "errors"
+        "fmt"
+        "strings"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/event"
+        "maunium.net/go/mautrix/id"
+)
+
+// roomSettings is how a monitored room can be found and joined; empty fields couldn't be read
+type roomSettings struct {
+        JoinRule    string
+        GuestAccess string
+        Visibility  string
+}
+
+// String describes the settings for reports, e.g. "join rule public, guest access forbidden, public in the room directory"
+func (s roomSettings) String() string {
+        var parts []string
+        if s.JoinRule != "" {
+                parts = append(parts, "join rule "+s.JoinRule)
+        }
+        if s.GuestAccess != "" {
+                parts = append(parts, "guest access "+s.GuestAccess)
+        }
+        if s.Visibility != "" {
+                parts = append(parts, s.Visibility+" in the room directory")
+        }
+        if len(parts) == 0 {
+                return "unknown"
+        }
+        return strings.Join(parts, ", ")
+}
+
+// fetchRoomSettings reads a room's join rule, guest access and directory visibility. Missing state events
+// mean the spec's defaults; state the bot can't read is left empty.
+func fetchRoomSettings(ctx context.Context, client *mautrix.Client, roomID id.RoomID) roomSettings {
+        var settings roomSettings
+
+        var joinRules struct {
+                JoinRule string `json:"join_rule"`
+        }
+        err := roomState(ctx, client, roomID, event.StateJoinRules, "", &joinRules)
+        if errors.Is(err, mautrix.MNotFound) {
+                settings.JoinRule = "invite"
+        } else if err == nil {
+                settings.JoinRule = joinRules.JoinRule
+        }
+
+        var guestAccess struct {
+                GuestAccess string `json:"guest_access"`
+        }
+        err = roomState(ctx, client, roomID, event.StateGuestAccess, "", &guestAccess)
+        if errors.Is(err, mautrix.MNotFound) || (err == nil && guestAccess.GuestAccess == "") {
+                settings.GuestAccess = "forbidden"
+        } else if err == nil {
+                settings.GuestAccess = guestAccess.GuestAccess
+        }
+
+        if resp, err := client.GetRoomDirectoryVisibility(ctx, roomID); err == nil {
+                settings.Visibility = string(resp.Visibility)
+        }
+        return settings
+}
+
+// checkRoomSettings reads a monitored room's settings and reports those that changed since they were last seen,
+// keeping them in the store so changes made while the bot was down are noticed too
+func checkRoomSettings(ctx context.Context, client *mautrix.Client, roomID id.RoomID, roomDescription string) roomSettings {
+        settings := fetchRoomSettings(ctx, client, roomID)
+
+        var previous roomSettings
+        err := db.QueryRow(`SELECT join_rule, guest_access, visibility FROM room_settings WHERE room_id = ?`, roomID.String()).
+                Scan(&previous.JoinRule, &previous.GuestAccess, &previous.Visibility)
+        if err != nil && err != sql.ErrNoRows {
+                fmt.Printf("Failed to load the settings of room %s: %v\n", roomID, err)
+                return settings
+        }
+
+        // Settings that couldn't be read keep their last known value
+        if settings.JoinRule == "" {
+                settings.JoinRule = previous.JoinRule
+        }
+        if settings.GuestAccess == "" {
+                settings.GuestAccess = previous.GuestAccess
+        }
+        if settings.Visibility == "" {
+                settings.Visibility = previous.Visibility
+        }
+        if settings == previous {
+                return settings
+        }
+
+        if err == nil {
+                var changes []string
+                changed := func(name, before, after string) {
+                        if before != "" && before != after {
+                                changes = append(changes, fmt.Sprintf("%s: %s -> %s", name, before, after))
+                        }
+                }
+                changed("Join rule", previous.JoinRule, settings.JoinRule)
+                changed("Guest access", previous.GuestAccess, settings.GuestAccess)
+                changed("Directory visibility", previous.Visibility, settings.Visibility)
+                if len(changes) > 0 {
+                        sendRoomReport(ctx, client, roomID, severityWarning, fmt.Sprintf("Settings of room %s changed:\n%s", roomDescription, strings.Join(changes, "\n")))
+                }
+        }
+
+        if _, err := db.Exec(`INSERT INTO room_settings (room_id, join_rule, guest_access, visibility) VALUES (?, ?, ?, ?)
+                ON CONFLICT (room_id) DO UPDATE SET join_rule = excluded.join_rule, guest_access = excluded.guest_access, visibility = excluded.visibility`,
+                roomID.String(), settings.JoinRule, settings.GuestAccess, settings.Visibility); err != nil {
+                fmt.Printf("Failed to save the settings of room %s: %v\n", roomID, err)
+        }
+        return settings
+}
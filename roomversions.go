@@ -0,0 +1,157 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "strconv"
+        "strings"
+        "sync"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/event"
+        "maunium.net/go/mautrix/id"
+)
+
+// defaultUpgradeTarget is the room version rooms are expected to be upgraded to, unless configured otherwise
+const defaultUpgradeTarget = "10"
+
+// minimumSoftwareVersions lists, per server software, the first release that supports each room version.
+// Servers running other software are never flagged.
+var minimumSoftwareVersions = map[string]map[string]string{
+        "synapse": {
+                "6":  "1.14.0",
+                "7":  "1.37.0",
+                "8":  "1.40.0",
+                "9":  "1.42.0",
+                "10": "1.64.0",
+                "11": "1.89.0",
+        },
+}
+
+var (
+        roomVersionsMu sync.Mutex
+        roomVersions   = make(map[id.RoomID]string)          // Version of each monitored room
+        versionWarned  = make(map[id.RoomID]map[string]bool) // Servers already reported per room
+)
+
+// roomVersion returns a room's version from its create event, recording it in the store the first time
+func roomVersion(ctx context.Context, client *mautrix.Client, roomID id.RoomID) string {
+        roomVersionsMu.Lock()
+        version, ok := roomVersions[roomID]
+        roomVersionsMu.Unlock()
+        if ok {
+                return version
+        }
+
+        var create struct {
+                RoomVersion string `json:"room_version"`
+        }
+        if err := roomState(ctx, client, roomID, event.StateCreate, "", &create); err != nil {
+                fmt.Printf("Failed to get the version of room %s: %v\n", roomID, err)
+                return ""
+        }
+        version = create.RoomVersion
+        if version == "" {
+                version = "1" // Rooms created before room versions existed
+        }
+
+        roomVersionsMu.Lock()
+        roomVersions[roomID] = version
+        roomVersionsMu.Unlock()
+        if _, err := db.Exec(`INSERT INTO room_versions (room_id, version) VALUES (?, ?) ON CONFLICT (room_id) DO UPDATE SET version = excluded.version`,
+                roomID.String(), version); err != nil {
+                fmt.Printf("Failed to record the version of room %s: %v\n", roomID, err)
+        }
+        return version
+}
+
+// reportRoomVersionSupport warns about servers in a room whose software is too old for the room's version,
+// or for the version it would be upgraded to. Each server is reported once per room.
+func reportRoomVersionSupport(ctx context.Context, client *mautrix.Client, roomID id.RoomID, roomDescription string, servers []string) {
+        version := roomVersion(ctx, client, roomID)
+        if version == "" {
+                return
+        }
+        target := config().RoomUpgradeTarget
+        if target == "" {
+                target = defaultUpgradeTarget
+        }
+
+        var lines []string
+        roomVersionsMu.Lock()
+        warned := versionWarned[roomID]
+        if warned == nil {
+                warned = make(map[string]bool)
+                versionWarned[roomID] = warned
+        }
+        for _, server := range servers {
+                result, ok := latestResult(server)
+                if !ok || len(versionParts(result.Version)) == 0 || warned[server] {
+                        continue
+                }
+                software := fmt.Sprintf("%s %s", result.Software, result.Version)
+                if needed, ok := minimumVersion(result.Software, version); ok && compareVersions(result.Version, needed) < 0 {
+                        lines = append(lines, fmt.Sprintf("%s - %s cannot support room version %s (needs %s)", server, software, version, needed))
+                        warned[server] = true
+                } else if needed, ok := minimumVersion(result.Software, target); ok && compareVersions(result.Version, needed) < 0 {
+                        lines = append(lines, fmt.Sprintf("%s - %s would fall out after an upgrade to room version %s (needs %s)", server, software, target, needed))
+                        warned[server] = true
+                }
+        }
+        roomVersionsMu.Unlock()
+
+        if len(lines) > 0 {
+                message := fmt.Sprintf("Servers with outdated software in room %s (room version %s):\n%s", roomDescription, version, reportLines(lines))
+                fmt.Println(message)
+                sendRoomReport(ctx, client, roomID, severityWarning, message)
+        }
+}
+
+// minimumVersion returns the first release of the software supporting the room version, if known
+func minimumVersion(software, roomVersion string) (string, bool) {
+        needed, ok := minimumSoftwareVersions[strings.ToLower(software)][roomVersion]
+        return needed, ok
+}
+
+// compareVersions compares dotted version numbers like 1.42.0, ignoring suffixes such as "rc1" or " (b=...)".
+// It returns a negative number if a is older than b, 0 if they are equal and a positive number if a is newer.
+func compareVersions(a, b string) int {
+        partsA, partsB := versionParts(a), versionParts(b)
+        for i := 0; i < len(partsA) || i < len(partsB); i++ {
+                var x, y int
+                if i < len(partsA) {
+                        x = partsA[i]
+                }
+                if i < len(partsB) {
+                        y = partsB[i]
+                }
+                if x != y {
+                        return x - y
+                }
+        }
+        return 0
+}
+
+// versionParts returns the leading numeric components of a version
+func versionParts(version string) []int {
+        fields := strings.Fields(version)
+        if len(fields) == 0 {
+                return nil
+        }
+        var parts []int
+        for _, field := range strings.Split(strings.TrimPrefix(fields[0], "v"), ".") {
+                end := 0
+                for end < len(field) && field[end] >= '0' && field[end] <= '9' {
+                        end++
+                }
+                n, err := strconv.Atoi(field[:end])
+                if err != nil {
+                        break
+                }
+                parts = append(parts, n)
+                if end < len(field) {
+                        break
+                }
+        }
+        return parts
+}
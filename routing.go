@@ -0,0 +1,133 @@
+package main
+
+import (
+        "context"
+        "fmt"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/event"
+        "maunium.net/go/mautrix/id"
+)
+
+// severity classifies log room messages so they can be routed to different rooms
+type severity string
+
+const (
+        severityCritical severity = "critical"    // Failures humans have to act on
+        severityWarning  severity = "warning"     // Misconfigurations and degradations
+        severityInfo     severity = "info"        // Routine summaries and statistics
+        severityCert     severity = "certificate" // TLS certificates expiring soon, expired or untrusted
+)
+
+// logRoomFor returns the log room for messages of the given severity, falling back to the global log room
+func logRoomFor(sev severity) id.RoomID {
+        var room string
+        switch sev {
+        case severityCritical:
+                room = config().LogRoomCritical
+        case severityWarning:
+                room = config().LogRoomWarning
+        case severityInfo:
+                room = config().LogRoomInfo
+        case severityCert:
+                room = config().LogRoomCert
+                if room == "" {
+                        room = config().LogRoomWarning
+                }
+        }
+        if room == "" {
+                room = config().LogRoom
+        }
+        return id.RoomID(room)
+}
+
+// sendReport sends a message to the log room responsible for its severity
+func sendReport(ctx context.Context, client *mautrix.Client, sev severity, message string) error {
+        return sendMessageToRoom(ctx, client, logRoomFor(sev), message)
+}
+
+// sendRoomReport sends a message about a monitored room to that room's dedicated log room,
+// or its tenant's log room, or by severity when the room has neither
+func sendRoomReport(ctx context.Context, client *mautrix.Client, roomID id.RoomID, sev severity, message string) error {
+        return sendMessageToRoom(ctx, client, roomReportTarget(roomID, sev), message)
+}
+
+// roomReportTarget returns the log room for messages about a monitored room: the room's dedicated log room,
+// its tenant's log room, or the log room for the severity
+func roomReportTarget(roomID id.RoomID, sev severity) id.RoomID {
+        if room, ok := config().RoomLogRooms[roomID.String()]; ok && room != "" {
+                return id.RoomID(room)
+        }
+        if tenant := tenantOf(roomID); tenant != "" && config().Tenants[tenant].LogRoom != "" {
+                return id.RoomID(config().Tenants[tenant].LogRoom)
+        }
+        return logRoomFor(sev)
+}
+
+// msgTypeFor returns the msgtype of the bot's messages in a room: the room's own from room_msgtypes, or msgtype,
+// defaulting to m.text. m.notice is shown less prominently by most clients, and other bots don't react to it.
+func msgTypeFor(roomID id.RoomID) event.MessageType {
+        if msgType := config().RoomMsgTypes[roomID.String()]; msgType != "" {
+                return event.MessageType(msgType)
+        }
+        if config().MsgType != "" {
+                return event.MessageType(config().MsgType)
+        }
+        return event.MsgText
+}
+
+// validateMsgTypes checks that msgtype and room_msgtypes only use m.text or m.notice
+func validateMsgTypes(c *Config) error {
+        check := func(name, msgType string) error {
+                switch event.MessageType(msgType) {
+                case "", event.MsgText, event.MsgNotice:
+                        return nil
+                }
+                return fmt.Errorf("invalid %s %q (expected m.text or m.notice)", name, msgType)
+        }
+        if err := check("msgtype", c.MsgType); err != nil {
+                return err
+        }
+        for room, msgType := range c.RoomMsgTypes {
+                if err := check("room_msgtypes for "+room, msgType); err != nil {
+                        return err
+                }
+        }
+        return nil
+}
+
+// logRooms returns every distinct configured log room
+func logRooms() []id.RoomID {
+        return logRoomsOf(config())
+}
+
+// logRoomsOf returns every distinct log room of a configuration
+func logRoomsOf(c *Config) []id.RoomID {
+        var rooms []id.RoomID
+        seen := make(map[id.RoomID]bool)
+        candidates := []string{c.LogRoom, c.LogRoomCritical, c.LogRoomWarning, c.LogRoomInfo, c.LogRoomCert}
+        for _, room := range c.RoomLogRooms {
+                candidates = append(candidates, room)
+        }
+        for _, tenant := range c.Tenants {
+                candidates = append(candidates, tenant.LogRoom)
+        }
+        for _, room := range candidates {
+                if room == "" || seen[id.RoomID(room)] {
+                        continue
+                }
+                seen[id.RoomID(room)] = true
+                rooms = append(rooms, id.RoomID(room))
+        }
+        return rooms
+}
+
+// isLogRoom reports whether a room is one of the configured log rooms
+func isLogRoom(roomID id.RoomID) bool {
+        for _, room := range logRooms() {
+                if room == roomID {
+                        return true
+                }
+        }
+        return false
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+        "hash/fnv"
+        "sync"
+)
+
+// SamplingConfig probes very stable servers less often: after a long run of OK checks a server is only probed
+// every few cycles, and any other result puts it back on every cycle
+type SamplingConfig struct {
+        Enabled     bool `yaml:"enabled"`
+        StableAfter int  `yaml:"stable_after"` // OK checks in a row after which a server counts as stable, defaults to 50
+        Every       int  `yaml:"every"`        // Cycles between probes of a stable server, defaults to 5
+}
+
+var (
+        samplingMu    sync.Mutex
+        okStreaks     = make(map[string]int) // OK checks in a row of each server
+        samplingCycle int                    // Check cycles so far, to spread stable servers' probes over the cycles
+)
+
+// recordStreak counts a check towards a server's run of OK results; any other result ends the run
+func recordStreak(server, status string) {
+        samplingMu.Lock()
+        defer samplingMu.Unlock()
+        if status == "OK" {
+                okStreaks[server]++
+        } else {
+                delete(okStreaks, server)
+        }
+}
+
+// nextSamplingCycle starts a new check cycle
+func nextSamplingCycle() {
+        samplingMu.Lock()
+        defer samplingMu.Unlock()
+        samplingCycle++
+}
+
+// sampleServers returns the servers due for a probe this cycle: all of them, except stable servers whose turn
+// hasn't come. Each stable server has its own turn, so their probes are spread evenly over the cycles.
+func sampleServers(servers []string) []string {
+        if !config().Sampling.Enabled {
+                return servers
+        }
+        stableAfter, every := config().Sampling.StableAfter, config().Sampling.Every
+        if stableAfter <= 0 {
+                stableAfter = 50
+        }
+        if every <= 0 {
+                every = 5
+        }
+
+        samplingMu.Lock()
+        defer samplingMu.Unlock()
+        due := make([]string, 0, len(servers))
+        for _, server := range servers {
+                if okStreaks[server] >= stableAfter {
+                        if _, ok := latestResult(server); ok && (samplingCycle+serverOffset(server))%every != 0 {
+                                continue
+                        }
+                }
+                due = append(due, server)
+        }
+        return due
+}
+
+// serverOffset spreads servers over the cycles by their name
+func serverOffset(server string) int {
+        hash := fnv.New32a()
+        hash.Write([]byte(server))
+        return int(hash.Sum32() & 0xffff)
+}
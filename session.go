@@ -0,0 +1,194 @@
+package main
+
+import (
+        "context"
+        "errors"
+        "fmt"
+        "sync"
+        "time"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/id"
+)
+
+const (
+        loginRetryMin      = 5 * time.Second
+        loginRetryMax      = 10 * time.Minute
+        loginFailuresAlert = 5 // Failed attempts in a row before the fallback webhook is notified
+)
+
+// sessionMu serializes re-logins, so concurrent requests failing with M_UNKNOWN_TOKEN share one
+var sessionMu sync.Mutex
+
+// loginRequest returns the password login for the configured account
+func loginRequest() *mautrix.ReqLogin {
+        return &mautrix.ReqLogin{
+                Type: mautrix.AuthTypePassword,
+                Identifier: mautrix.UserIdentifier{
+                        Type: mautrix.IdentifierTypeUser,
+                        User: config().Username,
+                },
+                Password:                 config().Password,
+                InitialDeviceDisplayName: deviceDisplayName,
+        }
+}
+
+// logIn logs in once: with the configured access token or the session saved by the last password login if it is
+// still valid, and with the password otherwise, keeping the saved device. Encryption goes through the crypto helper.
+func logIn(ctx context.Context, client *mautrix.Client) error {
+        resumed, err := resumeSession(ctx, client)
+        if err != nil {
+                return err
+        }
+        if resumed {
+                if config().Encryption.Enabled {
+                        return loginEncrypted(ctx, client, nil)
+                }
+                return nil
+        }
+
+        if config().Password == "" {
+                return fmt.Errorf("no valid access token, and no password to log in with")
+        }
+        login := loginRequest()
+        login.DeviceID = id.DeviceID(loadState("session_device"))
+        if config().Encryption.Enabled {
+                // The crypto helper logs in itself, so its keys stay with the same device
+                if err := loginEncrypted(ctx, client, login); err != nil {
+                        return err
+                }
+                saveSession(client)
+                return nil
+        }
+        loginResp, err := client.Login(ctx, login)
+        if err != nil {
+                return err
+        }
+
+        // Set the access token explicitly
+        client.AccessToken = loginResp.AccessToken
+        client.UserID = loginResp.UserID
+        client.DeviceID = loginResp.DeviceID
+        saveSession(client)
+        return nil
+}
+
+// resumeSession uses the configured access token, or else the saved session, if the homeserver still accepts it
+func resumeSession(ctx context.Context, client *mautrix.Client) (bool, error) {
+        token, device := config().AccessToken, config().DeviceID
+        if token == "" {
+                token, device = loadState("session_token"), loadState("session_device")
+        }
+        if token == "" {
+                return false, nil
+        }
+
+        client.AccessToken = token
+        client.UserID = id.UserID(config().Username)
+        client.DeviceID = id.DeviceID(device)
+        whoami, err := client.Whoami(ctx)
+        if isUnknownToken(err) {
+                fmt.Println("The access token is no longer valid, logging in with the password")
+                client.AccessToken = ""
+                return false, nil
+        } else if err != nil {
+                return false, err
+        }
+        client.UserID = whoami.UserID
+        if whoami.DeviceID != "" {
+                client.DeviceID = whoami.DeviceID
+        }
+        fmt.Printf("Resumed session for device %s\n", client.DeviceID)
+        return true, nil
+}
+
+// saveSession keeps the access token and device of a password login for the next start,
+// so restarts don't create a new device every time
+func saveSession(client *mautrix.Client) {
+        if err := saveState("session_token", client.AccessToken); err != nil {
+                fmt.Println("Failed to save the session:", err)
+                return
+        }
+        if err := saveState("session_device", client.DeviceID.String()); err != nil {
+                fmt.Println("Failed to save the session:", err)
+        }
+}
+
+// loginWithRetry logs in at startup, retrying with exponential backoff until it succeeds or the context ends
+func loginWithRetry(ctx context.Context, client *mautrix.Client) error {
+        return retryLogin(ctx, "Login", func() error {
+                return logIn(ctx, client)
+        })
+}
+
+// recoverSession logs in again after the access token was rejected, keeping the device ID so
+// encryption keys stay valid. Requests failing at the same time wait for the same re-login.
+func recoverSession(ctx context.Context, client *mautrix.Client, rejected string) error {
+        sessionMu.Lock()
+        defer sessionMu.Unlock()
+        if client.AccessToken != rejected {
+                // Another request already logged in again
+                return nil
+        }
+
+        if config().Password == "" {
+                return fmt.Errorf("access token rejected, and no password to log in again with")
+        }
+        fmt.Println("Access token rejected, logging in again...")
+        return retryLogin(ctx, "Re-login", func() error {
+                login := loginRequest()
+                login.DeviceID = client.DeviceID
+                loginResp, err := client.Login(ctx, login)
+                if err != nil {
+                        return err
+                }
+                client.AccessToken = loginResp.AccessToken
+                client.UserID = loginResp.UserID
+                client.DeviceID = loginResp.DeviceID
+                saveSession(client)
+                fmt.Println("Session recovered.")
+                return nil
+        })
+}
+
+// isUnknownToken reports whether the homeserver rejected the access token
+func isUnknownToken(err error) bool {
+        return errors.Is(err, mautrix.MUnknownToken)
+}
+
+// retryLogin runs a login attempt until it succeeds, doubling the delay after each failure.
+// The log rooms are unreachable without a session, so repeated failures go to the fallback webhook.
+func retryLogin(ctx context.Context, what string, attempt func() error) error {
+        delay := loginRetryMin
+        for failures := 1; ; failures++ {
+                err := attempt()
+                if err == nil {
+                        if failures > loginFailuresAlert {
+                                notifyFallback(ctx, fmt.Sprintf("matrix-health: %s as %s succeeded after %d attempts", what, config().Username, failures))
+                        }
+                        return nil
+                }
+                fmt.Printf("%s failed, retrying in %s: %v\n", what, delay, err)
+                if failures == loginFailuresAlert {
+                        notifyFallback(ctx, fmt.Sprintf("matrix-health: %s as %s failed %d times in a row, still retrying: %v", what, config().Username, failures, err))
+                }
+
+                if err := sleepContext(ctx, delay); err != nil {
+                        return err
+                }
+                delay *= 2
+                if delay > loginRetryMax {
+                        delay = loginRetryMax
+                }
+        }
+}
+
+// notifyFallback posts a message to the fallback webhook, for problems that keep the bot out of Matrix
+func notifyFallback(ctx context.Context, message string) {
+        if config().FallbackWebhook == "" {
+                return
+        }
+        if err := postWebhook(ctx, config().FallbackWebhook, map[string]interface{}{"text": message}); err != nil {
+                fmt.Println("Failed to notify the fallback webhook:", err)
+        }
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+        "fmt"
+        "sort"
+        "strings"
+        "sync"
+        "time"
+
+        "maunium.net/go/mautrix/id"
+)
+
+// Servers silenced with !health silence, kept in the store: they are still checked, but their problems aren't reported
+var (
+        silencesMu sync.RWMutex
+        silences   = make(map[string]time.Time) // Until when each server is silenced
+)
+
+// loadSilences loads the silences that haven't expired from the store
+func loadSilences() error {
+        rows, err := db.Query(`SELECT server, until FROM silences WHERE until > ?`, time.Now().Unix())
+        if err != nil {
+                return err
+        }
+        defer rows.Close()
+
+        silencesMu.Lock()
+        defer silencesMu.Unlock()
+        for rows.Next() {
+                var server string
+                var until int64
+                if err := rows.Scan(&server, &until); err != nil {
+                        return err
+                }
+                silences[server] = time.Unix(until, 0)
+        }
+        return rows.Err()
+}
+
+// silence stops reporting a server's problems until the given time
+func silence(server string, until time.Time, author id.UserID) error {
+        server = strings.ToLower(server)
+        if _, err := db.Exec(`INSERT INTO silences (server, until, author) VALUES (?, ?, ?)
+                ON CONFLICT (server) DO UPDATE SET until = excluded.until, author = excluded.author`,
+                server, until.Unix(), author.String()); err != nil {
+                return err
+        }
+        silencesMu.Lock()
+        defer silencesMu.Unlock()
+        recordAudit(author.String(), "silence", server, formatSilence(silences[server]), formatSilence(until))
+        silences[server] = until
+        return nil
+}
+
+// unsilence reports a server's problems again
+func unsilence(server string, actor id.UserID) error {
+        server = strings.ToLower(server)
+        if _, err := db.Exec(`DELETE FROM silences WHERE server = ?`, server); err != nil {
+                return err
+        }
+        silencesMu.Lock()
+        defer silencesMu.Unlock()
+        recordAudit(actor.String(), "unsilence", server, formatSilence(silences[server]), "")
+        delete(silences, server)
+        return nil
+}
+
+// formatSilence formats the end of a silence for the audit log, "" for none
+func formatSilence(until time.Time) string {
+        if until.IsZero() {
+                return ""
+        }
+        return until.UTC().Format("2006-01-02 15:04 UTC")
+}
+
+// isSilenced reports whether a server's problems are currently not reported
+func isSilenced(server string) bool {
+        silencesMu.RLock()
+        defer silencesMu.RUnlock()
+        until, ok := silences[strings.ToLower(server)]
+        return ok && time.Now().Before(until)
+}
+
+// activeSilences returns the silenced servers with the time their silence ends, sorted by server
+func activeSilences() []string {
+        now := time.Now()
+        var lines []string
+        silencesMu.RLock()
+        for server, until := range silences {
+                if now.Before(until) {
+                        lines = append(lines, fmt.Sprintf("%s until %s", server, until.UTC().Format("2006-01-02 15:04 UTC")))
+                }
+        }
+        silencesMu.RUnlock()
+        sort.Strings(lines)
+        return lines
+}
+
+// dropSilenced removes silenced servers from a set of check results, so they are left out of reports
+func dropSilenced(statuses map[string]string) {
+        for server := range statuses {
+                if isSilenced(server) {
+                        delete(statuses, server)
+                }
+        }
+}
+
+// commandSilence silences a server for a while, or lists the silences, e.g. "!health silence example.org 2h"
+func commandSilence(args []string, author id.UserID) string {
+        if len(args) == 0 {
+                lines := activeSilences()
+                if len(lines) == 0 {
+                        return "No servers are silenced"
+                }
+                return fmt.Sprintf("Silenced servers:\n%s", strings.Join(lines, "\n"))
+        }
+        if len(args) != 2 {
+                return "Usage: !health silence [<server> <duration>] (e.g. 2h, 1d)"
+        }
+        duration, err := parseAge(args[1])
+        if err != nil {
+                return err.Error()
+        }
+        until := time.Now().Add(duration)
+        if err := silence(args[0], until, author); err != nil {
+                return fmt.Sprintf("Failed to silence %s: %v", args[0], err)
+        }
+        return fmt.Sprintf("Silenced %s until %s", args[0], until.UTC().Format("2006-01-02 15:04 UTC"))
+}
+
+// commandUnsilence ends a server's silence, e.g. "!health unsilence example.org"
+func commandUnsilence(args []string, actor id.UserID) string {
+        if len(args) != 1 {
+                return "Usage: !health unsilence <server>"
+        }
+        if err := unsilence(args[0], actor); err != nil {
+                return fmt.Sprintf("Failed to unsilence %s: %v", args[0], err)
+        }
+        return fmt.Sprintf("Problems of %s are reported again", args[0])
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+        "compress/gzip"
+        "database/sql"
+        "encoding/json"
+        "flag"
+        "fmt"
+        "os"
+        "strings"
+        "time"
+)
+
+// stateFormat is the version of the state archive layout, raised when it changes incompatibly
+const stateFormat = 1
+
+// stateTables are the tables carried in a state archive: the check history, incidents, silences and everything
+// else the monitor remembers. Maintenance windows live in the configuration and are not part of it.
+var stateTables = []string{
+        "checks", "outages", "server_hourly", "server_state", "silences", "server_notes", "server_tags",
+        "audit_log", "room_stats", "room_versions", "room_settings", "alert_threads", "servers", "server_rooms",
+        "server_versions", "bot_state",
+}
+
+// stateArchive is the portable form of the store: gzipped JSON with the rows of each table as column maps
+type stateArchive struct {
+        Format   int                                 `json:"format"`
+        Version  string                              `json:"version"`  // matrix-health version that wrote it
+        Exported int64                               `json:"exported"` // Unix time of the export
+        Tables   map[string][]map[string]interface{} `json:"tables"`
+}
+
+// runState runs the state export and import commands, which work on the store without logging in, and returns the exit code
+func runState(args []string) int {
+        if len(args) == 0 || (args[0] != "export" && args[0] != "import") {
+                fmt.Println("usage: matrix-health state export [-o file] | state import [-replace] <file>")
+                return 2
+        }
+        if err := loadValidConfig(); err != nil {
+                fmt.Println("Configuration error:", err)
+                return 2
+        }
+        if err := openStore(); err != nil {
+                fmt.Println("Failed to open the database:", err)
+                return 1
+        }
+        defer db.Close()
+
+        var err error
+        if args[0] == "export" {
+                err = runStateExport(args[1:])
+        } else {
+                err = runStateImport(args[1:])
+        }
+        if err != nil {
+                fmt.Printf("State %s failed: %v\n", args[0], err)
+                return 1
+        }
+        return 0
+}
+
+// runStateExport writes every state table to an archive, e.g. "state export -o state.json.gz"
+func runStateExport(args []string) error {
+        flags := flag.NewFlagSet("state export", flag.ContinueOnError)
+        output := flags.String("o", "", "output file (defaults to matrix-health-state-<date>.json.gz)")
+        if err := flags.Parse(args); err != nil {
+                return err
+        }
+        if *output == "" {
+                *output = fmt.Sprintf("matrix-health-state-%s.json.gz", time.Now().Format("2006-01-02"))
+        }
+
+        archive := stateArchive{Format: stateFormat, Version: buildVersion, Exported: time.Now().Unix(), Tables: make(map[string][]map[string]interface{})}
+        for _, table := range stateTables {
+                rows, err := exportTable(table)
+                if err != nil {
+                        return fmt.Errorf("failed to read %s: %w", table, err)
+                }
+                archive.Tables[table] = rows
+        }
+
+        file, err := os.Create(*output)
+        if err != nil {
+                return err
+        }
+        defer file.Close()
+        gz := gzip.NewWriter(file)
+        if err := json.NewEncoder(gz).Encode(archive); err != nil {
+                return err
+        }
+        if err := gz.Close(); err != nil {
+                return err
+        }
+        fmt.Printf("Exported %s to %s\n", describeTables(archive.Tables), *output)
+        return file.Close()
+}
+
+// exportTable reads all rows of a table as column maps
+func exportTable(table string) ([]map[string]interface{}, error) {
+        rows, err := db.Query(`SELECT * FROM ` + table)
+        if err != nil {
+                return nil, err
+        }
+        defer rows.Close()
+        columns, err := rows.Columns()
+        if err != nil {
+                return nil, err
+        }
+
+        exported := []map[string]interface{}{}
+        for rows.Next() {
+                values := make([]interface{}, len(columns))
+                pointers := make([]interface{}, len(columns))
+                for i := range values {
+                        pointers[i] = &values[i]
+                }
+                if err := rows.Scan(pointers...); err != nil {
+                        return nil, err
+                }
+                row := make(map[string]interface{}, len(columns))
+                for i, column := range columns {
+                        if bytes, ok := values[i].([]byte); ok {
+                                values[i] = string(bytes)
+                        }
+                        row[column] = values[i]
+                }
+                exported = append(exported, row)
+        }
+        return exported, rows.Err()
+}
+
+// runStateImport restores the state tables from an archive, e.g. "state import state.json.gz". Into a store that
+// already has history it only imports with -replace, which discards what the store held.
+func runStateImport(args []string) error {
+        flags := flag.NewFlagSet("state import", flag.ContinueOnError)
+        replace := flags.Bool("replace", false, "replace the state already in the database")
+        if err := flags.Parse(args); err != nil {
+                return err
+        }
+        if flags.NArg() != 1 {
+                return fmt.Errorf("usage: matrix-health state import [-replace] <file>")
+        }
+
+        archive, err := readStateArchive(flags.Arg(0))
+        if err != nil {
+                return err
+        }
+        if archive.Format != stateFormat {
+                return fmt.Errorf("unsupported archive format %d (this version reads format %d)", archive.Format, stateFormat)
+        }
+        if !*replace {
+                var checks int
+                if err := db.QueryRow(`SELECT COUNT(*) FROM checks`).Scan(&checks); err != nil {
+                        return err
+                }
+                if checks > 0 {
+                        return fmt.Errorf("the database already has %d check results; use -replace to overwrite them", checks)
+                }
+        }
+
+        tx, err := db.Begin()
+        if err != nil {
+                return err
+        }
+        defer tx.Rollback()
+        for _, table := range stateTables {
+                if _, err := tx.Exec(`DELETE FROM ` + table); err != nil {
+                        return fmt.Errorf("failed to clear %s: %w", table, err)
+                }
+                for _, row := range archive.Tables[table] {
+                        if err := importRow(tx, table, row); err != nil {
+                                return fmt.Errorf("failed to import into %s: %w", table, err)
+                        }
+                }
+        }
+        if err := tx.Commit(); err != nil {
+                return err
+        }
+        fmt.Printf("Imported %s exported by matrix-health %s on %s\n", describeTables(archive.Tables), archive.Version,
+                time.Unix(archive.Exported, 0).UTC().Format(time.RFC3339))
+        return nil
+}
+
+// readStateArchive reads a state archive written by state export
+func readStateArchive(path string) (*stateArchive, error) {
+        file, err := os.Open(path)
+        if err != nil {
+                return nil, err
+        }
+        defer file.Close()
+        gz, err := gzip.NewReader(file)
+        if err != nil {
+                return nil, fmt.Errorf("not a state archive: %w", err)
+        }
+        defer gz.Close()
+
+        // Numbers are kept exact, as timestamps in milliseconds don't survive a float64 round trip
+        decoder := json.NewDecoder(gz)
+        decoder.UseNumber()
+        var archive stateArchive
+        if err := decoder.Decode(&archive); err != nil {
+                return nil, fmt.Errorf("not a state archive: %w", err)
+        }
+        return &archive, nil
+}
+
+// importRow inserts an archived row into a table
+func importRow(tx *sql.Tx, table string, row map[string]interface{}) error {
+        columns := make([]string, 0, len(row))
+        values := make([]interface{}, 0, len(row))
+        for column, value := range row {
+                if strings.ContainsAny(column, " \"'`;()") {
+                        return fmt.Errorf("invalid column name %q", column)
+                }
+                if number, ok := value.(json.Number); ok {
+                        if n, err := number.Int64(); err == nil {
+                                value = n
+                        } else if f, err := number.Float64(); err == nil {
+                                value = f
+                        }
+                }
+                columns = append(columns, column)
+                values = append(values, value)
+        }
+        placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+        _, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)`, table, strings.Join(columns, ", "), placeholders), values...)
+        return err
+}
+
+// describeTables summarizes the row counts of an archive, e.g. "1200 checks, 3 outages, ..."
+func describeTables(tables map[string][]map[string]interface{}) string {
+        var parts []string
+        for _, table := range stateTables {
+                if rows := len(tables[table]); rows > 0 {
+                        parts = append(parts, fmt.Sprintf("%d %s", rows, table))
+                }
+        }
+        if len(parts) == 0 {
+                return "an empty state"
+        }
+        return strings.Join(parts, ", ")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+        "context"
+        "encoding/json"
+        "errors"
+        "sync"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/event"
+        "maunium.net/go/mautrix/id"
+)
+
+// stateKey identifies a state event in a room
+type stateKey struct {
+        Type     string
+        StateKey string
+}
+
+// Room state read by the bot (names, aliases, power levels, ACLs, tombstones, ...), fetched once per room and
+// then kept current from the state events in the sync, so each cycle doesn't ask the homeserver again.
+// A nil content records state the room doesn't have.
+var (
+        stateCacheMu sync.Mutex
+        stateCache   = make(map[id.RoomID]map[stateKey]json.RawMessage)
+)
+
+// roomState reads a state event's content into out, from the cache or the homeserver. Like client.StateEvent,
+// it returns an M_NOT_FOUND error for state the room doesn't have.
+func roomState(ctx context.Context, client *mautrix.Client, roomID id.RoomID, evtType event.Type, key string, out interface{}) error {
+        cacheKey := stateKey{Type: evtType.Type, StateKey: key}
+        stateCacheMu.Lock()
+        content, ok := stateCache[roomID][cacheKey]
+        stateCacheMu.Unlock()
+        if ok {
+                if content == nil {
+                        return mautrix.MNotFound
+                }
+                return json.Unmarshal(content, out)
+        }
+
+        var raw json.RawMessage
+        err := client.StateEvent(ctx, roomID, evtType, key, &raw)
+        switch {
+        case errors.Is(err, mautrix.MNotFound):
+                cacheState(roomID, cacheKey, nil)
+                return err
+        case err != nil:
+                // Not cached: the bot may be able to read it later, e.g. after being invited back
+                return err
+        }
+        cacheState(roomID, cacheKey, raw)
+        return json.Unmarshal(raw, out)
+}
+
+// cacheState stores a state event's content in the cache
+func cacheState(roomID id.RoomID, key stateKey, content json.RawMessage) {
+        stateCacheMu.Lock()
+        defer stateCacheMu.Unlock()
+        room, ok := stateCache[roomID]
+        if !ok {
+                room = make(map[stateKey]json.RawMessage)
+                stateCache[roomID] = room
+        }
+        room[key] = content
+}
+
+// updateStateCache applies a state event from the sync to the cache. Only state the bot has read before is kept
+// current, and a room is forgotten when the bot leaves it, as its state then stops arriving.
+func updateStateCache(client *mautrix.Client, evt *event.Event) {
+        if evt.StateKey == nil {
+                return
+        }
+        if evt.Type == event.StateMember && id.UserID(*evt.StateKey) == client.UserID {
+                if membership, _ := evt.Content.Raw["membership"].(string); membership != string(event.MembershipJoin) {
+                        stateCacheMu.Lock()
+                        delete(stateCache, evt.RoomID)
+                        stateCacheMu.Unlock()
+                        return
+                }
+        }
+        key := stateKey{Type: evt.Type.Type, StateKey: *evt.StateKey}
+        stateCacheMu.Lock()
+        defer stateCacheMu.Unlock()
+        if _, cached := stateCache[evt.RoomID][key]; cached {
+                stateCache[evt.RoomID][key] = evt.Content.VeryRaw
+        }
+}
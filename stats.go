@@ -0,0 +1,108 @@
+package main
+
+import (
+        "fmt"
+        "sort"
+        "strings"
+        "time"
+)
+
+// federationStats aggregates what is known about all observed servers
+type federationStats struct {
+        GeneratedAt  time.Time                 `json:"generated_at"`
+        Servers      int                       `json:"servers"`
+        Software     map[string]int            `json:"software"`     // Servers per software name
+        Versions     map[string]map[string]int `json:"versions"`     // Servers per version, per software name
+        Availability map[string]int            `json:"availability"` // Servers per availability bucket
+}
+
+// availabilityBuckets are the histogram buckets, as lower bounds in percent, from best to worst
+var availabilityBuckets = []struct {
+        Name  string
+        Lower float64
+}{
+        {"100%", 100},
+        {"99-100%", 99},
+        {"95-99%", 95},
+        {"90-95%", 90},
+        {"<90%", 0},
+}
+
+// compileStats builds aggregate statistics from the latest results of every observed server
+func compileStats() federationStats {
+        stats := federationStats{
+                GeneratedAt:  time.Now(),
+                Software:     make(map[string]int),
+                Versions:     make(map[string]map[string]int),
+                Availability: make(map[string]int),
+        }
+
+        resultsMu.RLock()
+        defer resultsMu.RUnlock()
+        for _, result := range latestResults {
+                stats.Servers++
+
+                software := result.Software
+                if software == "" {
+                        software = "unknown"
+                }
+                stats.Software[software]++
+                if result.Version != "" {
+                        if stats.Versions[software] == nil {
+                                stats.Versions[software] = make(map[string]int)
+                        }
+                        stats.Versions[software][result.Version]++
+                }
+
+                if result.Checks > 0 {
+                        availability := 100 * float64(result.Successes) / float64(result.Checks)
+                        for _, bucket := range availabilityBuckets {
+                                if availability >= bucket.Lower {
+                                        stats.Availability[bucket.Name]++
+                                        break
+                                }
+                        }
+                }
+        }
+        return stats
+}
+
+// String formats the statistics as a report message
+func (s federationStats) String() string {
+        var b strings.Builder
+        fmt.Fprintf(&b, "Federation statistics for %d servers:\n", s.Servers)
+
+        b.WriteString("Software:\n")
+        for _, name := range sortedByCount(s.Software) {
+                fmt.Fprintf(&b, "  %s: %d", name, s.Software[name])
+                if versions := s.Versions[name]; len(versions) > 0 {
+                        var spread []string
+                        for _, version := range sortedByCount(versions) {
+                                spread = append(spread, fmt.Sprintf("%s (%d)", version, versions[version]))
+                        }
+                        fmt.Fprintf(&b, " - %s", strings.Join(spread, ", "))
+                }
+                b.WriteString("\n")
+        }
+
+        b.WriteString("Availability since startup:\n")
+        for _, bucket := range availabilityBuckets {
+                fmt.Fprintf(&b, "  %s: %d\n", bucket.Name, s.Availability[bucket.Name])
+        }
+        return strings.TrimSuffix(b.String(), "\n")
+}
+
+// sortedByCount returns the keys of a count map, most common first
+func sortedByCount(counts map[string]int) []string {
+        keys := make([]string, 0, len(counts))
+        for key := range counts {
+                keys = append(keys, key)
+        }
+        sort.Slice(keys, func(i, j int) bool {
+                if counts[keys[i]] != counts[keys[j]] {
+                        return counts[keys[i]] > counts[keys[j]]
+                }
+                return keys[i] < keys[j]
+        })
+        return keys
+}
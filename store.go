@@ -0,0 +1,167 @@
+package main
+
+import (
+        "database/sql"
+        "fmt"
+
+        _ "github.com/mattn/go-sqlite3"
+)
+
+// DatabaseConfig configures the SQLite database holding the monitor's history
+type DatabaseConfig struct {
+        Path          string `yaml:"path"`           // Database file, defaults to matrix-health.db
+        RetentionDays int    `yaml:"retention_days"` // Days the check history is kept, defaults to 90
+        Backfill      bool   `yaml:"backfill"`       // Rebuild the check and outage history from the bot's earlier reports on first use
+}
+
+// db is the monitor's persistent store
+var db *sql.DB
+
+// schema creates the tables used by the store; every statement must be idempotent
+var schema = []string{
+        `CREATE TABLE IF NOT EXISTS room_stats (
+                room_id TEXT NOT NULL,
+                ts      INTEGER NOT NULL,
+                members INTEGER NOT NULL,
+                servers INTEGER NOT NULL
+        )`,
+        `CREATE INDEX IF NOT EXISTS room_stats_room_ts ON room_stats (room_id, ts)`,
+        `CREATE TABLE IF NOT EXISTS room_versions (
+                room_id TEXT PRIMARY KEY,
+                version TEXT NOT NULL
+        )`,
+        `CREATE TABLE IF NOT EXISTS room_settings (
+                room_id      TEXT PRIMARY KEY,
+                join_rule    TEXT NOT NULL,
+                guest_access TEXT NOT NULL,
+                visibility   TEXT NOT NULL
+        )`,
+        `CREATE TABLE IF NOT EXISTS server_tags (
+                server TEXT NOT NULL,
+                tag    TEXT NOT NULL,
+                PRIMARY KEY (server, tag)
+        )`,
+        `CREATE TABLE IF NOT EXISTS server_hourly (
+                server    TEXT NOT NULL,
+                hour      INTEGER NOT NULL,
+                checks    INTEGER NOT NULL,
+                successes INTEGER NOT NULL,
+                PRIMARY KEY (server, hour)
+        )`,
+        `CREATE TABLE IF NOT EXISTS outages (
+                server  TEXT NOT NULL,
+                started INTEGER NOT NULL,
+                ended   INTEGER
+        )`,
+        `CREATE INDEX IF NOT EXISTS outages_server_started ON outages (server, started)`,
+        `CREATE TABLE IF NOT EXISTS server_notes (
+                server TEXT NOT NULL,
+                note   TEXT NOT NULL,
+                author TEXT NOT NULL,
+                added  INTEGER NOT NULL
+        )`,
+        `CREATE TABLE IF NOT EXISTS checks (
+                server     TEXT NOT NULL,
+                room       TEXT NOT NULL,
+                status     TEXT NOT NULL,
+                reason     TEXT NOT NULL,
+                latency_ms INTEGER NOT NULL,
+                ts         INTEGER NOT NULL
+        )`,
+        `CREATE INDEX IF NOT EXISTS checks_server_ts ON checks (server, ts)`,
+        `CREATE INDEX IF NOT EXISTS checks_ts ON checks (ts)`,
+        `CREATE TABLE IF NOT EXISTS silences (
+                server TEXT PRIMARY KEY,
+                until  INTEGER NOT NULL,
+                author TEXT NOT NULL
+        )`,
+        `CREATE TABLE IF NOT EXISTS audit_log (
+                ts        INTEGER NOT NULL,
+                actor     TEXT NOT NULL,
+                action    TEXT NOT NULL,
+                target    TEXT NOT NULL,
+                old_value TEXT NOT NULL,
+                new_value TEXT NOT NULL
+        )`,
+        `CREATE TABLE IF NOT EXISTS server_state (
+                server  TEXT PRIMARY KEY,
+                status  TEXT NOT NULL,
+                changed INTEGER NOT NULL
+        )`,
+        `CREATE TABLE IF NOT EXISTS alert_threads (
+                room       TEXT NOT NULL,
+                server     TEXT NOT NULL,
+                log_room   TEXT NOT NULL,
+                root_event TEXT NOT NULL,
+                last_event TEXT NOT NULL,
+                status     TEXT NOT NULL,
+                started    INTEGER NOT NULL,
+                updated    INTEGER NOT NULL,
+                PRIMARY KEY (room, server)
+        )`,
+        `CREATE TABLE IF NOT EXISTS servers (
+                server     TEXT PRIMARY KEY,
+                first_seen INTEGER NOT NULL,
+                last_seen  INTEGER NOT NULL,
+                checks     INTEGER NOT NULL,
+                successes  INTEGER NOT NULL
+        )`,
+        `CREATE TABLE IF NOT EXISTS server_rooms (
+                server     TEXT NOT NULL,
+                room       TEXT NOT NULL,
+                first_seen INTEGER NOT NULL,
+                last_seen  INTEGER NOT NULL,
+                PRIMARY KEY (server, room)
+        )`,
+        `CREATE INDEX IF NOT EXISTS server_rooms_room ON server_rooms (room)`,
+        `CREATE TABLE IF NOT EXISTS server_versions (
+                server     TEXT NOT NULL,
+                software   TEXT NOT NULL,
+                version    TEXT NOT NULL,
+                first_seen INTEGER NOT NULL,
+                last_seen  INTEGER NOT NULL,
+                PRIMARY KEY (server, software, version)
+        )`,
+        `CREATE TABLE IF NOT EXISTS bot_state (
+                key   TEXT PRIMARY KEY,
+                value TEXT NOT NULL
+        )`,
+}
+
+// openStore opens (creating if needed) the SQLite database and applies the schema
+func openStore() error {
+        path := config().Database.Path
+        if path == "" {
+                path = "matrix-health.db"
+        }
+        fmt.Printf("Opening database: %s\n", path)
+
+        var err error
+        db, err = sql.Open("sqlite3", path+"?_busy_timeout=5000&_journal_mode=WAL")
+        if err != nil {
+                return err
+        }
+        db.SetMaxOpenConns(1)
+
+        for _, statement := range schema {
+                if _, err := db.Exec(statement); err != nil {
+                        return fmt.Errorf("failed to apply schema: %w", err)
+                }
+        }
+        return nil
+}
+
+// loadState returns a value the bot saved for future runs, or "" if there is none
+func loadState(key string) string {
+        var value string
+        if err := db.QueryRow(`SELECT value FROM bot_state WHERE key = ?`, key).Scan(&value); err != nil && err != sql.ErrNoRows {
+                fmt.Printf("Failed to load %s from the database: %v\n", key, err)
+        }
+        return value
+}
+
+// saveState saves a value for future runs
+func saveState(key, value string) error {
+        _, err := db.Exec(`INSERT INTO bot_state (key, value) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value`, key, value)
+        return err
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "sync/atomic"
+        "time"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/event"
+)
+
+// startSync runs the sync loop in the background, restarting it after errors
+func startSync(ctx context.Context, client *mautrix.Client) {
+        syncer := client.Syncer.(*mautrix.DefaultSyncer)
+        syncer.FilterJSON = lazyLoadFilter
+
+        // Set when the sync loop failed, so the next batch is known to be a backlog
+        var resyncing atomic.Bool
+
+        // Events from the initial sync are history rather than live traffic, and so are
+        // those in the first batch after a sync error, at least as far as lag is concerned
+        syncer.OnSync(func(ctx context.Context, resp *mautrix.RespSync, since string) bool {
+                lagPaused.Store(resyncing.Swap(false))
+                dropStaleMembers(resp)
+                return since != ""
+        })
+        syncer.OnEvent(func(ctx context.Context, evt *event.Event) {
+                trackEventLag(evt)
+                updateStateCache(client, evt)
+        })
+        if cryptoHelper != nil {
+                // Keeps track of encrypted rooms and their members, so messages are encrypted for every member's devices
+                syncer.OnEvent(client.StateStoreSyncHandler)
+        }
+        syncer.OnEventType(event.EventMessage, func(ctx context.Context, evt *event.Event) {
+                handleCommandEvent(ctx, client, evt)
+        })
+        syncer.OnEventType(event.StateMember, func(ctx context.Context, evt *event.Event) {
+                updateMembers(evt)
+                handleAdminMembership(ctx, client, evt)
+                handleLogRoomStateChange(ctx, client, evt)
+        })
+        syncer.OnEventType(event.StatePowerLevels, func(ctx context.Context, evt *event.Event) {
+                handleLogRoomStateChange(ctx, client, evt)
+        })
+        syncer.OnEventType(event.StateJoinRules, func(ctx context.Context, evt *event.Event) {
+                handleLogRoomStateChange(ctx, client, evt)
+        })
+
+        go func() {
+                for {
+                        token := client.AccessToken
+                        err := client.SyncWithContext(ctx)
+                        if ctx.Err() != nil {
+                                return
+                        }
+                        if isUnknownToken(err) {
+                                if err := recoverSession(ctx, client, token); err != nil {
+                                        return
+                                }
+                                resyncing.Store(true)
+                                continue
+                        }
+                        fmt.Println("Sync failed, retrying in 10 seconds:", err)
+                        resyncing.Store(true)
+                        select {
+                        case <-ctx.Done():
+                                return
+                        case <-time.After(10 * time.Second):
+                        }
+                }
+        }()
+}
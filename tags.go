@@ -0,0 +1,189 @@
+package main
+
+import (
+        "fmt"
+        "sort"
+        "strings"
+        "sync"
+
+        "maunium.net/go/mautrix/id"
+)
+
+// Tags assigned at runtime with !tag, kept in the store, on top of those from the config and inventory
+var (
+        tagsMu      sync.RWMutex
+        runtimeTags = make(map[string]map[string]bool)
+)
+
+// loadTags loads the tags assigned at runtime from the store
+func loadTags() error {
+        rows, err := db.Query(`SELECT server, tag FROM server_tags`)
+        if err != nil {
+                return err
+        }
+        defer rows.Close()
+
+        tagsMu.Lock()
+        defer tagsMu.Unlock()
+        for rows.Next() {
+                var server, tag string
+                if err := rows.Scan(&server, &tag); err != nil {
+                        return err
+                }
+                if runtimeTags[server] == nil {
+                        runtimeTags[server] = make(map[string]bool)
+                }
+                runtimeTags[server][tag] = true
+        }
+        return rows.Err()
+}
+
+// addTag assigns a tag to a server at runtime
+func addTag(server, tag string) error {
+        server, tag = strings.ToLower(server), strings.ToLower(tag)
+        if _, err := db.Exec(`INSERT OR IGNORE INTO server_tags (server, tag) VALUES (?, ?)`, server, tag); err != nil {
+                return err
+        }
+        tagsMu.Lock()
+        defer tagsMu.Unlock()
+        if runtimeTags[server] == nil {
+                runtimeTags[server] = make(map[string]bool)
+        }
+        runtimeTags[server][tag] = true
+        return nil
+}
+
+// removeTag removes a tag assigned at runtime from a server
+func removeTag(server, tag string) error {
+        server, tag = strings.ToLower(server), strings.ToLower(tag)
+        if _, err := db.Exec(`DELETE FROM server_tags WHERE server = ? AND tag = ?`, server, tag); err != nil {
+                return err
+        }
+        tagsMu.Lock()
+        defer tagsMu.Unlock()
+        delete(runtimeTags[server], tag)
+        return nil
+}
+
+// serverTags returns all tags of a server, sorted: from the config, the inventory and runtime assignments
+func serverTags(server string) []string {
+        seen := make(map[string]bool)
+        for _, tag := range serverOverride(server).Tags {
+                seen[strings.ToLower(tag)] = true
+        }
+        tagsMu.RLock()
+        for tag := range runtimeTags[strings.ToLower(server)] {
+                seen[tag] = true
+        }
+        tagsMu.RUnlock()
+
+        tags := make([]string, 0, len(seen))
+        for tag := range seen {
+                tags = append(tags, tag)
+        }
+        sort.Strings(tags)
+        return tags
+}
+
+// hasTag reports whether a server has the given tag
+func hasTag(server, tag string) bool {
+        for _, t := range serverTags(server) {
+                if t == strings.ToLower(tag) {
+                        return true
+                }
+        }
+        return false
+}
+
+// notifyRooms returns the extra rooms that receive a server's failures and warnings:
+// its own notify room and the rooms routed to by its tags
+func notifyRooms(server string) []id.RoomID {
+        var rooms []id.RoomID
+        seen := make(map[id.RoomID]bool)
+        add := func(room string) {
+                if room != "" && !seen[id.RoomID(room)] {
+                        seen[id.RoomID(room)] = true
+                        rooms = append(rooms, id.RoomID(room))
+                }
+        }
+
+        add(serverOverride(server).Notify)
+        for _, tag := range serverTags(server) {
+                add(config().TagRooms[tag])
+        }
+        return rooms
+}
+
+// commandTag assigns tags to a server, e.g. "!tag example.org corp bridge"
+func commandTag(args []string, actor id.UserID) string {
+        if len(args) < 2 {
+                return "Usage: !tag <server> <tag>..."
+        }
+        before := strings.Join(serverTags(args[0]), ", ")
+        defer func() {
+                recordAudit(actor.String(), "tag", strings.ToLower(args[0]), before, strings.Join(serverTags(args[0]), ", "))
+        }()
+        for _, tag := range args[1:] {
+                if err := addTag(args[0], tag); err != nil {
+                        return fmt.Sprintf("Failed to tag %s: %v", args[0], err)
+                }
+        }
+        return fmt.Sprintf("Tags of %s: %s", args[0], strings.Join(serverTags(args[0]), ", "))
+}
+
+// commandUntag removes runtime tags from a server, e.g. "!untag example.org corp"
+func commandUntag(args []string, actor id.UserID) string {
+        if len(args) < 2 {
+                return "Usage: !untag <server> <tag>..."
+        }
+        before := strings.Join(serverTags(args[0]), ", ")
+        defer func() {
+                recordAudit(actor.String(), "untag", strings.ToLower(args[0]), before, strings.Join(serverTags(args[0]), ", "))
+        }()
+        for _, tag := range args[1:] {
+                if err := removeTag(args[0], tag); err != nil {
+                        return fmt.Sprintf("Failed to untag %s: %v", args[0], err)
+                }
+        }
+        return fmt.Sprintf("Tags of %s: %s", args[0], strings.Join(serverTags(args[0]), ", "))
+}
+
+// commandStatus lists the latest result of every checked server, optionally only those with a tag,
+// e.g. "!status" or "!status tag:corp". In a tenant's log room only the tenant's servers are listed.
+func commandStatus(args []string, tenant string) string {
+        var tag string
+        for _, arg := range args {
+                if !strings.HasPrefix(arg, "tag:") {
+                        return "Usage: !status [tag:<tag>]"
+                }
+                tag = strings.TrimPrefix(arg, "tag:")
+        }
+
+        var visible map[string]bool
+        if tenant != "" {
+                visible = tenantServers(tenant)
+        }
+
+        resultsMu.RLock()
+        var lines []string
+        for server, result := range latestResults {
+                if tag != "" && !hasTag(server, tag) {
+                        continue
+                }
+                if visible != nil && !visible[server] {
+                        continue
+                }
+                line := formatServerLine(server, result.Status)
+                if tags := serverTags(server); len(tags) > 0 {
+                        line += fmt.Sprintf(" {%s}", strings.Join(tags, ", "))
+                }
+                lines = append(lines, line)
+        }
+        resultsMu.RUnlock()
+
+        if len(lines) == 0 {
+                return "No matching servers have been checked yet"
+        }
+        sort.Strings(lines)
+        return fmt.Sprintf("Server status (%d servers):\n%s", len(lines), reportLines(lines))
+}
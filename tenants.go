@@ -0,0 +1,126 @@
+package main
+
+import (
+        "crypto/subtle"
+        "net/http"
+        "sort"
+        "strings"
+        "sync"
+
+        "maunium.net/go/mautrix/id"
+)
+
+// TenantConfig groups monitored rooms of one community, with its own log room, API view and alert routing,
+// so one deployment can serve several communities without showing them each other's rooms
+type TenantConfig struct {
+        Rooms       []string          `yaml:"rooms"`        // Monitored room IDs or aliases of the tenant
+        LogRoom     string            `yaml:"logroom"`      // Log room receiving the reports of the tenant's rooms
+        APIToken    string            `yaml:"api_token"`    // Bearer token for the tenant's read-only view of the status page and checks
+        AlertLabels map[string]string `yaml:"alert_labels"` // Extra Alertmanager labels for the tenant's alerts, for routing
+}
+
+var (
+        roomServersMu  sync.RWMutex
+        roomServerList = make(map[id.RoomID][]string) // Servers of each monitored room in the latest cycle
+)
+
+// tenantOf returns the tenant a monitored room belongs to, or "" if none
+func tenantOf(roomID id.RoomID) string {
+        for name, tenant := range config().Tenants {
+                for _, room := range tenant.Rooms {
+                        if id.RoomID(room) == roomID {
+                                return name
+                        }
+                }
+        }
+        return ""
+}
+
+// tenantOfLogRoom returns the tenant whose log room this is, or "" for the shared log rooms
+func tenantOfLogRoom(roomID id.RoomID) string {
+        for name, tenant := range config().Tenants {
+                if tenant.LogRoom != "" && id.RoomID(tenant.LogRoom) == roomID {
+                        return name
+                }
+        }
+        return ""
+}
+
+// inTenant reports whether a room is visible to a tenant; every room is visible without a tenant
+func inTenant(roomID id.RoomID, tenant string) bool {
+        return tenant == "" || tenantOf(roomID) == tenant
+}
+
+// recordRoomServers remembers the servers of a monitored room, for the tenant views
+func recordRoomServers(roomID id.RoomID, servers []string) {
+        roomServersMu.Lock()
+        defer roomServersMu.Unlock()
+        roomServerList[roomID] = servers
+}
+
+// tenantServers returns the servers in a tenant's rooms as of the latest cycle
+func tenantServers(tenant string) map[string]bool {
+        servers := make(map[string]bool)
+        roomServersMu.RLock()
+        defer roomServersMu.RUnlock()
+        for _, room := range config().Tenants[tenant].Rooms {
+                for _, server := range roomServerList[id.RoomID(room)] {
+                        servers[server] = true
+                }
+        }
+        return servers
+}
+
+// isMonitored reports whether a server is in one of the monitored rooms or a static server
+func isMonitored(server string) bool {
+        roomServersMu.RLock()
+        for _, servers := range roomServerList {
+                for _, known := range servers {
+                        if known == server {
+                                roomServersMu.RUnlock()
+                                return true
+                        }
+                }
+        }
+        roomServersMu.RUnlock()
+        for _, static := range staticServers() {
+                if static == server {
+                        return true
+                }
+        }
+        return false
+}
+
+// requestTenant returns the tenant whose API token the request carries, or ""
+func requestTenant(r *http.Request) string {
+        token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+        if token == "" {
+                return ""
+        }
+        names := make([]string, 0, len(config().Tenants))
+        for name := range config().Tenants {
+                names = append(names, name)
+        }
+        sort.Strings(names)
+        for _, name := range names {
+                tenantToken := config().Tenants[name].APIToken
+                if tenantToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(tenantToken)) == 1 {
+                        return name
+                }
+        }
+        return ""
+}
+
+// tenantView wraps a handler that can show a single tenant's view: requests with a tenant token get that tenant's
+// view, all others need read access as usual and get the full view
+func tenantView(handler func(w http.ResponseWriter, r *http.Request, tenant string)) http.HandlerFunc {
+        return func(w http.ResponseWriter, r *http.Request) {
+                if tenant := requestTenant(r); tenant != "" {
+                        handler(w, r, tenant)
+                        return
+                }
+                readOnly(func(w http.ResponseWriter, r *http.Request) {
+                        handler(w, r, "")
+                })(w, r)
+        }
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "sort"
+        "strings"
+        "time"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/id"
+)
+
+// testAlertServer is the made-up server named in test notifications; .invalid can never be a real server
+const testAlertServer = "test-alert.matrix-health.invalid"
+
+// testAlertChannels are the notification channels a test can be sent through
+var testAlertChannels = []string{"logroom", "rooms", "webhook", "alertmanager"}
+
+// sendTestAlert sends a made-up failure and recovery through a notification channel, or all of them, the way real
+// ones are formatted and routed, and describes how each channel fared. Notifier filters are bypassed, so every
+// configured channel can be tried out.
+func sendTestAlert(ctx context.Context, client *mautrix.Client, channel string) string {
+        channels := testAlertChannels
+        if channel != "" && channel != "all" {
+                if !containsFold(testAlertChannels, channel) {
+                        return fmt.Sprintf("Unknown channel %q, expected one of: all, %s", channel, strings.Join(testAlertChannels, ", "))
+                }
+                channels = []string{strings.ToLower(channel)}
+        }
+
+        failure := "Failed (Test alert)"
+        room := "Test room (!test-alert:matrix-health.invalid)"
+        failedLine := formatServerLine(testAlertServer, failure)
+        recoveredLine := formatServerLine(testAlertServer, "OK") + " (recovered after 5m)"
+
+        var results []string
+        for _, channel := range channels {
+                var err error
+                switch channel {
+                case "logroom":
+                        err = sendReport(ctx, client, severityCritical, fmt.Sprintf("[TEST] Failed servers in room %s:\n%s", room, failedLine))
+                        if err == nil {
+                                err = sendReport(ctx, client, severityInfo, fmt.Sprintf("[TEST] Servers recovered in room %s:\n%s", room, recoveredLine))
+                        }
+                case "rooms":
+                        rooms := allNotifyRooms()
+                        if len(rooms) == 0 {
+                                results = append(results, "rooms: no notify or tag rooms configured")
+                                continue
+                        }
+                        for _, notify := range rooms {
+                                if err = sendMessageToRoom(ctx, client, notify, fmt.Sprintf("[TEST] %s (room %s)", failedLine, room)); err != nil {
+                                        break
+                                }
+                        }
+                case "webhook":
+                        if config().Webhooks.OutageURL == "" {
+                                results = append(results, "webhook: no outage_url configured")
+                                continue
+                        }
+                        started := time.Now().Add(-5 * time.Minute).Unix()
+                        for _, payload := range []map[string]interface{}{
+                                outagePayload(testAlertServer, "started", failure, started, 0),
+                                outagePayload(testAlertServer, "ended", "OK", started, time.Now().Unix()),
+                        } {
+                                payload["test"] = true
+                                if err = postWebhook(ctx, config().Webhooks.OutageURL, payload); err != nil {
+                                        break
+                                }
+                        }
+                case "alertmanager":
+                        if config().Alertmanager.URL == "" {
+                                results = append(results, "alertmanager: no url configured")
+                                continue
+                        }
+                        alert := newAlert(testAlertServer, "!test-alert:matrix-health.invalid", failure)
+                        alert.Labels["test"] = "true"
+                        if err = postAlerts(ctx, []amAlert{alert}); err == nil {
+                                resolved := time.Now()
+                                alert.EndsAt = &resolved
+                                err = postAlerts(ctx, []amAlert{alert})
+                        }
+                }
+                if err != nil {
+                        results = append(results, fmt.Sprintf("%s: failed: %v", channel, err))
+                } else {
+                        results = append(results, channel+": sent a test failure and recovery")
+                }
+        }
+        return fmt.Sprintf("Test notifications for %s:\n%s", testAlertServer, strings.Join(results, "\n"))
+}
+
+// allNotifyRooms returns every configured notify and tag room
+func allNotifyRooms() []id.RoomID {
+        seen := make(map[id.RoomID]bool)
+        for _, override := range config().Servers {
+                if override.Notify != "" {
+                        seen[id.RoomID(override.Notify)] = true
+                }
+        }
+        for _, room := range config().TagRooms {
+                if room != "" {
+                        seen[id.RoomID(room)] = true
+                }
+        }
+        rooms := make([]id.RoomID, 0, len(seen))
+        for room := range seen {
+                rooms = append(rooms, room)
+        }
+        sort.Slice(rooms, func(i, j int) bool { return rooms[i] < rooms[j] })
+        return rooms
+}
+
+// commandTestAlert sends test notifications, e.g. "!testalert webhook"
+func commandTestAlert(ctx context.Context, client *mautrix.Client, args []string, actor id.UserID) string {
+        if len(args) > 1 {
+                return "Usage: !testalert [all|" + strings.Join(testAlertChannels, "|") + "]"
+        }
+        channel := "all"
+        if len(args) == 1 {
+                channel = args[0]
+        }
+        recordAudit(actor.String(), "testalert", channel, "", "")
+        return sendTestAlert(ctx, client, channel)
+}
+
+// runNotifyTest sends test notifications from the command line, e.g. "matrix-health notify-test webhook"
+func runNotifyTest(ctx context.Context, client *mautrix.Client, args []string) error {
+        if len(args) > 1 {
+                return fmt.Errorf("usage: matrix-health notify-test [all|%s]", strings.Join(testAlertChannels, "|"))
+        }
+        channel := ""
+        if len(args) == 1 {
+                channel = args[0]
+        }
+        fmt.Println(sendTestAlert(ctx, client, channel))
+        return nil
+}
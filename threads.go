@@ -0,0 +1,115 @@
+package main
+
+import (
+        "context"
+        "database/sql"
+        "fmt"
+        "time"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/event"
+        "maunium.net/go/mautrix/id"
+)
+
+// alertThread is the thread about a server's failure in a monitored room's log room
+type alertThread struct {
+        LogRoom   id.RoomID
+        RootEvent id.EventID // The first failure, which the updates reply to
+        LastEvent id.EventID // The latest message of the thread, quoted by clients without thread support
+        Status    string     // The status last posted
+        Started   time.Time
+        Updated   time.Time
+}
+
+// loadAlertThread returns the open thread about a server in a room, if there is one
+func loadAlertThread(roomID id.RoomID, server string) (alertThread, bool) {
+        var thread alertThread
+        var started, updated int64
+        err := db.QueryRow(`SELECT log_room, root_event, last_event, status, started, updated FROM alert_threads WHERE room = ? AND server = ?`,
+                roomID.String(), server).Scan(&thread.LogRoom, &thread.RootEvent, &thread.LastEvent, &thread.Status, &started, &updated)
+        if err != nil {
+                if err != sql.ErrNoRows {
+                        fmt.Printf("Failed to load the alert thread of %s in room %s: %v\n", server, roomID, err)
+                }
+                return thread, false
+        }
+        thread.Started, thread.Updated = time.Unix(started, 0), time.Unix(updated, 0)
+        return thread, true
+}
+
+// saveAlertThread stores the open thread about a server in a room
+func saveAlertThread(roomID id.RoomID, server string, thread alertThread) {
+        if _, err := db.Exec(`INSERT INTO alert_threads (room, server, log_room, root_event, last_event, status, started, updated)
+                VALUES (?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (room, server) DO UPDATE SET last_event = excluded.last_event,
+                status = excluded.status, updated = excluded.updated`,
+                roomID.String(), server, thread.LogRoom.String(), thread.RootEvent.String(), thread.LastEvent.String(), thread.Status,
+                thread.Started.Unix(), thread.Updated.Unix()); err != nil {
+                fmt.Printf("Failed to save the alert thread of %s in room %s: %v\n", server, roomID, err)
+        }
+}
+
+// reportThreads posts each failing server of a room as its own message in the room's log room, with later updates
+// (a different failure, still down after thread_reminder seconds, recovered) as replies in that message's thread,
+// so every incident stays in one place
+func reportThreads(ctx context.Context, client *mautrix.Client, roomID id.RoomID, roomDescription string, servers []string, statuses map[string]string) {
+        for _, server := range servers {
+                status, ok := statuses[server]
+                if !ok {
+                        continue
+                }
+                // Keeps the reported states current, for summaries and recovery notes
+                statusChanged(server, status)
+
+                alerting := statusSeverity(status) != severityInfo
+                thread, open := loadAlertThread(roomID, server)
+                switch {
+                case alerting && !open:
+                        line := fmt.Sprintf("%s (room %s)", formatServerLine(server, status), roomDescription)
+                        target := roomReportTarget(roomID, statusSeverity(status))
+                        eventID, err := sendMessageContent(ctx, client, target, &event.MessageEventContent{MsgType: msgTypeFor(target), Body: line})
+                        if err != nil {
+                                fmt.Printf("Failed to post the alert about %s in room %s: %v\n", server, roomID, err)
+                                continue
+                        }
+                        now := time.Now()
+                        saveAlertThread(roomID, server, alertThread{LogRoom: target, RootEvent: eventID, LastEvent: eventID, Status: status, Started: now, Updated: now})
+
+                case alerting && status != thread.Status:
+                        replyToThread(ctx, client, roomID, server, thread, status, "Now "+formatServerLine(server, status))
+
+                case alerting && config().ThreadReminder > 0 && time.Since(thread.Updated) >= time.Duration(config().ThreadReminder)*time.Second:
+                        state := "down"
+                        if statusClass(status) == "Warning" {
+                                state = "degraded"
+                        }
+                        replyToThread(ctx, client, roomID, server, thread, status,
+                                fmt.Sprintf("Still %s after %s: %s", state, formatDowntime(time.Since(thread.Started)), formatServerLine(server, status)))
+
+                case !alerting && open:
+                        message := "Now " + formatServerLine(server, status)
+                        if statusClass(status) == "OK" {
+                                message = "Recovered: " + newReportRow(server, status, recoveryNote(server)).Line
+                        }
+                        if replyToThread(ctx, client, roomID, server, thread, status, message) {
+                                if _, err := db.Exec(`DELETE FROM alert_threads WHERE room = ? AND server = ?`, roomID.String(), server); err != nil {
+                                        fmt.Printf("Failed to close the alert thread of %s in room %s: %v\n", server, roomID, err)
+                                }
+                        }
+                }
+        }
+}
+
+// replyToThread posts an update to a server's alert thread (MSC3440), replying to its latest message as a fallback
+// for clients without threads, and reports whether it was sent
+func replyToThread(ctx context.Context, client *mautrix.Client, roomID id.RoomID, server string, thread alertThread, status, message string) bool {
+        content := &event.MessageEventContent{MsgType: msgTypeFor(thread.LogRoom), Body: message}
+        content.RelatesTo = (&event.RelatesTo{}).SetThread(thread.RootEvent, thread.LastEvent)
+        eventID, err := sendMessageContent(ctx, client, thread.LogRoom, content)
+        if err != nil {
+                fmt.Printf("Failed to update the alert thread of %s in room %s: %v\n", server, roomID, err)
+                return false
+        }
+        thread.LastEvent, thread.Status, thread.Updated = eventID, status, time.Now()
+        saveAlertThread(roomID, server, thread)
+        return true
+}
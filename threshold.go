@@ -0,0 +1,50 @@
+package main
+
+import (
+        "fmt"
+        "strings"
+        "sync"
+)
+
+// Consecutive failed checks of each server, to hold back brief failures below the failure threshold
+var (
+        failStreakMu sync.Mutex
+        failStreaks  = make(map[string]int)
+)
+
+// failureThreshold returns how many checks in a row a server must fail before it is reported as down
+func failureThreshold(server string) int {
+        if threshold := serverOverride(server).FailureThreshold; threshold > 0 {
+                return threshold
+        }
+        if config().FailureThreshold > 0 {
+                return config().FailureThreshold
+        }
+        return 1
+}
+
+// confirmFailure counts a server's failed checks in a row and holds back a failure until the server reaches its
+// failure threshold: until then the server keeps the status it had before. Any other result ends the run.
+func confirmFailure(server, status string) string {
+        failStreakMu.Lock()
+        if !strings.HasPrefix(status, "Failed") {
+                delete(failStreaks, server)
+                failStreakMu.Unlock()
+                return status
+        }
+        failStreaks[server]++
+        failures := failStreaks[server]
+        failStreakMu.Unlock()
+
+        threshold := failureThreshold(server)
+        if failures >= threshold {
+                return status
+        }
+        previous, ok := latestResult(server)
+        if !ok || strings.HasPrefix(previous.Status, "Failed") {
+                // Nothing to keep: a server never seen answering is reported right away
+                return status
+        }
+        fmt.Printf("Server %s failed %d of %d checks before it is reported: %s\n", server, failures, threshold, status)
+        return previous.Status
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+        "fmt"
+        "strconv"
+        "strings"
+)
+
+// decodeTOML parses the subset of TOML used for configuration files into a generic map:
+// tables, arrays of tables, dotted and quoted keys, strings, integers, floats, booleans,
+// arrays and inline tables. Dates and multi-line strings are not supported.
+func decodeTOML(data string) (map[string]interface{}, error) {
+        root := make(map[string]interface{})
+        current := root
+        p := &tomlParser{data: data, line: 1}
+
+        for {
+                p.skipSpaceAndComments(true)
+                if p.eof() {
+                        return root, nil
+                }
+
+                // Table headers: [table] and [[array of tables]]
+                if p.peek() == '[' {
+                        array := strings.HasPrefix(p.data[p.pos:], "[[")
+                        if array {
+                                p.pos += 2
+                        } else {
+                                p.pos++
+                        }
+                        keys, err := p.parseKey()
+                        if err != nil {
+                                return nil, err
+                        }
+                        closing := "]"
+                        if array {
+                                closing = "]]"
+                        }
+                        p.skipSpace()
+                        if !strings.HasPrefix(p.data[p.pos:], closing) {
+                                return nil, p.errorf("expected %s", closing)
+                        }
+                        p.pos += len(closing)
+
+                        if current, err = tomlTable(root, keys, array); err != nil {
+                                return nil, p.errorf("%v", err)
+                        }
+                        if err := p.endOfLine(); err != nil {
+                                return nil, err
+                        }
+                        continue
+                }
+
+                // key = value
+                keys, err := p.parseKey()
+                if err != nil {
+                        return nil, err
+                }
+                p.skipSpace()
+                if p.eof() || p.peek() != '=' {
+                        return nil, p.errorf("expected =")
+                }
+                p.pos++
+                value, err := p.parseValue()
+                if err != nil {
+                        return nil, err
+                }
+                if err := tomlSet(current, keys, value); err != nil {
+                        return nil, p.errorf("%v", err)
+                }
+                if err := p.endOfLine(); err != nil {
+                        return nil, err
+                }
+        }
+}
+
+// tomlTable returns the table a header refers to, creating it (or a new array element) as needed
+func tomlTable(root map[string]interface{}, keys []string, array bool) (map[string]interface{}, error) {
+        table := root
+        for i, key := range keys {
+                last := i == len(keys)-1
+                switch existing := table[key].(type) {
+                case nil:
+                        if last && array {
+                                element := make(map[string]interface{})
+                                table[key] = []interface{}{element}
+                                return element, nil
+                        }
+                        child := make(map[string]interface{})
+                        table[key] = child
+                        table = child
+                case map[string]interface{}:
+                        if last && array {
+                                return nil, fmt.Errorf("%s is a table, not an array of tables", key)
+                        }
+                        table = existing
+                case []interface{}:
+                        if last && array {
+                                element := make(map[string]interface{})
+                                table[key] = append(existing, element)
+                                return element, nil
+                        }
+                        element, ok := existing[len(existing)-1].(map[string]interface{})
+                        if !ok {
+                                return nil, fmt.Errorf("%s is not a table", key)
+                        }
+                        table = element
+                default:
+                        return nil, fmt.Errorf("%s is not a table", key)
+                }
+        }
+        return table, nil
+}
+
+// tomlSet assigns a value to a (possibly dotted) key inside a table
+func tomlSet(table map[string]interface{}, keys []string, value interface{}) error {
+        for _, key := range keys[:len(keys)-1] {
+                child, ok := table[key].(map[string]interface{})
+                if !ok {
+                        if table[key] != nil {
+                                return fmt.Errorf("%s is not a table", key)
+                        }
+                        child = make(map[string]interface{})
+                        table[key] = child
+                }
+                table = child
+        }
+        key := keys[len(keys)-1]
+        if _, exists := table[key]; exists {
+                return fmt.Errorf("duplicate key %s", key)
+        }
+        table[key] = value
+        return nil
+}
+
+// tomlParser is a cursor over a TOML document
+type tomlParser struct {
+        data string
+        pos  int
+        line int
+}
+
+func (p *tomlParser) eof() bool  { return p.pos >= len(p.data) }
+func (p *tomlParser) peek() byte { return p.data[p.pos] }
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+        return fmt.Errorf("toml line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// skipSpace skips spaces and tabs on the current line
+func (p *tomlParser) skipSpace() {
+        for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+                p.pos++
+        }
+}
+
+// skipSpaceAndComments skips whitespace and comments, including newlines if requested
+func (p *tomlParser) skipSpaceAndComments(newlines bool) {
+        for !p.eof() {
+                switch c := p.peek(); {
+                case c == ' ' || c == '\t' || c == '\r':
+                        p.pos++
+                case c == '\n' && newlines:
+                        p.pos++
+                        p.line++
+                case c == '#':
+                        for !p.eof() && p.peek() != '\n' {
+                                p.pos++
+                        }
+                default:
+                        return
+                }
+        }
+}
+
+// endOfLine requires that only whitespace or a comment follows on the current line
+func (p *tomlParser) endOfLine() error {
+        p.skipSpaceAndComments(false)
+        if p.eof() {
+                return nil
+        }
+        if p.peek() != '\n' {
+                return p.errorf("unexpected %q", p.peek())
+        }
+        return nil
+}
+
+// parseKey parses a bare, quoted or dotted key into its parts
+func (p *tomlParser) parseKey() ([]string, error) {
+        var keys []string
+        for {
+                p.skipSpace()
+                if p.eof() {
+                        return nil, p.errorf("expected key")
+                }
+                var key string
+                if c := p.peek(); c == '"' || c == '\'' {
+                        value, err := p.parseString()
+                        if err != nil {
+                                return nil, err
+                        }
+                        key = value
+                } else {
+                        start := p.pos
+                        for !p.eof() {
+                                c := p.peek()
+                                if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+                                        break
+                                }
+                                p.pos++
+                        }
+                        if start == p.pos {
+                                return nil, p.errorf("expected key")
+                        }
+                        key = p.data[start:p.pos]
+                }
+                keys = append(keys, key)
+
+                p.skipSpace()
+                if p.eof() || p.peek() != '.' {
+                        return keys, nil
+                }
+                p.pos++
+        }
+}
+
+// parseValue parses any value
+func (p *tomlParser) parseValue() (interface{}, error) {
+        p.skipSpace()
+        if p.eof() {
+                return nil, p.errorf("expected value")
+        }
+
+        switch c := p.peek(); {
+        case c == '"' || c == '\'':
+                return p.parseString()
+        case c == '[':
+                return p.parseArray()
+        case c == '{':
+                return p.parseInlineTable()
+        }
+
+        // Booleans and numbers run until a delimiter
+        start := p.pos
+        for !p.eof() && !strings.ContainsRune(" \t\r\n,]}#", rune(p.peek())) {
+                p.pos++
+        }
+        token := p.data[start:p.pos]
+        switch token {
+        case "true":
+                return true, nil
+        case "false":
+                return false, nil
+        }
+        number := strings.ReplaceAll(token, "_", "")
+        if n, err := strconv.ParseInt(number, 0, 64); err == nil {
+                return n, nil
+        }
+        if f, err := strconv.ParseFloat(number, 64); err == nil {
+                return f, nil
+        }
+        return nil, p.errorf("unsupported value %q", token)
+}
+
+// parseString parses a basic ("...") or literal ('...') string
+func (p *tomlParser) parseString() (string, error) {
+        quote := p.peek()
+        p.pos++
+        var b strings.Builder
+        for !p.eof() {
+                c := p.peek()
+                switch {
+                case c == quote:
+                        p.pos++
+                        return b.String(), nil
+                case c == '\n':
+                        return "", p.errorf("unterminated string")
+                case c == '\\' && quote == '"':
+                        if p.pos+1 >= len(p.data) {
+                                return "", p.errorf("unterminated string")
+                        }
+                        escape := p.data[p.pos+1]
+                        p.pos += 2
+                        switch escape {
+                        case 'n':
+                                b.WriteByte('\n')
+                        case 't':
+                                b.WriteByte('\t')
+                        case 'r':
+                                b.WriteByte('\r')
+                        case '"', '\\':
+                                b.WriteByte(escape)
+                        case 'u', 'U':
+                                size := 4
+                                if escape == 'U' {
+                                        size = 8
+                                }
+                                if p.pos+size > len(p.data) {
+                                        return "", p.errorf("invalid unicode escape")
+                                }
+                                code, err := strconv.ParseUint(p.data[p.pos:p.pos+size], 16, 32)
+                                if err != nil {
+                                        return "", p.errorf("invalid unicode escape")
+                                }
+                                b.WriteRune(rune(code))
+                                p.pos += size
+                        default:
+                                return "", p.errorf("invalid escape \\%c", escape)
+                        }
+                default:
+                        b.WriteByte(c)
+                        p.pos++
+                }
+        }
+        return "", p.errorf("unterminated string")
+}
+
+// parseArray parses an array, which may span multiple lines
+func (p *tomlParser) parseArray() ([]interface{}, error) {
+        p.pos++
+        values := []interface{}{}
+        for {
+                p.skipSpaceAndComments(true)
+                if p.eof() {
+                        return nil, p.errorf("unterminated array")
+                }
+                if p.peek() == ']' {
+                        p.pos++
+                        return values, nil
+                }
+                value, err := p.parseValue()
+                if err != nil {
+                        return nil, err
+                }
+                values = append(values, value)
+                p.skipSpaceAndComments(true)
+                if !p.eof() && p.peek() == ',' {
+                        p.pos++
+                }
+        }
+}
+
+// parseInlineTable parses an inline table like { key = "value", other = 1 }
+func (p *tomlParser) parseInlineTable() (map[string]interface{}, error) {
+        p.pos++
+        table := make(map[string]interface{})
+        for {
+                p.skipSpace()
+                if p.eof() {
+                        return nil, p.errorf("unterminated inline table")
+                }
+                if p.peek() == '}' {
+                        p.pos++
+                        return table, nil
+                }
+                keys, err := p.parseKey()
+                if err != nil {
+                        return nil, err
+                }
+                p.skipSpace()
+                if p.eof() || p.peek() != '=' {
+                        return nil, p.errorf("expected =")
+                }
+                p.pos++
+                value, err := p.parseValue()
+                if err != nil {
+                        return nil, err
+                }
+                if err := tomlSet(table, keys, value); err != nil {
+                        return nil, p.errorf("%v", err)
+                }
+                p.skipSpace()
+                if !p.eof() && p.peek() == ',' {
+                        p.pos++
+                }
+        }
+}
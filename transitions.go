@@ -0,0 +1,183 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "sort"
+        "strconv"
+        "strings"
+        "sync"
+        "time"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/id"
+)
+
+// Report modes: full posts every room's failures (or an all-OK message) each cycle,
+// transitions only posts servers whose status changed since it was last reported,
+// diff posts each room's changes since the previous cycle, including servers joining and leaving,
+// live keeps one status message per room and edits it each cycle, threads posts each failing server as a message
+// with its updates in a thread
+const (
+        reportModeFull        = "full"
+        reportModeTransitions = "transitions"
+        reportModeDiff        = "diff"
+        reportModeLive        = "live"
+        reportModeThreads     = "threads"
+)
+
+// reportMode returns the configured report mode
+func reportMode() string {
+        if config().ReportMode == "" {
+                return reportModeFull
+        }
+        return config().ReportMode
+}
+
+// validateReportMode checks that report_mode is known
+func validateReportMode(c *Config) error {
+        switch c.ReportMode {
+        case "", reportModeFull, reportModeTransitions, reportModeDiff, reportModeLive, reportModeThreads:
+                return nil
+        }
+        return fmt.Errorf("unknown report_mode %q (expected full, transitions, diff, live or threads)", c.ReportMode)
+}
+
+var (
+        reportedMu     sync.Mutex
+        reportedStatus = make(map[string]string) // Status class (OK, Failed, Warning, Maintenance) last reported per server
+        pendingStatus  = make(map[string]string) // Status classes reported this cycle, saved when it ends
+)
+
+// statusClass returns the first word of a status, e.g. "Failed" for "Failed (Unreachable)"
+func statusClass(status string) string {
+        if fields := strings.Fields(status); len(fields) > 0 {
+                return fields[0]
+        }
+        return ""
+}
+
+// loadReportedStates loads the last reported status of every server from the store
+func loadReportedStates() error {
+        rows, err := db.Query(`SELECT server, status FROM server_state`)
+        if err != nil {
+                return err
+        }
+        defer rows.Close()
+
+        reportedMu.Lock()
+        defer reportedMu.Unlock()
+        for rows.Next() {
+                var server, status string
+                if err := rows.Scan(&server, &status); err != nil {
+                        return err
+                }
+                reportedStatus[server] = status
+        }
+        return rows.Err()
+}
+
+// statusChanged reports whether a server's status class differs from the one last reported, and returns that one.
+// Servers seen for the first time count as previously OK, so only new failures are reported for them.
+func statusChanged(server, status string) (bool, string) {
+        reportedMu.Lock()
+        defer reportedMu.Unlock()
+        previous, ok := reportedStatus[server]
+        if !ok {
+                previous = "OK"
+        }
+        class := statusClass(status)
+        pendingStatus[server] = class
+        return class != previous, previous
+}
+
+// saveReportedStates stores the status classes reported this cycle, so transitions survive restarts
+func saveReportedStates() {
+        reportedMu.Lock()
+        defer reportedMu.Unlock()
+        now := time.Now().Unix()
+        for server, class := range pendingStatus {
+                if reportedStatus[server] == class {
+                        continue
+                }
+                if _, err := db.Exec(`INSERT INTO server_state (server, status, changed) VALUES (?, ?, ?)
+                        ON CONFLICT (server) DO UPDATE SET status = excluded.status, changed = excluded.changed`,
+                        server, class, now); err != nil {
+                        fmt.Printf("Failed to save the state of %s: %v\n", server, err)
+                        continue
+                }
+                reportedStatus[server] = class
+        }
+        pendingStatus = make(map[string]string)
+}
+
+// reportTransitions posts the servers of a room whose status changed since the last report, grouped by their new status
+func reportTransitions(ctx context.Context, client *mautrix.Client, roomID id.RoomID, roomDescription string, servers []string, statuses map[string]string) {
+        changed := make(map[string][]reportRow)
+        for _, server := range servers {
+                status, ok := statuses[server]
+                if !ok {
+                        continue
+                }
+                if isChanged, previous := statusChanged(server, status); isChanged {
+                        class := statusClass(status)
+                        was := previous
+                        if class == "OK" && previous == "Failed" {
+                                if note := recoveryNote(server); note != "" {
+                                        was += ", " + note
+                                }
+                        }
+                        changed[class] = append(changed[class], newReportRow(server, status, "was "+was))
+                }
+        }
+
+        if rows, providerNote := groupProviderFailures(ctx, client, changed["Failed"]); len(rows) > 0 || providerNote != "" {
+                sendRoomReportRows(ctx, client, roomID, severityCritical, fmt.Sprintf("Servers now failing in room %s", roomDescription), rows, providerNote)
+        }
+        if rows := changed["Warning"]; len(rows) > 0 {
+                sendRoomReportRows(ctx, client, roomID, severityWarning, fmt.Sprintf("Servers now with warnings in room %s", roomDescription), rows, "")
+        }
+        if rows := changed["Maintenance"]; len(rows) > 0 {
+                sendRoomReportRows(ctx, client, roomID, severityInfo, fmt.Sprintf("Servers now in maintenance in room %s", roomDescription), rows, "")
+        }
+        if rows := changed["OK"]; len(rows) > 0 {
+                sendRoomReportRows(ctx, client, roomID, severityInfo, fmt.Sprintf("Servers recovered in room %s", roomDescription), rows, "")
+        }
+}
+
+// sendSummary posts an overview of every checked server when the summary interval has passed,
+// as a sign of life in transitions mode, where a healthy federation stays silent
+func sendSummary(ctx context.Context, client *mautrix.Client) {
+        if config().SummaryInterval <= 0 {
+                return
+        }
+        now := time.Now()
+        last, err := strconv.ParseInt(loadState("summary_last"), 10, 64)
+        if err == nil && now.Sub(time.Unix(last, 0)) < time.Duration(config().SummaryInterval)*time.Second {
+                return
+        }
+        if err := saveState("summary_last", strconv.FormatInt(now.Unix(), 10)); err != nil {
+                fmt.Println("Failed to save the summary time:", err)
+        }
+
+        counts := make(map[string]int)
+        var problems []string
+        resultsMu.RLock()
+        total := len(latestResults)
+        for server, result := range latestResults {
+                class := statusClass(result.Status)
+                counts[class]++
+                if class != "OK" {
+                        problems = append(problems, withImpact(formatServerLine(server, result.Status), server))
+                }
+        }
+        resultsMu.RUnlock()
+        sort.Strings(problems)
+
+        message := fmt.Sprintf("Summary: %d servers checked, %d OK, %d failed, %d with warnings, %d in maintenance",
+                total, counts["OK"], counts["Failed"], counts["Warning"], counts["Maintenance"])
+        if len(problems) > 0 {
+                message += "\n" + reportLines(problems)
+        }
+        sendReport(ctx, client, severityInfo, message)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "math"
+        "sync"
+        "time"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/id"
+)
+
+// TrendConfig controls room membership trend reporting
+type TrendConfig struct {
+        Window    int     `yaml:"window"`    // Hours to compare against, defaults to a week
+        Threshold float64 `yaml:"threshold"` // Change in percent that is reported, defaults to 30
+}
+
+var (
+        trendAlertsMu sync.Mutex
+        trendAlerts   = make(map[id.RoomID]time.Time) // When each room's trend was last reported
+)
+
+// trackRoomTrend records a room's member and server counts and reports significant changes over the trend window
+func trackRoomTrend(ctx context.Context, client *mautrix.Client, roomID id.RoomID, roomDescription string, members, servers int) {
+        window := time.Duration(config().Trends.Window) * time.Hour
+        if window <= 0 {
+                window = 7 * 24 * time.Hour
+        }
+        threshold := config().Trends.Threshold
+        if threshold <= 0 {
+                threshold = 30
+        }
+
+        now := time.Now()
+        if _, err := db.Exec(`INSERT INTO room_stats (room_id, ts, members, servers) VALUES (?, ?, ?, ?)`,
+                roomID.String(), now.Unix(), members, servers); err != nil {
+                fmt.Printf("Failed to record statistics for room %s: %v\n", roomID, err)
+                return
+        }
+
+        // Samples older than twice the window are never compared against again
+        db.Exec(`DELETE FROM room_stats WHERE room_id = ? AND ts < ?`, roomID.String(), now.Add(-2*window).Unix())
+
+        // Compare against the oldest sample inside the window
+        var oldMembers, oldServers int
+        var oldTs int64
+        err := db.QueryRow(`SELECT ts, members, servers FROM room_stats WHERE room_id = ? AND ts >= ? ORDER BY ts ASC LIMIT 1`,
+                roomID.String(), now.Add(-window).Unix()).Scan(&oldTs, &oldMembers, &oldServers)
+        if err != nil || oldTs == now.Unix() {
+                return
+        }
+
+        memberChange := percentChange(oldMembers, members)
+        serverChange := percentChange(oldServers, servers)
+        if math.Abs(memberChange) < threshold && math.Abs(serverChange) < threshold {
+                return
+        }
+
+        // Report each room at most once per day
+        trendAlertsMu.Lock()
+        if last, ok := trendAlerts[roomID]; ok && now.Sub(last) < 24*time.Hour {
+                trendAlertsMu.Unlock()
+                return
+        }
+        trendAlerts[roomID] = now
+        trendAlertsMu.Unlock()
+
+        since := time.Unix(oldTs, 0).Format("2006-01-02 15:04")
+        message := fmt.Sprintf("Membership trend in room %s since %s: servers %d -> %d (%+.0f%%), members %d -> %d (%+.0f%%)",
+                roomDescription, since, oldServers, servers, serverChange, oldMembers, members, memberChange)
+        fmt.Println(message)
+        sendRoomReport(ctx, client, roomID, severityWarning, message)
+}
+
+// percentChange returns the change from old to current in percent
+func percentChange(old, current int) float64 {
+        if old == 0 {
+                return 0
+        }
+        return 100 * float64(current-old) / float64(old)
+}
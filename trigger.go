@@ -0,0 +1,87 @@
+package main
+
+import (
+        "context"
+        "encoding/json"
+        "fmt"
+        "net/http"
+        "strings"
+
+        "maunium.net/go/mautrix"
+        "maunium.net/go/mautrix/id"
+)
+
+// triggerRequest asks for an immediate check of a server or a room
+type triggerRequest struct {
+        Server string `json:"server"`
+        Room   string `json:"room"` // Room ID or alias
+}
+
+// triggerQueue holds checks requested through the API until the worker gets to them
+var triggerQueue = make(chan triggerRequest, 100)
+
+// handleTrigger queues a check requested by an external system, e.g. a CI pipeline after a deployment:
+// POST /api/v1/trigger with {"server": "example.org"} or {"room": "#room:example.org"}
+func handleTrigger(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+                http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+                return
+        }
+        if !authorized(r) {
+                http.Error(w, "unauthorized", http.StatusUnauthorized)
+                return
+        }
+
+        var req triggerRequest
+        if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+                http.Error(w, "invalid JSON body", http.StatusBadRequest)
+                return
+        }
+        if (req.Server == "") == (req.Room == "") {
+                http.Error(w, "expected either server or room", http.StatusBadRequest)
+                return
+        }
+
+        select {
+        case triggerQueue <- req:
+                w.WriteHeader(http.StatusAccepted)
+                writeJSON(w, map[string]bool{"queued": true})
+        default:
+                http.Error(w, "too many queued checks", http.StatusServiceUnavailable)
+        }
+}
+
+// runTriggeredChecks works through the queued checks, reporting each result to the log rooms
+func runTriggeredChecks(ctx context.Context, client *mautrix.Client) {
+        for {
+                select {
+                case <-ctx.Done():
+                        return
+                case req := <-triggerQueue:
+                        if req.Server != "" {
+                                server := strings.ToLower(req.Server)
+                                status := checkServer(ctx, client, server)
+                                sev := severityInfo
+                                switch {
+                                case strings.HasPrefix(status, "Failed"):
+                                        sev = severityCritical
+                                case strings.HasPrefix(status, "Warning"):
+                                        sev = severityWarning
+                                }
+                                sendReport(ctx, client, sev, fmt.Sprintf("Triggered check: %s", formatServerLine(server, status)))
+                                continue
+                        }
+
+                        roomID := id.RoomID(req.Room)
+                        if strings.HasPrefix(req.Room, "#") {
+                                resp, err := client.ResolveAlias(ctx, id.RoomAlias(req.Room))
+                                if err != nil {
+                                        fmt.Printf("Failed to resolve triggered room %s: %v\n", req.Room, err)
+                                        continue
+                                }
+                                roomID = resp.RoomID
+                        }
+                        sendRoomReport(ctx, client, roomID, severityInfo, "Triggered check: "+roomReport(ctx, client, roomID))
+                }
+        }
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+        "fmt"
+        "strings"
+        "time"
+)
+
+// uptimeWindows are the periods uptime is computed over
+var uptimeWindows = []struct {
+        name   string
+        period time.Duration
+}{
+        {"24h", 24 * time.Hour},
+        {"7d", 7 * 24 * time.Hour},
+        {"30d", 30 * 24 * time.Hour},
+}
+
+// serverUptime returns the percentage of a server's checks over a period in which it answered, from the check
+// history, and the number of checks. Checks inside maintenance windows are left out.
+func serverUptime(server string, period time.Duration) (float64, int, error) {
+        var checks, up int
+        err := db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(CASE WHEN status != 'Failed' THEN 1 ELSE 0 END), 0) FROM checks
+                WHERE server = ? AND ts >= ? AND status != 'Maintenance'`, server, time.Now().Add(-period).Unix()).Scan(&checks, &up)
+        if err != nil || checks == 0 {
+                return 0, checks, err
+        }
+        return 100 * float64(up) / float64(checks), checks, nil
+}
+
+// uptimeSummary describes a server's uptime over each window, e.g. "uptime 98.20% (24h), 99.61% (7d), 99.87% (30d)",
+// or returns "" if it has no checks
+func uptimeSummary(server string) string {
+        var parts []string
+        for _, window := range uptimeWindows {
+                uptime, checks, err := serverUptime(server, window.period)
+                if err != nil || checks == 0 {
+                        continue
+                }
+                parts = append(parts, fmt.Sprintf("%.2f%% (%s)", uptime, window.name))
+        }
+        if len(parts) == 0 {
+                return ""
+        }
+        return "uptime " + strings.Join(parts, ", ")
+}
+
+// commandUptime describes a server's uptime over the last 24 hours, 7 days and 30 days, against the SLA target
+// if one is configured, e.g. "!health uptime example.org"
+func commandUptime(args []string, tenant string) string {
+        if len(args) != 1 {
+                return "Usage: !health uptime <server>"
+        }
+        server := strings.ToLower(args[0])
+        if tenant != "" && !tenantServers(tenant)[server] {
+                return fmt.Sprintf("%s is not monitored for %s", server, tenant)
+        }
+
+        var lines []string
+        for _, window := range uptimeWindows {
+                uptime, checks, err := serverUptime(server, window.period)
+                switch {
+                case err != nil:
+                        return fmt.Sprintf("Failed to read the check history of %s: %v", server, err)
+                case checks == 0:
+                        lines = append(lines, fmt.Sprintf("%s: no checks", window.name))
+                        continue
+                }
+                line := fmt.Sprintf("%s: %.2f%% over %d checks", window.name, uptime, checks)
+                if target := config().SLATarget; target > 0 && uptime < target {
+                        line += fmt.Sprintf(", below the %g%% SLA", target)
+                }
+                lines = append(lines, line)
+        }
+        if retention := historyRetention(); retention < uptimeWindows[len(uptimeWindows)-1].period {
+                lines = append(lines, fmt.Sprintf("The history only keeps %d days", int(retention.Hours()/24)))
+        }
+        return fmt.Sprintf("Uptime of %s (maintenance excluded):\n%s", server, strings.Join(lines, "\n"))
+}
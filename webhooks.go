@@ -0,0 +1,171 @@
+package main
+
+import (
+        "bytes"
+        "context"
+        "crypto/ed25519"
+        "crypto/rand"
+        "encoding/base64"
+        "encoding/json"
+        "fmt"
+        "net/http"
+        "os"
+        "strings"
+        "time"
+)
+
+// WebhooksConfig sends outage events to a webhook and signs the bot's webhook payloads the way Matrix servers sign
+// federation requests, so receivers can check them against the key published on the API
+type WebhooksConfig struct {
+        OutageURL      string       `yaml:"outage_url"`       // URL receiving a JSON event when a server's outage starts or ends
+        OutageFilter   NotifyFilter `yaml:"outage_filter"`    // Outages sent to the outage URL, all if empty
+        SigningKeyFile string       `yaml:"signing_key_file"` // File with the ed25519 signing key, created if missing; empty disables signing
+        KeyID          string       `yaml:"key_id"`           // Key version in signatures, e.g. ed25519:1; defaults to 1
+        Origin         string       `yaml:"origin"`           // Name the payloads are signed as, defaults to the bot's homeserver name
+}
+
+// webhookKey signs webhook payloads when signing is enabled
+var webhookKey ed25519.PrivateKey
+
+// initWebhookSigning loads the signing key, creating it on first use
+func initWebhookSigning() error {
+        path := config().Webhooks.SigningKeyFile
+        if path == "" {
+                return nil
+        }
+        data, err := os.ReadFile(path)
+        if os.IsNotExist(err) {
+                seed := make([]byte, ed25519.SeedSize)
+                if _, err := rand.Read(seed); err != nil {
+                        return err
+                }
+                if err := os.WriteFile(path, []byte(base64.RawStdEncoding.EncodeToString(seed)+"\n"), 0600); err != nil {
+                        return err
+                }
+                fmt.Printf("Created the webhook signing key in %s\n", path)
+                data, err = os.ReadFile(path)
+        }
+        if err != nil {
+                return err
+        }
+        seed, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(strings.TrimSpace(string(data)), "="))
+        if err != nil || len(seed) != ed25519.SeedSize {
+                return fmt.Errorf("%s doesn't hold a base64 ed25519 seed", path)
+        }
+        webhookKey = ed25519.NewKeyFromSeed(seed)
+        return nil
+}
+
+// webhookKeyID returns the ID of the signing key, e.g. "ed25519:1"
+func webhookKeyID() string {
+        version := config().Webhooks.KeyID
+        if version == "" {
+                version = "1"
+        }
+        return "ed25519:" + version
+}
+
+// webhookOrigin returns the name payloads are signed as
+func webhookOrigin() string {
+        if config().Webhooks.Origin != "" {
+                return config().Webhooks.Origin
+        }
+        return extractDomain(config().Username)
+}
+
+// signPayload adds Matrix-style signatures to a payload: the ed25519 signature of its canonical JSON,
+// without signatures and unsigned, under signatures.<origin>.<key ID>
+func signPayload(payload map[string]interface{}) error {
+        if webhookKey == nil {
+                return nil
+        }
+        delete(payload, "signatures")
+        unsigned, hasUnsigned := payload["unsigned"]
+        delete(payload, "unsigned")
+        message, err := canonicalJSON(payload)
+        if err != nil {
+                return err
+        }
+        signature := base64.RawStdEncoding.EncodeToString(ed25519.Sign(webhookKey, message))
+        payload["signatures"] = map[string]map[string]string{webhookOrigin(): {webhookKeyID(): signature}}
+        if hasUnsigned {
+                payload["unsigned"] = unsigned
+        }
+        return nil
+}
+
+// postWebhook sends a signed JSON payload to a webhook
+func postWebhook(ctx context.Context, url string, payload map[string]interface{}) error {
+        if err := signPayload(payload); err != nil {
+                return err
+        }
+        body, err := json.Marshal(payload)
+        if err != nil {
+                return err
+        }
+        ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+        defer cancel()
+        req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+        if err != nil {
+                return err
+        }
+        req.Header.Set("Content-Type", "application/json")
+        resp, err := http.DefaultClient.Do(req)
+        if err != nil {
+                return err
+        }
+        resp.Body.Close()
+        if resp.StatusCode >= 300 {
+                return fmt.Errorf("webhook returned %s", resp.Status)
+        }
+        return nil
+}
+
+// notifyOutage posts an outage starting or ending to the outage webhook in the background.
+// Outages are critical, also when they end, so the webhook gets both ends of those its filter lets through.
+func notifyOutage(server, room, event, status string, started, ended int64) {
+        if config().Webhooks.OutageURL == "" || !config().Webhooks.OutageFilter.matches(server, room, severityCritical) {
+                return
+        }
+        payload := outagePayload(server, event, status, started, ended)
+        go func() {
+                if err := postWebhook(context.Background(), config().Webhooks.OutageURL, payload); err != nil {
+                        fmt.Printf("Failed to send the outage of %s to the webhook: %v\n", server, err)
+                }
+        }()
+}
+
+// outagePayload builds the outage webhook's event for an outage starting or ending
+func outagePayload(server, event, status string, started, ended int64) map[string]interface{} {
+        payload := map[string]interface{}{
+                "type":    "outage_" + event,
+                "server":  server,
+                "status":  status,
+                "started": started,
+                "origin":  webhookOrigin(),
+                "ts":      time.Now().UnixMilli(),
+        }
+        if ended != 0 {
+                payload["ended"] = ended
+        }
+        if impact := serverImpact(server); impact != "" {
+                payload["impact"] = impact
+        }
+        return payload
+}
+
+// handleWebhookKey publishes the webhook signing key in the shape of /_matrix/key/v2/server,
+// e.g. GET /_matrix-health/key/v1
+func handleWebhookKey(w http.ResponseWriter, r *http.Request) {
+        if webhookKey == nil {
+                http.NotFound(w, r)
+                return
+        }
+        publicKey := webhookKey.Public().(ed25519.PublicKey)
+        writeJSON(w, map[string]interface{}{
+                "server_name": webhookOrigin(),
+                "verify_keys": map[string]map[string]string{
+                        webhookKeyID(): {"key": base64.RawStdEncoding.EncodeToString(publicKey)},
+                },
+        })
+}
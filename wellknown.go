@@ -0,0 +1,106 @@
+package main
+
+import (
+        "context"
+        "encoding/json"
+        "fmt"
+        "net/http"
+        "strconv"
+        "strings"
+        "sync"
+        "time"
+)
+
+// Bounds for how long .well-known answers are cached, following the server-server API's suggestions
+const (
+        wellKnownDefaultTTL = 24 * time.Hour   // Without caching headers
+        wellKnownMinTTL     = 5 * time.Minute  // Even if the headers ask for less, as every cycle would fetch it again
+        wellKnownMaxTTL     = 48 * time.Hour   // Even if the headers allow more, so changed delegations are picked up
+        wellKnownMissingTTL = 10 * time.Minute // For servers answering that they have no delegation
+)
+
+// wellKnownEntry is a cached .well-known answer; an empty server means the server has no delegation
+type wellKnownEntry struct {
+        server  string
+        expires time.Time
+}
+
+var (
+        wellKnownMu    sync.Mutex
+        wellKnownCache = make(map[string]wellKnownEntry)
+)
+
+// lookupWellKnown returns the server a server name delegates to in its /.well-known/matrix/server, or "" if it
+// doesn't. Answers are cached; failed requests are not, so a flaky server is asked again next time.
+func lookupWellKnown(ctx context.Context, server string) string {
+        wellKnownMu.Lock()
+        entry, ok := wellKnownCache[server]
+        wellKnownMu.Unlock()
+        if ok && time.Now().Before(entry.expires) {
+                return entry.server
+        }
+
+        url := fmt.Sprintf("https://%s/.well-known/matrix/server", server)
+        // This is probe traffic too, so it goes out through the probe transport (source address, interface, Tor)
+        timeout := probeTimeout(server)
+        wellKnownClient := &http.Client{Transport: newProbeTransport(server, timeout), Timeout: timeout}
+        req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+        if err != nil {
+                return ""
+        }
+        resp, err := wellKnownClient.Do(req)
+        if err != nil {
+                return ""
+        }
+        defer resp.Body.Close()
+
+        var delegated string
+        var ttl time.Duration
+        switch {
+        case resp.StatusCode == http.StatusOK:
+                var result struct {
+                        Server string `json:"m.server"`
+                }
+                if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.Server == "" {
+                        return ""
+                }
+                delegated, ttl = result.Server, wellKnownTTL(resp.Header)
+        case resp.StatusCode == http.StatusNotFound:
+                ttl = wellKnownMissingTTL
+        default:
+                return ""
+        }
+
+        wellKnownMu.Lock()
+        defer wellKnownMu.Unlock()
+        wellKnownCache[server] = wellKnownEntry{server: delegated, expires: time.Now().Add(ttl)}
+        return delegated
+}
+
+// wellKnownTTL returns how long a .well-known answer may be cached, from its Cache-Control or Expires header
+func wellKnownTTL(header http.Header) time.Duration {
+        ttl := wellKnownDefaultTTL
+        if expires, err := http.ParseTime(header.Get("Expires")); err == nil {
+                ttl = time.Until(expires)
+        }
+        // Cache-Control takes precedence over Expires
+        for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+                directive = strings.ToLower(strings.TrimSpace(directive))
+                if directive == "no-cache" || directive == "no-store" {
+                        ttl = 0
+                        break
+                }
+                if strings.HasPrefix(directive, "max-age=") {
+                        if seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil {
+                                ttl = time.Duration(seconds) * time.Second
+                        }
+                }
+        }
+        if ttl < wellKnownMinTTL {
+                return wellKnownMinTTL
+        }
+        if ttl > wellKnownMaxTTL {
+                return wellKnownMaxTTL
+        }
+        return ttl
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "net"
+        "runtime/debug"
+        "sync"
+
+        "maunium.net/go/mautrix"
+)
+
+// hostLimiters cap concurrent probes per federation host, as many server names can delegate to the same one
+var hostLimiters sync.Map
+
+// checkConcurrency returns how many servers are checked at once
+func checkConcurrency() int {
+        if config().Concurrency > 0 {
+                return config().Concurrency
+        }
+        return 10
+}
+
+// roomConcurrency returns how many rooms are checked at once
+func roomConcurrency() int {
+        if config().RoomConcurrency > 0 {
+                return config().RoomConcurrency
+        }
+        return 4
+}
+
+// notifySet records the servers sent to their own notification channels during a cycle, as rooms checked at once
+// can share servers
+type notifySet struct {
+        mu   sync.Mutex
+        sent map[string]bool
+}
+
+// newNotifySet returns an empty set
+func newNotifySet() *notifySet {
+        return &notifySet{sent: make(map[string]bool)}
+}
+
+// first records a notification, reporting whether it wasn't sent yet this cycle
+func (n *notifySet) first(key string) bool {
+        n.mu.Lock()
+        defer n.mu.Unlock()
+        if n.sent[key] {
+                return false
+        }
+        n.sent[key] = true
+        return true
+}
+
+// hostLimiter returns the limiter for probes of a federation host (host:port as resolved by delegation)
+func hostLimiter(matrixServer string) limiter {
+        host, _, err := net.SplitHostPort(matrixServer)
+        if err != nil {
+                host = matrixServer
+        }
+        perHost := config().PerHostConcurrency
+        if perHost <= 0 {
+                perHost = 2
+        }
+        l, _ := hostLimiters.LoadOrStore(host, newLimiter(perHost))
+        return l.(limiter)
+}
+
+// checkServers checks a room's servers the probe budget allows in parallel and returns the status of every server
+// that has one, falling back to the latest result for those left out. Without a plan every server is probed.
+// Ignored servers are skipped.
+func checkServers(ctx context.Context, client *mautrix.Client, room string, servers []string, probe map[string]bool) map[string]string {
+        var mu sync.Mutex
+        statuses := make(map[string]string)
+
+        var wg sync.WaitGroup
+        workers := make(limiter, checkConcurrency())
+        for _, server := range servers {
+                if ctx.Err() != nil {
+                        break
+                }
+                if isIgnored(server) {
+                        continue
+                }
+                if probe != nil && !probe[server] {
+                        if result, ok := latestResult(server); ok {
+                                mu.Lock()
+                                statuses[server] = result.Status
+                                mu.Unlock()
+                        }
+                        continue
+                }
+
+                release, err := workers.acquire(ctx)
+                if err != nil {
+                        break
+                }
+                wg.Add(1)
+                go func(server string) {
+                        defer wg.Done()
+                        defer release()
+                        // A server that crashes its check is left without a status instead of stopping the bot
+                        defer func() {
+                                if r := recover(); r != nil {
+                                        fmt.Printf("Checking server %s failed: %v\n%s", server, r, debug.Stack())
+                                }
+                        }()
+                        status, ok := checkServerOnce(ctx, client, server, room)
+                        if !ok {
+                                return
+                        }
+                        mu.Lock()
+                        statuses[server] = status
+                        mu.Unlock()
+                }(server)
+        }
+        wg.Wait()
+        return statuses
+}